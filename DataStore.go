@@ -16,6 +16,19 @@ var (
 	ErrTokenID    = errors.New("id is missing")
 )
 
+// A DataStore wraps these in its errors, so callers can tell outcomes apart
+// without knowing the store.
+var (
+	// ErrNotFound is wrapped by lookups of a record that does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is wrapped by writes that would break a uniqueness rule,
+	// such as renaming a profile onto another's name.
+	ErrConflict = errors.New("conflict")
+	// ErrAmbiguous is wrapped by DataStore.FindCharacter when more than one
+	// active character matches, as when one is in several profiles.
+	ErrAmbiguous = errors.New("more than one match")
+)
+
 // CharacterClaims is the identity a character record is created from. Its fields
 // are unexported and the only constructor is newCharacterClaims, which takes a
 // jwt.Token that validateAccessToken has already checked against the SSO JWKS.
@@ -40,14 +53,71 @@ type DataStore interface {
 	AllProfiles(ctx context.Context) ([]Profile, error)
 	GetProfile(ctx context.Context, profileID uuid.UUID) (Profile, error)
 	FindProfile(ctx context.Context, profileName string) (Profile, error)
+	// FindCharacter returns the one active character matching the given
+	// fields, across profiles. A character authorized into several profiles
+	// is ambiguous here: more than one match wraps ErrAmbiguous, where earlier
+	// versions returned the first. Look it up with Profile.FindCharacter
+	// instead.
 	FindCharacter(ctx context.Context, characterID int32, characterName string, Owner string) (Profile, Character, error)
-	DeleteProfile(ctx context.Context, profileID uuid.UUID) error
+	// DeleteProfile deletes the profile with its characters and PKCE rows.
+	// With WithRevocation, the characters' refresh tokens are revoked first.
+	DeleteProfile(ctx context.Context, profileID uuid.UUID, opts ...DeleteOption) error
 
+	// GetPKCE returns the row only while it has not expired.
 	GetPKCE(ctx context.Context, pkceID uuid.UUID) (PKCE, error)
+	// FindPKCE returns the row for state even when it has expired, so the
+	// callback can tell an expired authorization from an unknown one; check
+	// PKCEExpired before using it. Rows live until CleanPKCE removes them.
 	FindPKCE(ctx context.Context, state uuid.UUID) (PKCE, error)
+	// CleanPKCE deletes the rows that have expired.
 	CleanPKCE(ctx context.Context) error
 }
 
+// PKCELifetime is how long an authorization waits for its callback.
+const PKCELifetime = 5 * time.Minute
+
+// PKCEExpired reports whether pkce is older than PKCELifetime.
+func PKCEExpired(pkce PKCE) bool {
+	return time.Since(pkce.Time()) > PKCELifetime
+}
+
+// PKCELister is implemented by a DataStore that can list the authorizations
+// still waiting for their callback: the PKCE rows that have not expired,
+// oldest first.
+type PKCELister interface {
+	PendingPKCEs(ctx context.Context) ([]PKCE, error)
+}
+
+// ReturnURLCreator is implemented by a Profile whose PKCE rows can carry a
+// return URL, which their PKCE then reports through ReturnURLHolder. Without
+// it, EVESSO.CreatePKCE drops the URL.
+type ReturnURLCreator interface {
+	// CreatePKCEWithReturnURL is CreatePKCE with a URL the callback redirects
+	// to once the authorization succeeds. The URL is stored as given; the
+	// callback checks it against the configured return hosts.
+	CreatePKCEWithReturnURL(ctx context.Context, returnURL string, referenceData interface{}, scopes ...string) (PKCE, error)
+}
+
+// ReturnURLHolder is implemented by a PKCE that carries a return URL.
+type ReturnURLHolder interface {
+	GetReturnURL() string
+}
+
+// ReturnURL returns the return URL pkce carries, or "".
+func ReturnURL(pkce PKCE) string {
+	if holder, ok := pkce.(ReturnURLHolder); ok {
+		return holder.GetReturnURL()
+	}
+	return ""
+}
+
+// RemoteRefresher is implemented by a Character whose refresh token is held
+// by another process, such as a token server. A token source asks it for the
+// current access token instead of refreshing, and stores nothing.
+type RemoteRefresher interface {
+	RemoteToken(ctx context.Context) (*oauth2.Token, error)
+}
+
 type Profile interface {
 	GetID() uuid.UUID
 	GetName() string
@@ -59,13 +129,18 @@ type Profile interface {
 
 	AllCharacters(ctx context.Context) ([]Character, error)
 	GetCharacter(ctx context.Context, uuid uuid.UUID) (Character, error)
+	// FindCharacter returns the active character of the profile matching the
+	// given fields. Empty fields match anything, and so do no Scopes.
 	FindCharacter(ctx context.Context, characterID int32, characterName string, Owner string, Scopes []string) (Character, error)
 
 	// CreateCharacter persists claims that the caller has already verified. It
 	// must not re-derive identity from token.AccessToken.
 	CreateCharacter(ctx context.Context, claims CharacterClaims, token *oauth2.Token, referenceData interface{}) (Character, error)
 	CreatePKCE(ctx context.Context, referenceData interface{}, scopes ...string) (PKCE, error)
-	Delete(ctx context.Context) error
+	// RevokeAll revokes the refresh token of every character in the profile and
+	// marks them inactive. Failures are collected in a *RevocationError.
+	RevokeAll(ctx context.Context) error
+	Delete(ctx context.Context, opts ...DeleteOption) error
 }
 
 type PKCE interface {
@@ -99,7 +174,9 @@ type Character interface {
 	UpdateRefreshToken(ctx context.Context, RefreshToken string) error
 	UpdateActiveState(ctx context.Context, active bool) error
 	Token() (*oauth2.Token, error)
-	Delete(ctx context.Context) error
+	// Revoke revokes the refresh token at SSO and marks the character inactive.
+	Revoke(ctx context.Context) error
+	Delete(ctx context.Context, opts ...DeleteOption) error
 }
 
 func MatchScopes[T comparable](x, y []T) bool {
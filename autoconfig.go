@@ -2,13 +2,11 @@ package evesso
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
-	"path"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -16,13 +14,12 @@ import (
 	"github.com/lestrrat-go/httprc/v3"
 	"github.com/lestrrat-go/jwx/v3/jwk"
 	"github.com/lestrrat-go/jwx/v3/jwt"
-	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/oauth2"
-
-	"github.com/ferocious-space/evesso/internal/utils"
 )
 
-type EVESSO struct {
+// Metadata is the SSO authorization server metadata document published at
+// AUTOCONFIG_URL.
+type Metadata struct {
 	Issuer                                     string   `json:"issuer,omitempty"`
 	AuthorizationEndpoint                      string   `json:"authorization_endpoint,omitempty"`
 	TokenEndpoint                              string   `json:"token_endpoint,omitempty"`
@@ -33,68 +30,178 @@ type EVESSO struct {
 	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported,omitempty"`
 	TokenEndpointAuthSigningAlgValuesSupported []string `json:"token_endpoint_auth_signing_alg_values_supported,omitempty"`
 	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+type EVESSO struct {
+	Metadata
 
 	refresher *jwk.Cache
-	cfg       *appConfig
+	cfg       *Config
 	client    *http.Client
+	issuers   []string
+	log       logr.Logger
+	renderer  Renderer
+	hooks     hooks
+	jobs      scheduler
+	jwks      jwksStatus
+	retry     retryPolicy
+	discovery discoveryStatus
+	counts    characterCount
+	documents DocumentCache
+	intervals intervals
+	telemetry *telemetry
 
 	store DataStore
 	ctx   context.Context
 }
 
+// AutoConfig builds an EVESSO from a configuration file against EVE SSO. It is
+// New with WithConfigFile, WithStore and WithHTTPClient. cfgpath is required;
+// to configure from the environment alone, use New without WithConfigFile.
 func AutoConfig(ctx context.Context, cfgpath string, store DataStore, client *http.Client) (*EVESSO, error) {
-	if client == nil {
-		client = &http.Client{Timeout: 5 * time.Minute}
+	if cfgpath == "" {
+		return nil, errors.New("evesso: AutoConfig needs a configuration file")
 	}
+	return New(ctx, WithConfigFile(cfgpath), WithStore(store), WithHTTPClient(client))
+}
+
+// New builds an EVESSO: it loads and validates the configuration, sets up the store,
+// discovers the SSO metadata unless WithMetadata supplied it, and registers the
+// JWKS for periodic refetching. With a DocumentCache, a discovery document or
+// JWKS that cannot be fetched is taken from the cache instead.
+func New(ctx context.Context, opts ...Option) (*EVESSO, error) {
+	o := &options{intervals: defaultIntervals, retry: defaultRetry}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.store == nil {
+		return nil, errors.New("evesso: no DataStore configured")
+	}
+	if o.client == nil {
+		o.client = &http.Client{Timeout: 5 * time.Minute}
+	}
+
 	item := new(EVESSO)
-	item.client = client
-	item.cfg = new(appConfig)
+	item.client = o.client
 	item.ctx = ctx
-	if err := item.cfg.Load(cfgpath); err != nil {
-		return nil, err
+	item.log = logr.FromContextOrDiscard(ctx)
+	if o.log != nil {
+		item.log = *o.log
 	}
-	err := store.Setup(ctx, item.cfg.DSN)
-	if err != nil {
-		return nil, err
+	item.issuers = VALID_ISSUERS
+	item.intervals = o.intervals
+	item.retry = o.retry
+	item.renderer = o.renderer
+	if item.renderer == nil {
+		item.renderer = DefaultRenderer
 	}
-	item.store = store
-	issuer, err := url.Parse(path.Join(ISSUER, AUTOCONFIG_URL))
+	telemetry, err := newTelemetry(o.tracers, o.meters)
 	if err != nil {
 		return nil, err
 	}
-	issuer.Scheme = "https"
-	withContext, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer.String(), nil)
-	if err != nil {
-		return nil, err
+	item.telemetry = telemetry
+	item.jwks.errors = telemetry.jwksErrors
+	if o.config != nil {
+		cfg := *o.config
+		item.cfg = &cfg
+		if o.secrets != nil {
+			if err := item.cfg.LoadSecrets(ctx, o.secrets); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		cfg, err := LoadConfig(ctx, o.configPath, o.secrets)
+		if err != nil {
+			return nil, err
+		}
+		item.cfg = cfg
 	}
-	resp, err := client.Do(withContext)
-	if err != nil {
+
+	if err := validateConfig(item.cfg, o.store); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	data, err := io.ReadAll(resp.Body)
+
+	if ts, ok := o.store.(TracerSetter); ok && o.tracers != nil {
+		ts.SetTracerProvider(o.tracers)
+	}
+	err = o.store.Setup(logr.NewContext(ctx, item.log), item.cfg.DSN)
 	if err != nil {
 		return nil, err
 	}
-	if err = json.Unmarshal(data, &item); err != nil {
-		return nil, err
+	item.store = o.store
+	item.documents = o.documents
+	if dc, ok := o.store.(DocumentCache); ok && item.documents == nil {
+		item.documents = dc
+	}
+	if rs, ok := o.store.(RevokerSetter); ok {
+		rs.SetRevoker(item)
+	}
+
+	discovery := ISSUER_URL
+	if o.issuer != "" {
+		issuer, err := url.Parse(o.issuer)
+		if err != nil {
+			return nil, err
+		}
+		if issuer.Scheme == "" || issuer.Host == "" {
+			return nil, fmt.Errorf("evesso: issuer %q is not an absolute URL", o.issuer)
+		}
+		discovery = o.issuer
+		item.issuers = append([]string{issuer.Host, strings.TrimSuffix(issuer.String(), "/")}, VALID_ISSUERS...)
 	}
-	item.refresher, err = jwk.NewCache(ctx, httprc.NewClient(httprc.WithHTTPClient(client)))
+	if o.metadata != nil {
+		if err = item.validateMetadata(*o.metadata); err != nil {
+			return nil, err
+		}
+		item.Metadata = *o.metadata
+	} else {
+		if err = item.discover(ctx, discovery); err != nil {
+			return nil, err
+		}
+	}
+
+	jwksClient := item.jwks.client(item.client)
+	item.refresher, err = jwk.NewCache(ctx, httprc.NewClient(httprc.WithHTTPClient(jwksClient)))
 	if err != nil {
 		return nil, err
 	}
 	if err = item.refresher.Register(
 		ctx, item.JwksURI,
-		jwk.WithHTTPClient(client),
-		jwk.WithConstantInterval(5*time.Minute),
+		jwk.WithHTTPClient(jwksClient),
+		jwk.WithConstantInterval(o.intervals.jwks),
+		jwk.WithWaitReady(false),
 	); err != nil {
 		return nil, err
 	}
+	if err = item.awaitJWKS(ctx); err != nil {
+		return nil, err
+	}
 	return item, nil
 }
-func (r *EVESSO) AppConfig() *appConfig {
+
+// validateConfig runs cfg.Validate and, if store can, checks the DSN too.
+func validateConfig(cfg *Config, store DataStore) error {
+	errs := cfg.validate()
+	if v, ok := store.(DSNValidator); ok {
+		if err := v.ValidateDSN(cfg.DSN); err != nil {
+			errs.add("dsn", err)
+		}
+	}
+	if len(errs.Fields) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (r *EVESSO) AppConfig() *Config {
 	return r.cfg
 }
+
+// clientContext carries the configured HTTP client to oauth2, which otherwise
+// falls back to http.DefaultClient.
+func (r *EVESSO) clientContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, r.client)
+}
 func (r *EVESSO) oAuth2(scopes ...string) *oauth2.Config {
 	return &oauth2.Config{
 		ClientID:     r.cfg.Key,
@@ -114,230 +221,74 @@ func (r *EVESSO) Store() DataStore {
 
 // verify checks an access token against the SSO JWKS. Character identity comes
 // from the token it returns, never from an unverified parse of the same string.
-func (r *EVESSO) verify(ctx context.Context, accessToken string) (jwt.Token, error) {
-	ks, err := r.refresher.Lookup(ctx, r.JwksURI)
+func (r *EVESSO) verify(ctx context.Context, accessToken string) (_ jwt.Token, err error) {
+	ctx, span := r.telemetry.start(ctx, "evesso.validate")
+	defer func() { end(span, err) }()
+	ks, err := r.keys(ctx, accessToken)
 	if err != nil {
 		return nil, err
 	}
-	return validateAccessToken(ks, r.cfg.Key, accessToken)
+	return validateAccessToken(ks, r.issuers, r.cfg.Key, accessToken)
+}
+
+// lookupJWKS returns the cached key set.
+func (r *EVESSO) lookupJWKS(ctx context.Context) (_ jwk.Set, err error) {
+	ctx, span := r.telemetry.start(ctx, "evesso.jwks.lookup")
+	defer func() { end(span, err) }()
+	return r.refresher.Lookup(ctx, r.JwksURI)
 }
 func (r *EVESSO) TokenSource(profileID uuid.UUID, CharacterName string, Scopes ...string) (*ssoTokenSource, error) {
 	return &ssoTokenSource{
-		token:       nil,
-		ctx:         context.WithValue(r.ctx, oauth2.HTTPClient, r.client),
-		oauthConfig: r.oAuth2(Scopes...),
-		jwkfn: func() (jwk.Set, error) {
-			return r.refresher.Lookup(r.ctx, r.JwksURI)
-		},
+		token:         nil,
+		ctx:           r.clientContext(r.ctx),
+		oauthConfig:   r.oAuth2(Scopes...),
+		jwkfn:         r.keys,
+		issuers:       r.issuers,
 		store:         r.store,
+		sso:           r,
 		profileID:     profileID,
 		characterName: CharacterName,
 	}, nil
 }
 func (r *EVESSO) CharacterSource(character Character) (*ssoTokenSource, error) {
 	return &ssoTokenSource{
-		token:       nil,
-		ctx:         context.WithValue(r.ctx, oauth2.HTTPClient, r.client),
-		oauthConfig: r.oAuth2(character.GetScopes()...),
-		jwkfn: func() (jwk.Set, error) {
-			return r.refresher.Lookup(r.ctx, r.JwksURI)
-		},
+		token:         nil,
+		ctx:           r.clientContext(r.ctx),
+		oauthConfig:   r.oAuth2(character.GetScopes()...),
+		jwkfn:         r.keys,
+		issuers:       r.issuers,
 		store:         r.store,
+		sso:           r,
 		profileID:     character.GetProfileID(),
 		characterName: character.GetCharacterName(),
 		character:     character,
 	}, nil
 }
-func (r *EVESSO) AuthUrl(pkce PKCE) string {
-	return r.oAuth2(pkce.GetScopes()...).AuthCodeURL(
-		pkce.GetState().String(),
-		oauth2.AccessTypeOffline,
-		oauth2.SetAuthURLParam("code_challenge", pkce.GetCodeChallange()),
-		oauth2.SetAuthURLParam("code_challenge_method", pkce.GetCodeChallangeMethod()),
-	)
-}
-func (r *EVESSO) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	encoder := json.NewEncoder(w)
-	code := req.FormValue("code")
-	state := req.FormValue("state")
-	stateID, err := uuid.Parse(state)
-	if err != nil {
-		//we have no state for this request, discard it
-		return
-	}
-	pkce, err := r.store.FindPKCE(req.Context(), stateID)
-	if err != nil {
-		//we have no state for this request, discard it
-		return
-	}
-	profile, err := pkce.GetProfile(req.Context())
-	if err != nil {
-		return
-	}
-	// delete the state as we are handling it at the moment
-	err = pkce.Destroy(req.Context())
-	if err != nil {
-		return
-	}
-	// check if more than 5 mins passed
-	if time.Since(pkce.Time()) > 5*time.Minute {
-		_ = encoder.Encode("authentication timeout, please try again")
-		return
-	}
 
-	// get the token
-	token, err := r.oAuth2().Exchange(
-		r.ctx,
-		code,
-		oauth2.SetAuthURLParam("code_verifier", pkce.GetCodeVerifier()),
-	)
-	if err != nil {
-		// token exchange failed ?
-		_ = encoder.Encode(err)
-		return
-	}
-	// extract character from the verified token
-	jt, err := r.verify(req.Context(), token.AccessToken)
-	if err != nil {
-		_ = encoder.Encode(err)
-		return
+// CreatePKCE starts an authorization on profile and counts it in the
+// evesso.pkce.created metric. returnURL is stored when the profile is a
+// ReturnURLCreator; otherwise, or when it is "", the callback redirects to the
+// configured redirect, as it does for a URL it rejects.
+func (r *EVESSO) CreatePKCE(ctx context.Context, profile Profile, returnURL string, referenceData interface{}, scopes ...string) (PKCE, error) {
+	var pkce PKCE
+	var err error
+	if creator, ok := profile.(ReturnURLCreator); ok && returnURL != "" {
+		pkce, err = creator.CreatePKCEWithReturnURL(ctx, returnURL, referenceData, scopes...)
+	} else {
+		pkce, err = profile.CreatePKCE(ctx, referenceData, scopes...)
 	}
-	claims, err := newCharacterClaims(jt)
 	if err != nil {
-		_ = encoder.Encode(err)
-		return
-	}
-	_, err = profile.CreateCharacter(req.Context(), claims, token, pkce.GetReferenceData())
-	if err != nil {
-		_ = encoder.Encode(err)
-		return
+		return nil, err
 	}
-	_ = r.store.CleanPKCE(req.Context())
-	//https://login.eveonline.com/Account/LogOff?ReturnUrl=https%3A%2F%2Fwww.fuzzwork.co.uk%2Fauth/login.php
-	http.Redirect(w, req, r.AppConfig().Redirect, http.StatusFound)
+	r.telemetry.pkceCreated.Add(ctx, 1)
+	return pkce, nil
 }
-func (r *EVESSO) LocalhostAuth(urlPath string) error {
-	if err := utils.OSExec(urlPath); err != nil {
-		return err
-	}
-
-	callback, err := url.Parse(r.AppConfig().Callback)
-	if err != nil {
-		return err
-	}
-	stopChannel := make(chan struct{}, 1)
-	errChannel := make(chan error, 1)
-
-	mux := http.NewServeMux()
-	mux.HandleFunc(
-		callback.Path, func(w http.ResponseWriter, req *http.Request) {
-			defer func() {
-				stopChannel <- struct{}{}
-			}()
-			ctx := logr.NewContext(req.Context(), logr.FromContextOrDiscard(r.ctx))
-
-			code := req.FormValue("code")
-			state := req.FormValue("state")
-			stateID, err := uuid.Parse(state)
-			if err != nil {
-				// we have no state for this request, discard it
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			pkce, err := r.store.FindPKCE(ctx, stateID)
-			if err != nil {
-				// we have no state for this request, discard it
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			profile, err := pkce.GetProfile(ctx)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			err = pkce.Destroy(ctx)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			// check if more than 5 mins passed
-			if time.Since(pkce.Time()) > 5*time.Minute {
-				http.Error(w, "timeout ", http.StatusInternalServerError)
-				return
-			}
 
-			token, err := r.oAuth2().Exchange(
-				ctx,
-				code,
-				oauth2.SetAuthURLParam("code_verifier", pkce.GetCodeVerifier()),
-			)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			jt, err := r.verify(ctx, token.AccessToken)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			claims, err := newCharacterClaims(jt)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			_, err = profile.CreateCharacter(ctx, claims, token, pkce.GetReferenceData())
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			_ = r.store.CleanPKCE(ctx)
-			_ = json.NewEncoder(w).Encode(jt)
-		},
+func (r *EVESSO) AuthUrl(pkce PKCE) string {
+	return r.oAuth2(pkce.GetScopes()...).AuthCodeURL(
+		pkce.GetState().String(),
+		oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("code_challenge", pkce.GetCodeChallange()),
+		oauth2.SetAuthURLParam("code_challenge_method", pkce.GetCodeChallangeMethod()),
 	)
-
-	srv := &http.Server{Handler: mux}
-
-	if callback.Port() == "" && callback.Scheme != "http" && r.AppConfig().Autocert {
-		manager := &autocert.Manager{
-			Prompt:     autocert.AcceptTOS,
-			HostPolicy: autocert.HostWhitelist(callback.Hostname()),
-			Cache:      autocert.DirCache(r.AppConfig().AutocertCache),
-		}
-		srv.TLSConfig = manager.TLSConfig()
-	}
-
-	go func() {
-		var serveErr error
-		switch {
-		case callback.Port() == "" && callback.Scheme == "http":
-			srv.Addr = ":80"
-			serveErr = srv.ListenAndServe()
-		case callback.Port() == "":
-			srv.Addr = ":443"
-			if r.AppConfig().Autocert {
-				serveErr = srv.ListenAndServeTLS("", "")
-			} else {
-				serveErr = srv.ListenAndServeTLS(r.AppConfig().TLSCert, r.AppConfig().TLSKey)
-			}
-		default:
-			srv.Addr = fmt.Sprintf(":%s", callback.Port())
-			serveErr = srv.ListenAndServe()
-		}
-		if errors.Is(serveErr, http.ErrServerClosed) {
-			serveErr = nil
-		}
-		errChannel <- serveErr
-	}()
-
-	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Minute)
-	defer cancel()
-
-	select {
-	case serveErr := <-errChannel:
-		return serveErr
-	case <-stopChannel:
-		return srv.Shutdown(ctx)
-	case <-ctx.Done():
-		return srv.Shutdown(ctx)
-	}
 }
@@ -0,0 +1,288 @@
+package evesso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// batch landing page routes, served next to the callback path
+const (
+	batchPath      = "/batch"
+	batchStartPath = "/batch/start"
+	batchDonePath  = "/batch/done"
+)
+
+// BatchTarget is one button on the LocalhostBatchAuth landing page: every click
+// starts a fresh authorization of Scopes on Profile.
+type BatchTarget struct {
+	// Label is the button text, by default the profile name.
+	Label         string
+	Profile       Profile
+	Scopes        []string
+	ReferenceData interface{}
+}
+
+// BatchReport is called once per callback that reaches LocalhostBatchAuth,
+// with the authorization or the *CallbackError. Calls never overlap.
+type BatchReport func(auth *Authorization, err error)
+
+// LocalhostBatchAuth authorizes any number of characters on one callback
+// server. It presents the URL of a landing page with a button per target,
+// each starting a new authorization, and returns to the page after every
+// callback. It runs until the page's "done" action or until ctx is done,
+// and returns the characters authorized so far, with ctx's error in the
+// latter case. Unlike LocalhostAuthContext it has no default deadline.
+func (r *EVESSO) LocalhostBatchAuth(ctx context.Context, targets []BatchTarget, report BatchReport, opts ...LocalhostOption) ([]*Authorization, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("no batch targets")
+	}
+	for i, target := range targets {
+		if target.Profile == nil {
+			return nil, fmt.Errorf("batch target %d has no profile", i)
+		}
+	}
+	o := new(localhostOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+	if len(o.present) == 0 {
+		WithBrowser()(o)
+	}
+
+	callback, err := url.Parse(r.AppConfig().Callback)
+	if err != nil {
+		return nil, err
+	}
+	token := make([]byte, 32)
+	if _, err = rand.Read(token); err != nil {
+		return nil, err
+	}
+	b := &batch{
+		sso:     r,
+		log:     r.logger(ctx),
+		targets: targets,
+		report:  report,
+		token:   base64.RawURLEncoding.EncodeToString(token),
+		done:    make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(callback.Path, b.callback)
+	mux.HandleFunc(batchPath, b.landing)
+	mux.HandleFunc(batchStartPath, b.start)
+	mux.HandleFunc(batchDonePath, b.finish)
+
+	srv, listener, err := r.localhostServer(callback, o.bind, mux)
+	if err != nil {
+		b.log.Error(err, "callback server could not listen", "error_class", errorClass(err))
+		return nil, err
+	}
+	b.log.V(logEvents).Info("serving batch authorization page", "addr", listener.Addr().String())
+	defer func() {
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+	errChannel := r.serveLocalhost(srv, listener, callback)
+
+	landing := *callback
+	landing.Path, landing.RawQuery, landing.Fragment = batchPath, url.Values{"token": {b.token}}.Encode(), ""
+	for _, present := range o.present {
+		if err = present(landing.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	select {
+	case serveErr := <-errChannel:
+		b.log.Error(serveErr, "callback server failed", "error_class", errorClass(serveErr))
+		return b.authorized(), serveErr
+	case <-b.done:
+		return b.authorized(), nil
+	case <-ctx.Done():
+		return b.authorized(), ctx.Err()
+	}
+}
+
+// batch is the state of one LocalhostBatchAuth session.
+type batch struct {
+	sso     *EVESSO
+	log     logr.Logger
+	targets []BatchTarget
+	report  BatchReport
+	// token is in the landing page URL and its forms, so that only whoever
+	// was handed the URL can start authorizations or end the batch; the
+	// server may listen on every interface.
+	token string
+
+	mu       sync.Mutex
+	outcomes []*Outcome
+	done     chan struct{}
+	once     sync.Once
+}
+
+// allowed checks the method and token of req, answering it if they are wrong.
+func (b *batch) allowed(w http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(req.FormValue("token")), []byte(b.token)) != 1 {
+		http.Error(w, "open the link the application presented", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (b *batch) start(w http.ResponseWriter, req *http.Request) {
+	if !b.allowed(w, req, http.MethodPost) {
+		return
+	}
+	i, err := strconv.Atoi(req.FormValue("target"))
+	if err != nil || i < 0 || i >= len(b.targets) {
+		http.NotFound(w, req)
+		return
+	}
+	target := b.targets[i]
+	pkce, err := b.sso.CreatePKCE(req.Context(), target.Profile, "", target.ReferenceData, target.Scopes...)
+	if err != nil {
+		b.log.Error(err, "batch authorization could not start", "profile_id", target.Profile.GetID(), "error_class", errorClass(err))
+		http.Error(w, "the authorization could not be started", http.StatusInternalServerError)
+		return
+	}
+	b.log.V(logDetail).Info("authorization started",
+		"profile_id", target.Profile.GetID(),
+		"state", pkce.GetState(),
+		"scope_count", len(target.Scopes),
+	)
+	http.Redirect(w, req, b.sso.AuthUrl(pkce), http.StatusFound)
+}
+
+func (b *batch) callback(w http.ResponseWriter, req *http.Request) {
+	ctx := logr.NewContext(req.Context(), b.log)
+	outcome := b.sso.complete(ctx, CauseLocalhost, req.FormValue("code"), req.FormValue("state"))
+	b.mu.Lock()
+	b.outcomes = append(b.outcomes, outcome)
+	if b.report != nil {
+		b.report(outcome.authorization())
+	}
+	b.mu.Unlock()
+	http.Redirect(w, req, batchPath+"?"+url.Values{"token": {b.token}}.Encode(), http.StatusSeeOther)
+}
+
+// finish ends the batch with the Renderer: a BatchRenderer gets every
+// outcome, any other the last one.
+func (b *batch) finish(w http.ResponseWriter, req *http.Request) {
+	if !b.allowed(w, req, http.MethodPost) {
+		return
+	}
+	b.mu.Lock()
+	outcomes := slices.Clone(b.outcomes)
+	b.mu.Unlock()
+	switch renderer := b.sso.renderer.(type) {
+	case BatchRenderer:
+		renderer.RenderBatch(w, req, outcomes)
+	default:
+		if len(outcomes) == 0 {
+			renderBatchDefault(w, req, outcomes)
+		} else {
+			renderer.Render(w, req, outcomes[len(outcomes)-1])
+		}
+	}
+	b.once.Do(func() { close(b.done) })
+}
+
+func (b *batch) landing(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != batchPath {
+		http.NotFound(w, req)
+		return
+	}
+	if !b.allowed(w, req, http.MethodGet) {
+		return
+	}
+	type button struct {
+		Index int
+		Label string
+	}
+	type result struct {
+		OK      bool
+		Message string
+	}
+	page := struct {
+		Buttons  []button
+		Results  []result
+		DonePath string
+		Start    string
+		Token    string
+	}{DonePath: batchDonePath, Start: batchStartPath, Token: b.token}
+	for i, target := range b.targets {
+		label := target.Label
+		if label == "" {
+			label = target.Profile.GetName()
+		}
+		page.Buttons = append(page.Buttons, button{Index: i, Label: label})
+	}
+	b.mu.Lock()
+	for _, outcome := range b.outcomes {
+		if outcome.Kind == OutcomeSuccess {
+			page.Results = append(page.Results, result{OK: true, Message: outcome.Claims.CharacterName() + " authorized"})
+		} else {
+			page.Results = append(page.Results, result{Message: "authorization failed: " + outcome.Kind.String()})
+		}
+	}
+	b.mu.Unlock()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = batchPage.Execute(w, page)
+}
+
+// authorized returns the successful authorizations in arrival order.
+func (b *batch) authorized() []*Authorization {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []*Authorization
+	for _, outcome := range b.outcomes {
+		if auth, err := outcome.authorization(); err == nil {
+			out = append(out, auth)
+		}
+	}
+	return out
+}
+
+var batchPage = template.Must(template.New("batch").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Authorize characters</title></head>
+<body style="font-family:sans-serif;max-width:32em;margin:4em auto">
+<h1>Authorize characters</h1>
+<p>Each button starts a new EVE SSO login. Log in with one character at a time; you come back here after each.</p>
+<ul>
+{{- range .Buttons}}
+<li><form method="post" action="{{$.Start}}"><input type="hidden" name="token" value="{{$.Token}}"><input type="hidden" name="target" value="{{.Index}}"><button type="submit">{{.Label}}</button></form></li>
+{{- end}}
+</ul>
+{{- if .Results}}
+<h2>So far</h2>
+<ul>
+{{- range .Results}}
+<li>{{if .OK}}&#10003;{{else}}&#10007;{{end}} {{.Message}}</li>
+{{- end}}
+</ul>
+{{- end}}
+<form method="post" action="{{.DonePath}}"><input type="hidden" name="token" value="{{.Token}}"><button type="submit">Done</button></form>
+</body>
+</html>
+`))
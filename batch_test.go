@@ -0,0 +1,131 @@
+package evesso_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+func TestLocalhostBatchAuth(t *testing.T) {
+	cfg := evessotest.Config()
+	cfg.Callback = freeCallback(t)
+	srv, sso, store := evessotest.NewSSO(t, evesso.WithConfig(cfg))
+	profile, err := store.NewProfile(context.Background(), "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		auths []*evesso.Authorization
+		err   error
+	}
+	landingURL := make(chan string, 1)
+	results := make(chan result, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go func() {
+		auths, err := sso.LocalhostBatchAuth(ctx, []evesso.BatchTarget{{Profile: profile, Scopes: []string{"publicData"}}}, nil,
+			evesso.WithBindAddress("127.0.0.1"),
+			evesso.WithURLHandler(func(authURL string) { landingURL <- authURL }),
+		)
+		results <- result{auths, err}
+	}()
+	var landing *url.URL
+	select {
+	case u := <-landingURL:
+		if landing, err = url.Parse(u); err != nil {
+			t.Fatal(err)
+		}
+	case r := <-results:
+		t.Fatalf("LocalhostBatchAuth returned early: %v", r.err)
+	}
+	token := landing.Query().Get("token")
+	base := landing.Scheme + "://" + landing.Host
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		form   url.Values
+		status int
+	}{
+		{"landing page", http.MethodGet, "/batch?token=" + url.QueryEscape(token), nil, http.StatusOK},
+		{"landing page without token", http.MethodGet, "/batch", nil, http.StatusForbidden},
+		{"landing page with another token", http.MethodGet, "/batch?token=nope", nil, http.StatusForbidden},
+		{"start by GET", http.MethodGet, "/batch/start?token=" + url.QueryEscape(token) + "&target=0", nil, http.StatusMethodNotAllowed},
+		{"start with another token", http.MethodPost, "/batch/start", url.Values{"token": {"nope"}, "target": {"0"}}, http.StatusForbidden},
+		{"start an unknown target", http.MethodPost, "/batch/start", url.Values{"token": {token}, "target": {"1"}}, http.StatusNotFound},
+		{"done by GET", http.MethodGet, "/batch/done?token=" + url.QueryEscape(token), nil, http.StatusMethodNotAllowed},
+		{"done with another token", http.MethodPost, "/batch/done", url.Values{"token": {"nope"}}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp *http.Response
+			if tt.form != nil {
+				resp, err = client.PostForm(base+tt.path, tt.form)
+			} else {
+				resp, err = client.Get(base + tt.path)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+			}
+		})
+	}
+
+	// a full round: start, approve at SSO, back on the landing page, done
+	resp, err := client.PostForm(base+"/batch/start", url.Values{"token": {token}, "target": {"0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || !strings.HasPrefix(resp.Header.Get("Location"), srv.URL) {
+		t.Fatalf("start = %d to %q, want a redirect to SSO", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	callback, err := srv.Approve(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = client.Get(callback); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	want := "/batch?" + url.Values{"token": {token}}.Encode()
+	if resp.Header.Get("Location") != want {
+		t.Fatalf("callback redirects to %q, want %q", resp.Header.Get("Location"), want)
+	}
+	if resp, err = client.PostForm(base+"/batch/done", url.Values{"token": {token}}); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	r := <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if len(r.auths) != 1 || r.auths[0].Claims.CharacterName() != evessotest.Pilot.Name {
+		t.Fatalf("authorized %v, want %s", r.auths, evessotest.Pilot.Name)
+	}
+	if _, err = profile.FindCharacter(context.Background(), evessotest.Pilot.ID, evessotest.Pilot.Name, evessotest.Pilot.Owner, nil); err != nil {
+		t.Errorf("character not stored: %v", err)
+	}
+}
+
+func TestLocalhostBatchAuthNoTargets(t *testing.T) {
+	cfg := evessotest.Config()
+	cfg.Callback = freeCallback(t)
+	_, sso, _ := evessotest.NewSSO(t, evesso.WithConfig(cfg))
+	if _, err := sso.LocalhostBatchAuth(context.Background(), nil, nil, evesso.WithURLHandler(func(string) {})); err == nil {
+		t.Fatal("no error without targets")
+	}
+}
@@ -0,0 +1,122 @@
+package evesso
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+// complete finishes an authorization from the code and state SSO sent to the
+// callback: it looks up and consumes the PKCE row, exchanges the code, verifies
+// the token and persists the character. Every callback path goes through it,
+// and raises OnAuthorized with cause on success.
+func (r *EVESSO) complete(ctx context.Context, cause Cause, code, state string) (outcome *Outcome) {
+	defer func() { r.logOutcome(ctx, cause, state, outcome) }()
+	stateID, err := uuid.Parse(state)
+	if err != nil {
+		return &Outcome{Kind: OutcomeUnknownState, Err: err}
+	}
+	pkce, err := r.store.FindPKCE(ctx, stateID)
+	if err != nil {
+		// we have no state for this request, discard it
+		return &Outcome{Kind: OutcomeUnknownState, Err: err}
+	}
+	outcome = &Outcome{PKCE: pkce}
+	outcome.Profile, err = pkce.GetProfile(ctx)
+	if err != nil {
+		return outcome.fail(OutcomeStorageFailed, err)
+	}
+	// delete the state as we are handling it at the moment
+	if err = pkce.Destroy(ctx); err != nil {
+		return outcome.fail(OutcomeStorageFailed, err)
+	}
+	if PKCEExpired(pkce) {
+		r.telemetry.pkceExpired.Add(ctx, 1)
+		return outcome.fail(OutcomeExpired, errors.New("authorization expired"))
+	}
+
+	token, err := r.exchange(ctx, code, pkce)
+	if err != nil {
+		return outcome.fail(OutcomeExchangeFailed, err)
+	}
+	// extract character from the verified token
+	jt, err := r.verify(ctx, token.AccessToken)
+	if err != nil {
+		return outcome.fail(OutcomeVerificationFailed, err)
+	}
+	outcome.Claims, err = newCharacterClaims(jt)
+	if err != nil {
+		return outcome.fail(OutcomeVerificationFailed, err)
+	}
+	outcome.Character, err = outcome.Profile.CreateCharacter(ctx, outcome.Claims, token, pkce.GetReferenceData())
+	if err != nil {
+		return outcome.fail(OutcomeStorageFailed, err)
+	}
+	_ = r.store.CleanPKCE(ctx)
+	outcome.Kind = OutcomeSuccess
+	r.emit(ctx, eventAuthorized, Event{
+		Profile:       outcome.Profile,
+		Character:     outcome.Character,
+		ReferenceData: pkce.GetReferenceData(),
+		Cause:         cause,
+	})
+	return outcome
+}
+
+// exchange trades an authorization code for a token with the PKCE verifier.
+func (r *EVESSO) exchange(ctx context.Context, code string, pkce PKCE) (_ *oauth2.Token, err error) {
+	ctx, span := r.telemetry.start(ctx, "evesso.exchange")
+	defer func() { end(span, err) }()
+	token, err := r.oAuth2().Exchange(
+		r.clientContext(ctx),
+		code,
+		oauth2.SetAuthURLParam("code_verifier", pkce.GetCodeVerifier()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	r.telemetry.obtained(ctx, token)
+	return token, nil
+}
+
+// ServeHTTP handles the SSO callback. A successful authorization redirects to
+// the PKCE row's return URL if it passes CheckReturnURL, else to the configured
+// redirect URL, or renders the success page if there is neither; anything
+// else is rendered by the configured Renderer.
+func (r *EVESSO) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	outcome := r.complete(req.Context(), CauseCallback, req.FormValue("code"), req.FormValue("state"))
+	if outcome.Kind == OutcomeSuccess {
+		if target := r.successRedirect(outcome); target != "" {
+			//https://login.eveonline.com/Account/LogOff?ReturnUrl=https%3A%2F%2Fwww.fuzzwork.co.uk%2Fauth/login.php
+			http.Redirect(w, req, target, http.StatusFound)
+			return
+		}
+	}
+	r.renderer.Render(w, req, outcome)
+}
+
+// CompleteRequest is ServeHTTP for handlers that answer the browser
+// themselves: it completes the callback in req and returns the authorization
+// with where ServeHTTP would have redirected, or a *CallbackError.
+func (r *EVESSO) CompleteRequest(req *http.Request) (auth *Authorization, redirect string, err error) {
+	outcome := r.complete(req.Context(), CauseCallback, req.FormValue("code"), req.FormValue("state"))
+	if auth, err = outcome.authorization(); err != nil {
+		return nil, "", err
+	}
+	return auth, r.successRedirect(outcome), nil
+}
+
+// successRedirect picks where a successful callback sends the browser. An
+// unacceptable return URL falls back to the configured redirect.
+func (r *EVESSO) successRedirect(outcome *Outcome) string {
+	if raw := ReturnURL(outcome.PKCE); raw != "" {
+		target, err := r.expandReturnURL(raw, outcome.Character.GetCharacterID(), outcome.Profile.GetID().String())
+		if err == nil {
+			return target
+		}
+	}
+	return r.cfg.Redirect
+}
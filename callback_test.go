@@ -0,0 +1,212 @@
+package evesso_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+func TestServeHTTP(t *testing.T) {
+	tests := []struct {
+		name string
+		// callback starts an authorization and returns the callback SSO
+		// sends the browser to
+		callback   func(t *testing.T, srv *evessotest.Server, sso *evesso.EVESSO, profile evesso.Profile) string
+		status     int
+		location   string
+		characters int
+	}{
+		{
+			name: "success redirects to the configured redirect",
+			callback: func(t *testing.T, srv *evessotest.Server, sso *evesso.EVESSO, profile evesso.Profile) string {
+				pkce, err := sso.CreatePKCE(context.Background(), profile, "", nil, "publicData")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return approve(t, srv, sso.AuthUrl(pkce))
+			},
+			status:     http.StatusFound,
+			location:   "http://localhost/",
+			characters: 1,
+		},
+		{
+			name: "success redirects to the return URL",
+			callback: func(t *testing.T, srv *evessotest.Server, sso *evesso.EVESSO, profile evesso.Profile) string {
+				pkce, err := sso.CreatePKCE(context.Background(), profile, "/characters/{character_id}", nil, "publicData")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return approve(t, srv, sso.AuthUrl(pkce))
+			},
+			status:     http.StatusFound,
+			location:   "/characters/90000001",
+			characters: 1,
+		},
+		{
+			name: "a return URL on another host falls back to the redirect",
+			callback: func(t *testing.T, srv *evessotest.Server, sso *evesso.EVESSO, profile evesso.Profile) string {
+				pkce, err := sso.CreatePKCE(context.Background(), profile, "https://evil.example.com/", nil, "publicData")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return approve(t, srv, sso.AuthUrl(pkce))
+			},
+			status:     http.StatusFound,
+			location:   "http://localhost/",
+			characters: 1,
+		},
+		{
+			name: "a scheme-relative return URL falls back to the redirect",
+			callback: func(t *testing.T, srv *evessotest.Server, sso *evesso.EVESSO, profile evesso.Profile) string {
+				pkce, err := sso.CreatePKCE(context.Background(), profile, "//evil.example.com/", nil, "publicData")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return approve(t, srv, sso.AuthUrl(pkce))
+			},
+			status:     http.StatusFound,
+			location:   "http://localhost/",
+			characters: 1,
+		},
+		{
+			name: "unknown state",
+			callback: func(*testing.T, *evessotest.Server, *evesso.EVESSO, evesso.Profile) string {
+				return "/callback?" + url.Values{"code": {"code"}, "state": {uuid.NewString()}}.Encode()
+			},
+			status: evesso.OutcomeUnknownState.Status(),
+		},
+		{
+			name: "malformed state",
+			callback: func(*testing.T, *evessotest.Server, *evesso.EVESSO, evesso.Profile) string {
+				return "/callback?code=code&state=nope"
+			},
+			status: evesso.OutcomeUnknownState.Status(),
+		},
+		{
+			name: "SSO refuses the code",
+			callback: func(t *testing.T, srv *evessotest.Server, sso *evesso.EVESSO, profile evesso.Profile) string {
+				pkce, err := sso.CreatePKCE(context.Background(), profile, "", nil, "publicData")
+				if err != nil {
+					t.Fatal(err)
+				}
+				callback, err := url.Parse(approve(t, srv, sso.AuthUrl(pkce)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				q := callback.Query()
+				q.Set("code", "forged")
+				callback.RawQuery = q.Encode()
+				return callback.String()
+			},
+			status: evesso.OutcomeExchangeFailed.Status(),
+		},
+		{
+			name: "a state is redeemed once",
+			callback: func(t *testing.T, srv *evessotest.Server, sso *evesso.EVESSO, profile evesso.Profile) string {
+				pkce, err := sso.CreatePKCE(context.Background(), profile, "", nil, "publicData")
+				if err != nil {
+					t.Fatal(err)
+				}
+				callback := approve(t, srv, sso.AuthUrl(pkce))
+				sso.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, callback, nil))
+				return callback
+			},
+			status:     evesso.OutcomeUnknownState.Status(),
+			characters: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, sso, store := evessotest.NewSSO(t)
+			profile, err := store.NewProfile(context.Background(), "default", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			sso.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.callback(t, srv, sso, profile), nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if got := rec.Header().Get("Location"); got != tt.location {
+				t.Errorf("location = %q, want %q", got, tt.location)
+			}
+			characters, err := profile.AllCharacters(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(characters) != tt.characters {
+				t.Errorf("%d characters stored, want %d", len(characters), tt.characters)
+			}
+		})
+	}
+}
+
+// approve has the server approve authURL and returns the callback URL.
+func approve(t *testing.T, srv *evessotest.Server, authURL string) string {
+	t.Helper()
+	callback, err := srv.Approve(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback
+}
+
+func TestRenderer(t *testing.T) {
+	tests := []struct {
+		name     string
+		redirect string
+		// forge replaces the code SSO returned
+		forge  bool
+		status int
+		kind   evesso.OutcomeKind
+		// page is a part of the default page, which must not show errors
+		page string
+	}{
+		{name: "success without a redirect", status: http.StatusOK, kind: evesso.OutcomeSuccess, page: "Test Pilot is authorized."},
+		{name: "exchange failure", redirect: "http://localhost/", forge: true, status: http.StatusBadGateway, kind: evesso.OutcomeExchangeFailed, page: "EVE SSO did not complete the login."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := evessotest.Config()
+			cfg.Redirect = tt.redirect
+			var rendered []evesso.OutcomeKind
+			renderer := evesso.RendererFunc(func(w http.ResponseWriter, req *http.Request, outcome *evesso.Outcome) {
+				rendered = append(rendered, outcome.Kind)
+				evesso.DefaultRenderer.Render(w, req, outcome)
+			})
+			srv, sso, store := evessotest.NewSSO(t, evesso.WithConfig(cfg), evesso.WithRenderer(renderer))
+			profile, err := store.NewProfile(context.Background(), "default", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pkce, err := sso.CreatePKCE(context.Background(), profile, "", nil, "publicData")
+			if err != nil {
+				t.Fatal(err)
+			}
+			callback := approve(t, srv, sso.AuthUrl(pkce))
+			if tt.forge {
+				callback = strings.Replace(callback, "code=", "code=forged", 1)
+			}
+			rec := httptest.NewRecorder()
+			sso.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, callback, nil))
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if len(rendered) != 1 || rendered[0] != tt.kind {
+				t.Errorf("rendered %v, want %v", rendered, tt.kind)
+			}
+			body := rec.Body.String()
+			if !strings.Contains(body, tt.page) || strings.Contains(body, "forged") {
+				t.Errorf("page:\n%s", body)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -14,12 +15,20 @@ import (
 )
 
 func main() {
+	headless := flag.Bool("headless", false, "print the authorization URL instead of opening a browser")
+	qr := flag.Bool("qr", false, "render the authorization URL as a QR code instead of opening a browser; add -headless to print it as well")
+	paste := flag.Bool("paste", false, "paste the callback URL back instead of serving the callback (for SSH sessions)")
+	batch := flag.Bool("batch", false, "authorize any number of characters from a local landing page, until Done is clicked")
+	bind := flag.String("bind", "", "address the callback server listens on (default: all interfaces)")
+	flag.Parse()
+
 	newContext := logr.NewContext(context.Background(), stdr.New(log.New(os.Stdout, "", 0)))
 	config, err := evesso.AutoConfig(newContext, "./config.yaml", &evessopg.PGStore{}, nil)
 	if err != nil {
 		log.Fatalln(err)
 		return
 	}
+	defer config.Close()
 	defaultProfile, err := config.Store().FindProfile(newContext, "default")
 	if err != nil {
 		defaultProfile, err = config.Store().NewProfile(newContext, "default", nil)
@@ -32,6 +41,30 @@ func main() {
 		log.Fatalln(err)
 		return
 	}
+	var opts []evesso.LocalhostOption
+	if *headless {
+		opts = append(opts, evesso.WithPrintURL(os.Stdout))
+	}
+	if *qr {
+		opts = append(opts, evesso.WithQRCode(os.Stdout))
+	}
+	if *bind != "" {
+		opts = append(opts, evesso.WithBindAddress(*bind))
+	}
+	if *batch {
+		target := evesso.BatchTarget{Profile: defaultProfile, Scopes: evesso.ALL_SCOPES}
+		_, err = config.LocalhostBatchAuth(newContext, []evesso.BatchTarget{target}, func(auth *evesso.Authorization, err error) {
+			if err != nil {
+				fmt.Println("failed:", err)
+				return
+			}
+			fmt.Println("authorized:", auth.Character.GetCharacterName())
+		}, opts...)
+		if err != nil {
+			log.Fatalln(err)
+			return
+		}
+	}
 	source, err := config.TokenSource(defaultProfile.GetID(), "Ferocious Bite", evesso.ALL_SCOPES...)
 	if err != nil {
 		log.Fatalln(err)
@@ -43,11 +76,17 @@ func main() {
 			log.Fatalln(err)
 			return
 		}
-		err = config.LocalhostAuth(au)
+		var auth *evesso.Authorization
+		if *paste {
+			auth, err = config.PasteAuth(newContext, au, os.Stdin, os.Stdout)
+		} else {
+			auth, err = config.LocalhostAuthContext(newContext, au, opts...)
+		}
 		if err != nil {
 			log.Fatalln(err)
 			return
 		}
+		fmt.Println("authorized:", auth.Character.GetCharacterName())
 	}
 	fmt.Println("valid:", source.Valid())
 	profiles, err := config.Store().AllProfiles(newContext)
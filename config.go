@@ -1,16 +1,43 @@
 package evesso
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/goccy/go-json"
 	"gopkg.in/yaml.v3"
 )
 
-type appConfig struct {
+// EnvPrefix prefixes the environment variables LoadEnv reads, one per config
+// key: EVESSO_KEY, EVESSO_SECRET, EVESSO_DSN and so on.
+const EnvPrefix = "EVESSO_"
+
+// ErrSecretNotFound is returned by a SecretProvider that has no value for a
+// name, leaving the configured one in place.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretProvider supplies the sensitive configuration values, "secret" and
+// "dsn", from somewhere other than the config file, such as a vault.
+type SecretProvider interface {
+	GetSecret(ctx context.Context, name string) (string, error)
+}
+
+// SecretProviderFunc adapts a function to SecretProvider.
+type SecretProviderFunc func(ctx context.Context, name string) (string, error)
+
+func (f SecretProviderFunc) GetSecret(ctx context.Context, name string) (string, error) {
+	return f(ctx, name)
+}
+
+// Config is the application configuration: the SSO client credentials, the
+// callback and redirect URLs, the DSN and TLS settings for LocalhostAuth.
+type Config struct {
 	// ESI API Key
 	Key string `json:"key" yaml:"key"`
 	// ESI API Secret
@@ -29,9 +56,37 @@ type appConfig struct {
 	TLSCert string `json:"tlscert" yaml:"tlscert"`
 	// TLSKey path to pem Key file to use for https if letsencrypt is disabled
 	TLSKey string `json:"tlskey" yaml:"tlskey"`
+	// ReturnHosts hosts a per-authorization return URL may redirect to
+	ReturnHosts []string `json:"returnhosts" yaml:"returnhosts"`
+}
+
+// LoadConfig builds a Config from layered sources, each overriding the one
+// before it:
+//
+//  1. the YAML or JSON file at path, skipped when path is empty;
+//  2. EVESSO_<KEY> environment variables, e.g. EVESSO_SECRET;
+//  3. EVESSO_<KEY>_FILE environment variables naming a file that holds the
+//     value, as Docker and Kubernetes mount secrets;
+//  4. secrets, if not nil, for "secret" and "dsn".
+func LoadConfig(ctx context.Context, path string, secrets SecretProvider) (*Config, error) {
+	c := new(Config)
+	if path != "" {
+		if err := c.Load(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.LoadEnv(); err != nil {
+		return nil, err
+	}
+	if secrets != nil {
+		if err := c.LoadSecrets(ctx, secrets); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
 }
 
-func (c *appConfig) CallbackURL() *url.URL {
+func (c *Config) CallbackURL() *url.URL {
 	parse, err := url.Parse(c.Callback)
 	if err != nil {
 		return nil
@@ -39,7 +94,7 @@ func (c *appConfig) CallbackURL() *url.URL {
 	return parse
 }
 
-func (c *appConfig) Load(path string) error {
+func (c *Config) Load(path string) error {
 	cfg, err := os.Open(path)
 	if err != nil {
 		return err
@@ -59,3 +114,64 @@ func (c *appConfig) Load(path string) error {
 	}
 	return nil
 }
+
+// LoadEnv overrides c from EVESSO_<KEY> variables, then from the files named
+// by EVESSO_<KEY>_FILE. Values read from files have trailing newlines removed.
+// EVESSO_RETURNHOSTS is a comma separated list.
+func (c *Config) LoadEnv() error {
+	for name, field := range c.stringFields() {
+		env := EnvPrefix + strings.ToUpper(name)
+		if v, ok := os.LookupEnv(env); ok {
+			*field = v
+		}
+		if file, ok := os.LookupEnv(env + "_FILE"); ok {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %w", env, err)
+			}
+			*field = strings.TrimRight(string(data), "\r\n")
+		}
+	}
+	if v, ok := os.LookupEnv(EnvPrefix + "RETURNHOSTS"); ok {
+		c.ReturnHosts = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	env := EnvPrefix + "AUTOCERT"
+	if v, ok := os.LookupEnv(env); ok {
+		autocert, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", env, err)
+		}
+		c.Autocert = autocert
+	}
+	return nil
+}
+
+// LoadSecrets overrides Secret and DSN with whatever secrets holds for
+// "secret" and "dsn".
+func (c *Config) LoadSecrets(ctx context.Context, secrets SecretProvider) error {
+	for name, field := range map[string]*string{"secret": &c.Secret, "dsn": &c.DSN} {
+		v, err := secrets.GetSecret(ctx, name)
+		if errors.Is(err, ErrSecretNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*field = v
+	}
+	return nil
+}
+
+// stringFields maps the config keys of the string settings to their fields.
+func (c *Config) stringFields() map[string]*string {
+	return map[string]*string{
+		"key":           &c.Key,
+		"secret":        &c.Secret,
+		"callback":      &c.Callback,
+		"redirect":      &c.Redirect,
+		"dsn":           &c.DSN,
+		"autocertcache": &c.AutocertCache,
+		"tlscert":       &c.TLSCert,
+		"tlskey":        &c.TLSKey,
+	}
+}
@@ -0,0 +1,113 @@
+package evesso_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ferocious-space/evesso"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	yamlPath := file("config.yaml", "key: from-file\nsecret: file-secret\ndsn: file-dsn\ncallback: http://localhost/callback\nreturnhosts: [a.example.com]\n")
+	jsonPath := file("config.json", `{"key":"from-json","secret":"json-secret"}`)
+	secretFile := file("secret", "mounted-secret\n")
+	secrets := evesso.SecretProviderFunc(func(_ context.Context, name string) (string, error) {
+		if name == "dsn" {
+			return "vault-dsn", nil
+		}
+		return "", evesso.ErrSecretNotFound
+	})
+
+	tests := []struct {
+		name    string
+		path    string
+		env     map[string]string
+		secrets evesso.SecretProvider
+		want    evesso.Config
+		wantErr bool
+	}{
+		{
+			name: "yaml file",
+			path: yamlPath,
+			want: evesso.Config{Key: "from-file", Secret: "file-secret", DSN: "file-dsn", Callback: "http://localhost/callback", ReturnHosts: []string{"a.example.com"}},
+		},
+		{
+			name: "json file",
+			path: jsonPath,
+			want: evesso.Config{Key: "from-json", Secret: "json-secret"},
+		},
+		{
+			name: "environment alone",
+			env:  map[string]string{"EVESSO_KEY": "from-env", "EVESSO_RETURNHOSTS": "a.example.com, b.example.com", "EVESSO_AUTOCERT": "true"},
+			want: evesso.Config{Key: "from-env", ReturnHosts: []string{"a.example.com", "b.example.com"}, Autocert: true},
+		},
+		{
+			name: "environment over file",
+			path: yamlPath,
+			env:  map[string]string{"EVESSO_KEY": "from-env"},
+			want: evesso.Config{Key: "from-env", Secret: "file-secret", DSN: "file-dsn", Callback: "http://localhost/callback", ReturnHosts: []string{"a.example.com"}},
+		},
+		{
+			name: "_FILE over variable",
+			path: yamlPath,
+			env:  map[string]string{"EVESSO_SECRET": "env-secret", "EVESSO_SECRET_FILE": secretFile},
+			want: evesso.Config{Key: "from-file", Secret: "mounted-secret", DSN: "file-dsn", Callback: "http://localhost/callback", ReturnHosts: []string{"a.example.com"}},
+		},
+		{
+			name:    "secret provider over environment",
+			path:    yamlPath,
+			env:     map[string]string{"EVESSO_DSN": "env-dsn"},
+			secrets: secrets,
+			want:    evesso.Config{Key: "from-file", Secret: "file-secret", DSN: "vault-dsn", Callback: "http://localhost/callback", ReturnHosts: []string{"a.example.com"}},
+		},
+		{name: "missing file", path: filepath.Join(dir, "missing.yaml"), wantErr: true},
+		{name: "unknown extension", path: file("config.toml", ""), wantErr: true},
+		{name: "missing _FILE", env: map[string]string{"EVESSO_SECRET_FILE": filepath.Join(dir, "missing")}, wantErr: true},
+		{name: "malformed autocert", env: map[string]string{"EVESSO_AUTOCERT": "maybe"}, wantErr: true},
+		{
+			name: "failing secret provider",
+			secrets: evesso.SecretProviderFunc(func(context.Context, string) (string, error) {
+				return "", errors.New("vault sealed")
+			}),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := evesso.LoadConfig(context.Background(), tt.path, tt.secrets)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("no error, config %+v", cfg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*cfg, tt.want) {
+				t.Errorf("config = %+v, want %+v", *cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoConfigNeedsPath(t *testing.T) {
+	if _, err := evesso.AutoConfig(context.Background(), "", nil, nil); err == nil {
+		t.Fatal("AutoConfig accepted an empty path")
+	}
+}
@@ -0,0 +1,359 @@
+package evesso
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// jwksStartupWait bounds how long New waits for the first JWKS fetch before
+// falling back to the cached JWKS.
+const jwksStartupWait = 30 * time.Second
+
+// maxRetryWait caps a single wait between discovery attempts, Retry-After
+// included.
+const maxRetryWait = 30 * time.Second
+
+// ErrInvalidMetadata is returned for a discovery document evesso cannot work
+// with.
+var ErrInvalidMetadata = errors.New("invalid sso metadata")
+
+// retryPolicy is how often and how patiently discovery is attempted.
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+}
+
+var defaultRetry = retryPolicy{attempts: 4, backoff: 500 * time.Millisecond}
+
+// statusError is a discovery response other than 200 OK.
+type statusError struct {
+	status     string
+	code       int
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return "discovery: " + e.status
+}
+
+// retryable reports whether another attempt could succeed: after a network
+// error, an unparseable body (a proxy's error page) or a 429 or 5xx status.
+func retryable(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code == http.StatusTooManyRequests || status.code >= 500
+	}
+	return !errors.Is(err, ErrInvalidMetadata) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date.
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+// MetadataChange is one field of the discovery document that differs between
+// two fetches. Field is its JSON name.
+type MetadataChange struct {
+	Field    string
+	Old, New string
+}
+
+// discoveryStatus records the background re-discovery: the base URL it
+// fetches, when it last succeeded, the document it last saw and who to tell
+// when that changes.
+type discoveryStatus struct {
+	sync.Mutex
+	base     string
+	fetched  time.Time
+	err      error
+	latest   Metadata
+	handlers []func(ctx context.Context, changes []MetadataChange)
+}
+
+func (s *discoveryStatus) record(err error) {
+	s.Lock()
+	defer s.Unlock()
+	if err == nil {
+		s.fetched = time.Now()
+	}
+	s.err = err
+}
+
+// last returns when discovery last succeeded and the error of the latest
+// attempt, if it failed.
+func (s *discoveryStatus) last() (time.Time, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.fetched, s.err
+}
+
+// OnMetadataChanged registers handler for when background re-discovery finds
+// a discovery document that differs from the last one seen. The changes are
+// reported, and persisted to the DocumentCache, but the running EVESSO keeps
+// its endpoints until it is built again.
+func (r *EVESSO) OnMetadataChanged(handler func(ctx context.Context, changes []MetadataChange)) {
+	r.discovery.Lock()
+	defer r.discovery.Unlock()
+	r.discovery.handlers = append(r.discovery.handlers, handler)
+}
+
+// fetchMetadata fetches, parses and validates the metadata document from the
+// issuer base URL, returning it raw too for the DocumentCache. Failures that
+// may pass are retried with exponential backoff, waiting at least as long as
+// a Retry-After header asks.
+func (r *EVESSO) fetchMetadata(ctx context.Context, issuer string) (_ Metadata, _ []byte, err error) {
+	ctx, span := r.telemetry.start(ctx, "evesso.discovery", attribute.String("evesso.issuer", issuer))
+	defer func() { end(span, err) }()
+	backoff := r.retry.backoff
+	for attempt := 1; ; attempt++ {
+		md, data, fetchErr := r.fetchMetadataOnce(ctx, issuer)
+		if fetchErr == nil {
+			return md, data, r.validateMetadata(md)
+		}
+		if attempt >= r.retry.attempts || !retryable(fetchErr) {
+			return md, nil, fetchErr
+		}
+		wait := backoff
+		var status *statusError
+		if errors.As(fetchErr, &status) && status.retryAfter > wait {
+			wait = status.retryAfter
+		}
+		wait = min(wait, maxRetryWait)
+		r.logger(ctx).V(logEvents).Info("sso discovery failed, retrying", "issuer", issuer, "attempt", attempt, "wait", wait, "error_class", errorClass(fetchErr), "error", fetchErr.Error())
+		select {
+		case <-ctx.Done():
+			return md, nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// fetchMetadataOnce is one attempt of fetchMetadata.
+func (r *EVESSO) fetchMetadataOnce(ctx context.Context, issuer string) (Metadata, []byte, error) {
+	var md Metadata
+	withContext, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(issuer, "/")+AUTOCONFIG_URL, nil)
+	if err != nil {
+		return md, nil, err
+	}
+	resp, err := r.client.Do(withContext)
+	if err != nil {
+		return md, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		return md, nil, &statusError{status: resp.Status, code: resp.StatusCode, retryAfter: retryAfter(resp.Header.Get("Retry-After"))}
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return md, nil, err
+	}
+	if err = json.Unmarshal(data, &md); err != nil {
+		return md, nil, fmt.Errorf("discovery: %w", err)
+	}
+	return md, data, nil
+}
+
+// validateMetadata checks that md is from an accepted issuer and has what the
+// authorization code flow with PKCE needs.
+func (r *EVESSO) validateMetadata(md Metadata) error {
+	var problems []string
+	if !slices.Contains(r.issuers, md.Issuer) {
+		problems = append(problems, fmt.Sprintf("issuer %q is not accepted", md.Issuer))
+	}
+	for name, endpoint := range map[string]string{
+		"authorization_endpoint": md.AuthorizationEndpoint,
+		"token_endpoint":         md.TokenEndpoint,
+		"jwks_uri":               md.JwksURI,
+	} {
+		if endpoint == "" {
+			problems = append(problems, name+" is missing")
+		}
+	}
+	if !slices.Contains(md.CodeChallengeMethodsSupported, "S256") {
+		problems = append(problems, "S256 code challenges are not supported")
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("%w: %s", ErrInvalidMetadata, strings.Join(problems, ", "))
+}
+
+// discover sets the metadata from the issuer base URL and caches the document.
+// If SSO cannot be reached it falls back to the cached document, leaving the
+// background re-discovery to catch up. A document SSO does serve but that is
+// invalid is an error; the cache does not paper over it.
+func (r *EVESSO) discover(ctx context.Context, issuer string) error {
+	r.discovery.base = issuer
+	md, data, err := r.fetchMetadata(ctx, issuer)
+	r.discovery.record(err)
+	if err == nil {
+		r.Metadata = md
+		r.discovery.latest = md
+		r.storeDocument(ctx, discoveryDocument+issuer, data)
+		return nil
+	}
+	if errors.Is(err, ErrInvalidMetadata) {
+		return err
+	}
+	cached, cacheErr := r.loadDocument(ctx, discoveryDocument+issuer)
+	if cacheErr != nil {
+		return err
+	}
+	if cacheErr = json.Unmarshal(cached, &md); cacheErr != nil || r.validateMetadata(md) != nil {
+		return err
+	}
+	r.Metadata = md
+	r.discovery.latest = r.Metadata
+	r.log.Error(err, "sso discovery failed, starting from the cached document", "issuer", issuer, "error_class", errorClass(err))
+	return nil
+}
+
+// awaitJWKS waits for the first JWKS fetch to succeed, and falls back to the
+// cached JWKS if it fails or takes longer than jwksStartupWait.
+func (r *EVESSO) awaitJWKS(ctx context.Context) error {
+	wait, cancel := context.WithTimeout(ctx, jwksStartupWait)
+	defer cancel()
+	err := r.jwksReady(wait)
+	if err == nil {
+		ks, err := r.refresher.Lookup(ctx, r.JwksURI)
+		if err != nil {
+			return err
+		}
+		r.keepJWKS(ctx, ks)
+		return nil
+	}
+	cached, cacheErr := r.loadDocument(ctx, jwksDocument+r.JwksURI)
+	if cacheErr != nil {
+		return err
+	}
+	ks, cacheErr := jwk.Parse(cached)
+	if cacheErr != nil {
+		return err
+	}
+	r.jwks.keep(ks)
+	r.log.Error(err, "jwks fetch failed, starting from the cached key set", "jwks_uri", r.JwksURI, "error_class", errorClass(err))
+	return nil
+}
+
+// jwksReady blocks until the JWKS has been fetched once, a fetch has failed,
+// or ctx is done.
+func (r *EVESSO) jwksReady(ctx context.Context) error {
+	for {
+		poll, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		ready := r.refresher.Ready(poll, r.JwksURI)
+		cancel()
+		if ready {
+			return nil
+		}
+		if _, err := r.jwks.last(); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("jwks: %w", err)
+		}
+	}
+}
+
+// keepJWKS makes ks the last known good key set, and caches it if it is new.
+func (r *EVESSO) keepJWKS(ctx context.Context, ks jwk.Set) {
+	if !r.jwks.keep(ks) {
+		return
+	}
+	data, err := json.Marshal(ks)
+	if err != nil {
+		return
+	}
+	r.storeDocument(ctx, jwksDocument+r.JwksURI, data)
+}
+
+// checkDiscovery fetches the discovery document again once the discovery
+// interval has passed since the last success, caches it, and reports fields
+// that changed since the last document seen.
+func (r *EVESSO) checkDiscovery(ctx context.Context) {
+	r.discovery.Lock()
+	base := r.discovery.base
+	r.discovery.Unlock()
+	fetched, _ := r.discovery.last()
+	if base == "" || time.Since(fetched) < r.intervals.discovery {
+		return
+	}
+	md, data, err := r.fetchMetadata(ctx, base)
+	r.discovery.record(err)
+	if err != nil {
+		r.log.Error(err, "sso re-discovery failed", "issuer", base, "last_fetched", fetched, "error_class", errorClass(err))
+		return
+	}
+	r.storeDocument(ctx, discoveryDocument+base, data)
+
+	r.discovery.Lock()
+	changes := diffMetadata(r.discovery.latest, md)
+	r.discovery.latest = md
+	handlers := append([]func(context.Context, []MetadataChange){}, r.discovery.handlers...)
+	r.discovery.Unlock()
+	if len(changes) == 0 {
+		return
+	}
+	for _, change := range changes {
+		r.log.Info("sso metadata changed", "field", change.Field, "old", change.Old, "new", change.New)
+	}
+	for _, handler := range handlers {
+		handler(ctx, changes)
+	}
+}
+
+// diffMetadata lists the fields that differ between old and new.
+func diffMetadata(old, new Metadata) []MetadataChange {
+	var changes []MetadataChange
+	ov, nv := reflect.ValueOf(old), reflect.ValueOf(new)
+	for i := 0; i < ov.NumField(); i++ {
+		o, n := fmt.Sprint(ov.Field(i).Interface()), fmt.Sprint(nv.Field(i).Interface())
+		if o == n {
+			continue
+		}
+		field, _, _ := strings.Cut(ov.Type().Field(i).Tag.Get("json"), ",")
+		changes = append(changes, MetadataChange{Field: field, Old: o, New: n})
+	}
+	return changes
+}
+
+func (r *EVESSO) loadDocument(ctx context.Context, key string) ([]byte, error) {
+	if r.documents == nil {
+		return nil, ErrNoDocument
+	}
+	return r.documents.LoadDocument(ctx, key)
+}
+
+func (r *EVESSO) storeDocument(ctx context.Context, key string, document []byte) {
+	if r.documents == nil {
+		return
+	}
+	if err := r.documents.StoreDocument(ctx, key, document); err != nil {
+		r.log.Error(err, "sso document could not be cached", "key", key, "error_class", errorClass(err))
+	}
+}
@@ -0,0 +1,132 @@
+package evesso_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+func TestDiscoveryRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		status   int
+		attempts int
+		wantErr  bool
+	}{
+		{name: "first attempt", attempts: 3},
+		{name: "recovers within the attempts", failures: 2, status: http.StatusBadGateway, attempts: 3},
+		{name: "rate limited", failures: 1, status: http.StatusTooManyRequests, attempts: 2},
+		{name: "fails every attempt", failures: 3, status: http.StatusServiceUnavailable, attempts: 3, wantErr: true},
+		{name: "not found is not retried", failures: 1, status: http.StatusNotFound, attempts: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := evessotest.NewServer(evessotest.ClientID, evessotest.ClientSecret, evessotest.Pilot)
+			defer srv.Close()
+			if tt.failures > 0 {
+				srv.FailNext(evessotest.EndpointDiscovery, tt.failures, tt.status)
+			}
+			sso, err := evesso.New(context.Background(),
+				evesso.WithConfig(evessotest.Config()),
+				evesso.WithStore(evessotest.NewStore()),
+				evesso.WithIssuer(srv.URL),
+				evesso.WithDiscoveryRetry(tt.attempts, time.Millisecond),
+			)
+			if tt.wantErr {
+				if err == nil {
+					sso.Close()
+					t.Fatal("New succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			sso.Close()
+		})
+	}
+}
+
+func TestDiscoveryValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		// document is served with %s replaced by the server's URL
+		document string
+		// attempts is how often the document is fetched with 3 allowed
+		attempts int32
+		invalid  bool
+	}{
+		{
+			name:     "issuer not accepted",
+			document: `{"issuer":"https://evil.example.com","authorization_endpoint":"%s/a","token_endpoint":"%s/t","jwks_uri":"%s/j","code_challenge_methods_supported":["S256"]}`,
+			attempts: 1,
+			invalid:  true,
+		},
+		{
+			name:     "no token endpoint",
+			document: `{"issuer":"https://login.eveonline.com","authorization_endpoint":"%s/a","jwks_uri":"%s/j","code_challenge_methods_supported":["S256"]}`,
+			attempts: 1,
+			invalid:  true,
+		},
+		{
+			name:     "no S256",
+			document: `{"issuer":"https://login.eveonline.com","authorization_endpoint":"%s/a","token_endpoint":"%s/t","jwks_uri":"%s/j","code_challenge_methods_supported":["plain"]}`,
+			attempts: 1,
+			invalid:  true,
+		},
+		{
+			name:     "a proxy's error page",
+			document: `<html><body>502 Bad Gateway</body></html>`,
+			attempts: 3,
+		},
+		{
+			name:     "an error page with 502",
+			status:   http.StatusBadGateway,
+			document: `<html><body>502 Bad Gateway</body></html>`,
+			attempts: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetched atomic.Int32
+			var srv *httptest.Server
+			srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != evessotest.DiscoveryPath {
+					http.NotFound(w, req)
+					return
+				}
+				fetched.Add(1)
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				_, _ = w.Write([]byte(strings.ReplaceAll(tt.document, "%s", srv.URL)))
+			}))
+			defer srv.Close()
+			_, err := evesso.New(context.Background(),
+				evesso.WithConfig(evessotest.Config()),
+				evesso.WithStore(evessotest.NewStore()),
+				evesso.WithIssuer(srv.URL),
+				evesso.WithDiscoveryRetry(3, time.Millisecond),
+			)
+			if err == nil {
+				t.Fatal("New accepted the document")
+			}
+			if errors.Is(err, evesso.ErrInvalidMetadata) != tt.invalid {
+				t.Errorf("err = %v, invalid metadata %t", err, tt.invalid)
+			}
+			if n := fetched.Load(); n != tt.attempts {
+				t.Errorf("fetched %d times, want %d", n, tt.attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package evesso
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrNoDocument is returned by a DocumentCache that holds nothing under a key.
+var ErrNoDocument = errors.New("document not cached")
+
+// DocumentCache keeps the last SSO discovery document and JWKS that were
+// fetched successfully, so New can start while SSO is unreachable. A DataStore
+// that implements it is used unless WithDocumentCache names another cache.
+// Documents are JSON.
+type DocumentCache interface {
+	// LoadDocument returns the document stored under key, or ErrNoDocument.
+	LoadDocument(ctx context.Context, key string) ([]byte, error)
+	// StoreDocument replaces the document stored under key.
+	StoreDocument(ctx context.Context, key string, document []byte) error
+}
+
+// document cache keys, followed by the URL the document was fetched from
+const (
+	discoveryDocument = "discovery "
+	jwksDocument      = "jwks "
+)
+
+// FileCache is a DocumentCache kept in a single JSON file.
+type FileCache struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileCache returns a FileCache at path. The file is created on the first
+// store.
+func NewFileCache(path string) *FileCache {
+	return &FileCache{path: path}
+}
+
+func (f *FileCache) LoadDocument(_ context.Context, key string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	documents, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	document, ok := documents[key]
+	if !ok {
+		return nil, ErrNoDocument
+	}
+	return document, nil
+}
+
+func (f *FileCache) StoreDocument(_ context.Context, key string, document []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	documents, err := f.read()
+	if err != nil {
+		return err
+	}
+	documents[key] = document
+	data, err := json.Marshal(documents)
+	if err != nil {
+		return err
+	}
+	// write next to the file and rename, so a crash never leaves half a file
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func (f *FileCache) read() (map[string]json.RawMessage, error) {
+	documents := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return documents, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &documents); err != nil {
+		return nil, err
+	}
+	return documents, nil
+}
@@ -0,0 +1,125 @@
+package evesso_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+func TestDocumentCache(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		// warm starts an EVESSO with the cache before SSO goes down
+		warm    bool
+		cache   bool
+		wantErr bool
+	}{
+		{name: "warm cache", warm: true, cache: true},
+		{name: "cold cache", cache: true, wantErr: true},
+		{name: "no cache", warm: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := evesso.NewFileCache(filepath.Join(t.TempDir(), "sso.json"))
+			srv, _, _ := evessotest.NewSSO(t, evesso.WithDocumentCache(cache))
+			if !tt.warm {
+				cache = evesso.NewFileCache(filepath.Join(t.TempDir(), "sso.json"))
+			}
+			opts := []evesso.Option{
+				evesso.WithConfig(evessotest.Config()),
+				evesso.WithStore(evessotest.NewStore()),
+				evesso.WithIssuer(srv.URL),
+				evesso.WithDiscoveryRetry(1, 0),
+			}
+			if tt.cache {
+				opts = append(opts, evesso.WithDocumentCache(cache))
+			}
+
+			srv.FailNext(evessotest.EndpointDiscovery, 1, http.StatusServiceUnavailable)
+			srv.FailNext(evessotest.EndpointJWKS, 100, http.StatusServiceUnavailable)
+			sso, err := evesso.New(ctx, opts...)
+			if tt.wantErr {
+				if err == nil {
+					sso.Close()
+					t.Fatal("started without SSO")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sso.Close()
+			profile, err := sso.Store().NewProfile(ctx, "default", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// tokens verify against the cached JWKS while SSO serves none
+			if auth := evessotest.Authorize(t, srv, sso, profile, "publicData"); auth.Claims.CharacterID() != evessotest.Pilot.ID {
+				t.Errorf("authorized %d", auth.Claims.CharacterID())
+			}
+		})
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	ctx := context.Background()
+	cache := evesso.NewFileCache(filepath.Join(t.TempDir(), "sso.json"))
+	if _, err := cache.LoadDocument(ctx, "a"); !errors.Is(err, evesso.ErrNoDocument) {
+		t.Fatalf("err = %v, want ErrNoDocument", err)
+	}
+	for _, doc := range []string{`{"v":1}`, `{"v":2}`} {
+		if err := cache.StoreDocument(ctx, "a", []byte(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cache.StoreDocument(ctx, "b", []byte(`[]`)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cache.LoadDocument(ctx, "a"); err != nil || string(got) != `{"v":2}` {
+		t.Errorf("a = %s (%v)", got, err)
+	}
+	if got, err := cache.LoadDocument(ctx, "b"); err != nil || string(got) != `[]` {
+		t.Errorf("b = %s (%v)", got, err)
+	}
+}
+
+// TestDocumentCacheRefresh checks that a start from the cache is followed by
+// background re-discovery once SSO is back.
+func TestDocumentCacheRefresh(t *testing.T) {
+	ctx := context.Background()
+	cache := evesso.NewFileCache(filepath.Join(t.TempDir(), "sso.json"))
+	srv, _, _ := evessotest.NewSSO(t, evesso.WithDocumentCache(cache))
+	srv.FailNext(evessotest.EndpointDiscovery, 1, http.StatusServiceUnavailable)
+	sso, err := evesso.New(ctx,
+		evesso.WithConfig(evessotest.Config()),
+		evesso.WithStore(evessotest.NewStore()),
+		evesso.WithIssuer(srv.URL),
+		evesso.WithDiscoveryRetry(1, 0),
+		evesso.WithDocumentCache(cache),
+		evesso.WithDiscoveryInterval(time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sso.Close()
+	if sso.Health(ctx).Discovery.LastError == "" {
+		t.Fatal("discovery failure not reported")
+	}
+	if err = sso.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for sso.Health(ctx).Discovery.LastError != "" {
+		if time.Now().After(deadline) {
+			t.Fatal("discovery never recovered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
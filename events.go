@@ -0,0 +1,144 @@
+package evesso
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Cause says what raised an Event.
+type Cause string
+
+const (
+	// CauseCallback is a callback served by ServeHTTP.
+	CauseCallback Cause = "callback"
+	// CauseLocalhost is a callback served by LocalhostAuth.
+	CauseLocalhost Cause = "localhost"
+	// CausePaste is a callback pasted back through Complete.
+	CausePaste Cause = "paste"
+	// CauseSave is a token handed to a token source's Save.
+	CauseSave Cause = "save"
+	// CauseRefresh is a token source refreshing its token.
+	CauseRefresh Cause = "refresh"
+	// CauseDelete is a deletion through DeleteCharacter or DeleteProfile.
+	CauseDelete Cause = "delete"
+)
+
+// Event describes a change to a character's authorization.
+type Event struct {
+	Profile   Profile
+	Character Character
+	// ReferenceData is the PKCE reference data for an authorization, and the
+	// character's stored reference data otherwise.
+	ReferenceData interface{}
+	Cause         Cause
+	// Err is the SSO error that deactivated a character, or the revocation
+	// error that accompanied a deletion.
+	Err error
+}
+
+// EventHandler reacts to an Event. Handlers run synchronously, in the order
+// they were registered, on the goroutine that raised the event: a callback
+// handler finishes before the browser is redirected. A handler must not call
+// Token on the token source that raised the event.
+type EventHandler func(ctx context.Context, event Event)
+
+type eventKind int
+
+const (
+	eventAuthorized eventKind = iota
+	eventRefreshed
+	eventDeactivated
+	eventDeleted
+	eventKinds
+)
+
+type hooks struct {
+	sync.RWMutex
+	handlers [eventKinds][]EventHandler
+}
+
+// OnAuthorized registers h to run whenever a character is authorized and
+// persisted, through a callback or Save.
+func (r *EVESSO) OnAuthorized(h EventHandler) { r.on(eventAuthorized, h) }
+
+// OnRefreshed registers h to run whenever a token source obtains a new access
+// token.
+func (r *EVESSO) OnRefreshed(h EventHandler) { r.on(eventRefreshed, h) }
+
+// OnDeactivated registers h to run whenever SSO rejects a refresh and the
+// character is marked inactive.
+func (r *EVESSO) OnDeactivated(h EventHandler) { r.on(eventDeactivated, h) }
+
+// OnDeleted registers h to run whenever DeleteCharacter or DeleteProfile
+// deletes a character, or pruning does. Deletions made on the store directly,
+// through Character.Delete, Profile.Delete or DataStore.DeleteProfile, do not
+// raise it.
+func (r *EVESSO) OnDeleted(h EventHandler) { r.on(eventDeleted, h) }
+
+func (r *EVESSO) on(kind eventKind, h EventHandler) {
+	r.hooks.Lock()
+	defer r.hooks.Unlock()
+	r.hooks.handlers[kind] = append(r.hooks.handlers[kind], h)
+}
+
+// emit runs the handlers for kind. The profile is looked up only when there is
+// a handler to receive it.
+func (r *EVESSO) emit(ctx context.Context, kind eventKind, event Event) {
+	r.hooks.RLock()
+	handlers := r.hooks.handlers[kind]
+	r.hooks.RUnlock()
+	if len(handlers) == 0 {
+		return
+	}
+	if event.Profile == nil && event.Character != nil {
+		event.Profile, _ = event.Character.GetProfile(ctx)
+	}
+	if event.ReferenceData == nil && event.Character != nil {
+		event.ReferenceData = event.Character.GetReferenceData()
+	}
+	for _, h := range handlers {
+		h(ctx, event)
+	}
+}
+
+// DeleteCharacter deletes character, passing opts on, and raises OnDeleted.
+// A *RevocationError still counts as deleted and is carried in Event.Err.
+func (r *EVESSO) DeleteCharacter(ctx context.Context, character Character, opts ...DeleteOption) error {
+	err := character.Delete(ctx, opts...)
+	var revokeErr *RevocationError
+	if err != nil && !errors.As(err, &revokeErr) {
+		return err
+	}
+	if err != nil {
+		r.logger(ctx).Error(err, "character deleted, tokens still valid at SSO", characterFields(character)...)
+	} else {
+		r.logger(ctx).V(logEvents).Info("character deleted", characterFields(character)...)
+	}
+	r.emit(ctx, eventDeleted, Event{Character: character, Cause: CauseDelete, Err: err})
+	return err
+}
+
+// DeleteProfile deletes profile with its characters, passing opts on, and
+// raises OnDeleted for each character it had. A *RevocationError still counts
+// as deleted and is carried in Event.Err.
+func (r *EVESSO) DeleteProfile(ctx context.Context, profile Profile, opts ...DeleteOption) error {
+	characters, err := profile.AllCharacters(ctx)
+	if err != nil {
+		return err
+	}
+	err = r.store.DeleteProfile(ctx, profile.GetID(), opts...)
+	var revokeErr *RevocationError
+	if err != nil && !errors.As(err, &revokeErr) {
+		return err
+	}
+	if err != nil {
+		r.logger(ctx).Error(err, "profile deleted, tokens still valid at SSO", "profile_id", profile.GetID())
+	} else {
+		r.logger(ctx).V(logEvents).Info("profile deleted", "profile_id", profile.GetID(), "character_count", len(characters))
+	}
+	for _, character := range characters {
+		r.emit(ctx, eventDeleted, Event{Profile: profile, Character: character, Cause: CauseDelete, Err: err})
+	}
+	return err
+}
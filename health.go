@@ -0,0 +1,221 @@
+package evesso
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Pinger is implemented by a DataStore that can check its connection, for the
+// readiness report.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// MigrationVersioner is implemented by a DataStore with a schema of its own,
+// reporting the latest migration applied and whether it failed halfway.
+type MigrationVersioner interface {
+	MigrationVersion() (version uint, dirty bool, err error)
+}
+
+// CharacterCounter is implemented by a DataStore that can count its characters
+// without loading them.
+type CharacterCounter interface {
+	CountCharacters(ctx context.Context) (active, inactive int64, err error)
+}
+
+// HealthStatus is the overall verdict of a Health report.
+type HealthStatus string
+
+const (
+	// HealthOK means everything checked is working.
+	HealthOK HealthStatus = "ok"
+	// HealthDegraded means tokens can be issued and verified, but something
+	// is failing: SSO cannot be reached, or characters cannot be counted.
+	HealthDegraded HealthStatus = "degraded"
+	// HealthUnavailable means tokens cannot be issued: the EVESSO is closed,
+	// the store is unreachable or half migrated, or no JWKS was ever fetched.
+	HealthUnavailable HealthStatus = "unavailable"
+)
+
+// healthTimeout bounds a readiness check whose request has no deadline.
+const healthTimeout = 5 * time.Second
+
+// characterCountAge is how long a character count is reused, so that frequent
+// readiness probes do not each count the characters.
+const characterCountAge = time.Minute
+
+// characterCount is the last successful character count.
+type characterCount struct {
+	sync.Mutex
+	counted time.Time
+	counts  CharacterCounts
+}
+
+// get returns the counts, counting again once they are older than
+// characterCountAge. A failed count is not kept, so recovery shows at once.
+func (c *characterCount) get(ctx context.Context, counter CharacterCounter) CharacterCounts {
+	c.Lock()
+	defer c.Unlock()
+	if !c.counted.IsZero() && time.Since(c.counted) < characterCountAge {
+		return c.counts
+	}
+	var counts CharacterCounts
+	var err error
+	counts.Active, counts.Inactive, err = counter.CountCharacters(ctx)
+	if err != nil {
+		return CharacterCounts{Error: err.Error()}
+	}
+	c.counted, c.counts = time.Now(), counts
+	return counts
+}
+
+// Health is the readiness report. Sections whose store support is missing are
+// left out.
+type Health struct {
+	Status     HealthStatus     `json:"status"`
+	Store      StoreHealth      `json:"store,omitzero"`
+	JWKS       FetchHealth      `json:"jwks,omitzero"`
+	Discovery  FetchHealth      `json:"discovery,omitzero"`
+	Characters *CharacterCounts `json:"characters,omitempty"`
+}
+
+// StoreHealth reports the DataStore. Error is the ping error.
+type StoreHealth struct {
+	Reachable      *bool  `json:"reachable,omitempty"`
+	Error          string `json:"error,omitempty"`
+	Migration      *uint  `json:"migration_version,omitempty"`
+	MigrationDirty bool   `json:"migration_dirty,omitempty"`
+	MigrationError string `json:"migration_error,omitempty"`
+}
+
+// FetchHealth reports a document evesso fetches from SSO: when it was last
+// fetched successfully, how long ago in seconds, and the error of the latest
+// attempt if that failed. Fetched is zero when the document came from the
+// DocumentCache or WithMetadata.
+type FetchHealth struct {
+	Fetched   time.Time `json:"fetched_at,omitzero"`
+	Age       float64   `json:"age_seconds,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// CharacterCounts reports how many characters are stored, as counted up to a
+// minute ago.
+type CharacterCounts struct {
+	Active   int64  `json:"active"`
+	Inactive int64  `json:"inactive"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Health checks the store and reports on the JWKS and discovery. It makes no
+// requests to SSO: a failing SSO degrades the report but does not make it
+// unavailable, since the last known good keys still verify tokens and every
+// instance would be equally affected.
+func (r *EVESSO) Health(ctx context.Context) Health {
+	h := Health{Status: HealthOK}
+	worse := func(status HealthStatus) {
+		if status == HealthUnavailable || h.Status == HealthOK {
+			h.Status = status
+		}
+	}
+	if r.closed() {
+		// the store is closed too
+		h.Status = HealthUnavailable
+		return h
+	}
+
+	if pinger, ok := r.store.(Pinger); ok {
+		err := pinger.Ping(ctx)
+		reachable := err == nil
+		h.Store.Reachable = &reachable
+		if err != nil {
+			h.Store.Error = err.Error()
+			worse(HealthUnavailable)
+		}
+	}
+	if versioner, ok := r.store.(MigrationVersioner); ok {
+		version, dirty, err := versioner.MigrationVersion()
+		switch {
+		case err != nil:
+			h.Store.MigrationError = err.Error()
+			worse(HealthDegraded)
+		case dirty:
+			h.Store.Migration, h.Store.MigrationDirty = &version, true
+			worse(HealthUnavailable)
+		default:
+			h.Store.Migration = &version
+		}
+	}
+
+	fetched, err := r.jwks.last()
+	h.JWKS = fetchHealth(fetched, err)
+	switch {
+	case r.jwks.lastGood() == nil:
+		worse(HealthUnavailable)
+	case err != nil, !fetched.IsZero() && time.Since(fetched) > 2*r.intervals.jwks:
+		worse(HealthDegraded)
+	}
+	fetched, err = r.discovery.last()
+	h.Discovery = fetchHealth(fetched, err)
+	if err != nil {
+		worse(HealthDegraded)
+	}
+
+	if counter, ok := r.store.(CharacterCounter); ok {
+		counts := r.counts.get(ctx, counter)
+		h.Characters = &counts
+		if counts.Error != "" {
+			worse(HealthDegraded)
+		}
+	}
+	return h
+}
+
+func fetchHealth(fetched time.Time, err error) FetchHealth {
+	f := FetchHealth{Fetched: fetched}
+	if !fetched.IsZero() {
+		f.Age = time.Since(fetched).Seconds()
+	}
+	if err != nil {
+		f.LastError = err.Error()
+	}
+	return f
+}
+
+// LivenessHandler answers 200 while the EVESSO is open and 503 once it is
+// closed. It checks nothing else, so an outage of the store or SSO never gets
+// a healthy process restarted.
+func (r *EVESSO) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		status := HealthOK
+		if r.closed() {
+			status = HealthUnavailable
+		}
+		writeHealth(w, Health{Status: status})
+	})
+}
+
+// ReadinessHandler answers with the Health report as JSON: 200 when it is ok
+// or degraded, 503 when it is unavailable.
+func (r *EVESSO) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, healthTimeout)
+			defer cancel()
+		}
+		writeHealth(w, r.Health(ctx))
+	})
+}
+
+func writeHealth(w http.ResponseWriter, h Health) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if h.Status == HealthUnavailable {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(h)
+}
@@ -0,0 +1,127 @@
+package evesso_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+// healthStore is a store with the optional health capabilities, each
+// failing on demand.
+type healthStore struct {
+	*evessotest.Store
+	pingErr, migrationErr, countErr error
+	dirty                           bool
+	counted                         atomic.Int32
+}
+
+func (s *healthStore) Ping(context.Context) error {
+	return s.pingErr
+}
+
+func (s *healthStore) MigrationVersion() (uint, bool, error) {
+	return 3, s.dirty, s.migrationErr
+}
+
+func (s *healthStore) CountCharacters(context.Context) (int64, int64, error) {
+	s.counted.Add(1)
+	return 2, 1, s.countErr
+}
+
+func TestHealth(t *testing.T) {
+	failed := errors.New("failed")
+	tests := []struct {
+		name   string
+		store  *healthStore
+		status evesso.HealthStatus
+		code   int
+	}{
+		{name: "store without health support", status: evesso.HealthOK, code: http.StatusOK},
+		{name: "healthy store", store: &healthStore{}, status: evesso.HealthOK, code: http.StatusOK},
+		{name: "store unreachable", store: &healthStore{pingErr: failed}, status: evesso.HealthUnavailable, code: http.StatusServiceUnavailable},
+		{name: "migration dirty", store: &healthStore{dirty: true}, status: evesso.HealthUnavailable, code: http.StatusServiceUnavailable},
+		{name: "migration version unknown", store: &healthStore{migrationErr: failed}, status: evesso.HealthDegraded, code: http.StatusOK},
+		{name: "characters not counted", store: &healthStore{countErr: failed}, status: evesso.HealthDegraded, code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []evesso.Option
+			if tt.store != nil {
+				tt.store.Store = evessotest.NewStore()
+				opts = append(opts, evesso.WithStore(tt.store))
+			}
+			_, sso, _ := evessotest.NewSSO(t, opts...)
+
+			rec := httptest.NewRecorder()
+			sso.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tt.code {
+				t.Errorf("status code = %d, want %d", rec.Code, tt.code)
+			}
+			var h evesso.Health
+			if err := json.NewDecoder(rec.Body).Decode(&h); err != nil {
+				t.Fatal(err)
+			}
+			if h.Status != tt.status {
+				t.Errorf("status = %s, want %s: %+v", h.Status, tt.status, h)
+			}
+			if tt.store == nil {
+				if h.Store.Reachable != nil || h.Characters != nil {
+					t.Errorf("reported what the store cannot tell: %+v", h)
+				}
+				return
+			}
+			if tt.store.countErr == nil && (h.Characters == nil || h.Characters.Active != 2 || h.Characters.Inactive != 1) {
+				t.Errorf("characters = %+v", h.Characters)
+			}
+		})
+	}
+}
+
+func TestHealthCountsReused(t *testing.T) {
+	store := &healthStore{Store: evessotest.NewStore()}
+	_, sso, _ := evessotest.NewSSO(t, evesso.WithStore(store))
+	for range 3 {
+		sso.Health(context.Background())
+	}
+	if n := store.counted.Load(); n != 1 {
+		t.Errorf("counted %d times, want once", n)
+	}
+
+	store = &healthStore{Store: evessotest.NewStore(), countErr: errors.New("failed")}
+	_, sso, _ = evessotest.NewSSO(t, evesso.WithStore(store))
+	for range 3 {
+		sso.Health(context.Background())
+	}
+	// failed counts are not kept, so recovery shows at once
+	if n := store.counted.Load(); n != 3 {
+		t.Errorf("counted %d times, want every time", n)
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	_, sso, _ := evessotest.NewSSO(t)
+	probe := func() int {
+		rec := httptest.NewRecorder()
+		sso.LivenessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+		return rec.Code
+	}
+	if code := probe(); code != http.StatusOK {
+		t.Errorf("open: %d", code)
+	}
+	if err := sso.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("closed: %d", code)
+	}
+	if h := sso.Health(context.Background()); h.Status != evesso.HealthUnavailable {
+		t.Errorf("closed: readiness %s", h.Status)
+	}
+}
@@ -0,0 +1,158 @@
+package evesso
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// jwksStatus records the outcome of the latest JWKS fetch, whoever made it:
+// the cache's periodic refresh or a forced one. It also keeps the last key set
+// that was looked up successfully, and when a refetch was last forced.
+type jwksStatus struct {
+	sync.Mutex
+	fetched time.Time
+	err     error
+	good    jwk.Set
+	forced  time.Time
+	// errors counts failed fetches
+	errors metric.Int64Counter
+}
+
+// keep remembers ks as the last known good key set, reporting whether it
+// differs from the one before.
+func (s *jwksStatus) keep(ks jwk.Set) bool {
+	s.Lock()
+	defer s.Unlock()
+	changed := s.good != ks
+	s.good = ks
+	return changed
+}
+
+// lastGood returns the last known good key set, nil before the first.
+func (s *jwksStatus) lastGood() jwk.Set {
+	s.Lock()
+	defer s.Unlock()
+	return s.good
+}
+
+// mayForce reports whether a refetch may be forced now, at most one per
+// interval, and if so counts it as forced.
+func (s *jwksStatus) mayForce(interval time.Duration) bool {
+	s.Lock()
+	defer s.Unlock()
+	if time.Since(s.forced) < interval {
+		return false
+	}
+	s.forced = time.Now()
+	return true
+}
+
+func (s *jwksStatus) record(err error) {
+	s.Lock()
+	defer s.Unlock()
+	if err == nil {
+		s.fetched = time.Now()
+	}
+	s.err = err
+}
+
+// last returns when the JWKS was last fetched successfully and the error of
+// the latest attempt, if it failed.
+func (s *jwksStatus) last() (time.Time, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.fetched, s.err
+}
+
+// client wraps base so every response it gets is recorded.
+func (s *jwksStatus) client(base *http.Client) *http.Client {
+	next := base.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	wrapped := *base
+	wrapped.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(req)
+		switch {
+		case err != nil:
+			s.record(err)
+		case resp.StatusCode != http.StatusOK:
+			s.record(fmt.Errorf("jwks: %s", resp.Status))
+		default:
+			s.record(nil)
+			return resp, err
+		}
+		if s.errors != nil {
+			s.errors.Add(req.Context(), 1)
+		}
+		return resp, err
+	})
+	return &wrapped
+}
+
+// keys returns the key set to verify accessToken with. A token signed with a
+// key id the set lacks forces a refetch, rate limited by the JWKS refetch
+// interval, so rotated keys are picked up before the next scheduled refresh.
+// When the cache cannot be read, the last known good set is used instead.
+func (r *EVESSO) keys(ctx context.Context, accessToken string) (jwk.Set, error) {
+	ks, err := r.lookupJWKS(ctx)
+	if err != nil {
+		good := r.jwks.lastGood()
+		if good == nil {
+			return nil, err
+		}
+		r.logger(ctx).Error(err, "jwks lookup failed, using last known good keys", "error_class", errorClass(err))
+		ks = good
+	} else {
+		r.keepJWKS(ctx, ks)
+	}
+	kid := keyID(accessToken)
+	if kid == "" {
+		return ks, nil
+	}
+	if _, ok := ks.LookupKeyID(kid); ok || !r.jwks.mayForce(r.intervals.jwksRefetch) {
+		return ks, nil
+	}
+	fresh, err := r.refetchJWKS(ctx, kid)
+	if err != nil {
+		r.logger(ctx).Error(err, "jwks refetch for unknown key id failed", "kid", kid, "error_class", errorClass(err))
+		return ks, nil
+	}
+	return fresh, nil
+}
+
+// refetchJWKS refetches the key set because of kid.
+func (r *EVESSO) refetchJWKS(ctx context.Context, kid string) (_ jwk.Set, err error) {
+	ctx, span := r.telemetry.start(ctx, "evesso.jwks.refetch", attribute.String("evesso.kid", kid))
+	defer func() { end(span, err) }()
+	r.logger(ctx).V(logEvents).Info("unknown jwks key id, refetching", "kid", kid)
+	ks, err := r.refresher.Refresh(ctx, r.JwksURI)
+	if err != nil {
+		return nil, err
+	}
+	r.keepJWKS(ctx, ks)
+	return ks, nil
+}
+
+// keyID returns the kid header of a compact JWS, or "" if it has none or
+// cannot be parsed; validation reports the latter properly.
+func keyID(token string) string {
+	msg, err := jws.Parse([]byte(token))
+	if err != nil || len(msg.Signatures()) == 0 {
+		return ""
+	}
+	kid, _ := msg.Signatures()[0].ProtectedHeaders().KeyID()
+	return kid
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
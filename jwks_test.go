@@ -0,0 +1,108 @@
+package evesso_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+func TestJWKSRefetch(t *testing.T) {
+	type step struct {
+		// before changes the server before the next authorization
+		before  func(t *testing.T, srv *evessotest.Server)
+		wait    time.Duration
+		outcome evesso.OutcomeKind
+	}
+	rotate := func(t *testing.T, srv *evessotest.Server) {
+		if err := srv.RotateKey(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name string
+		// refetch is the minimum time between forced refetches
+		refetch time.Duration
+		steps   []step
+	}{
+		{
+			name:    "rotated key is fetched on first sight",
+			refetch: time.Hour,
+			steps: []step{
+				{outcome: evesso.OutcomeSuccess},
+				{before: rotate, outcome: evesso.OutcomeSuccess},
+			},
+		},
+		{
+			name:    "forced refetches are rate limited",
+			refetch: time.Hour,
+			steps: []step{
+				{outcome: evesso.OutcomeSuccess},
+				{before: rotate, outcome: evesso.OutcomeSuccess},
+				{before: rotate, outcome: evesso.OutcomeVerificationFailed},
+			},
+		},
+		{
+			name:    "a refetch is allowed again after the interval",
+			refetch: 50 * time.Millisecond,
+			steps: []step{
+				{outcome: evesso.OutcomeSuccess},
+				{before: rotate, outcome: evesso.OutcomeSuccess},
+				{before: rotate, wait: 100 * time.Millisecond, outcome: evesso.OutcomeSuccess},
+			},
+		},
+		{
+			name:    "a failed refetch is retried after the interval",
+			refetch: 50 * time.Millisecond,
+			steps: []step{
+				{outcome: evesso.OutcomeSuccess},
+				{
+					before: func(t *testing.T, srv *evessotest.Server) {
+						rotate(t, srv)
+						srv.FailNext(evessotest.EndpointJWKS, 1, http.StatusBadGateway)
+					},
+					outcome: evesso.OutcomeVerificationFailed,
+				},
+				{wait: 100 * time.Millisecond, outcome: evesso.OutcomeSuccess},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, sso, store := evessotest.NewSSO(t, evesso.WithJWKSRefetchInterval(tt.refetch))
+			profile, err := store.NewProfile(context.Background(), "default", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, s := range tt.steps {
+				if s.before != nil {
+					s.before(t, srv)
+				}
+				time.Sleep(s.wait)
+				pkce, err := sso.CreatePKCE(context.Background(), profile, "", nil, "publicData")
+				if err != nil {
+					t.Fatal(err)
+				}
+				callback, err := srv.Approve(sso.AuthUrl(pkce))
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = sso.CompleteCallback(context.Background(), callback)
+				outcome := evesso.OutcomeSuccess
+				var callbackErr *evesso.CallbackError
+				if errors.As(err, &callbackErr) {
+					outcome = callbackErr.Kind
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if outcome != s.outcome {
+					t.Fatalf("step %d: %s (%v), want %s", i, outcome, err, s.outcome)
+				}
+			}
+		})
+	}
+}
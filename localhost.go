@@ -0,0 +1,207 @@
+package evesso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/mdp/qrterminal/v3"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/ferocious-space/evesso/internal/utils"
+)
+
+// LocalhostOption configures LocalhostAuth.
+type LocalhostOption func(*localhostOptions)
+
+type localhostOptions struct {
+	present []func(authURL string) error
+	bind    string
+}
+
+// WithBrowser opens the authorization URL in the desktop browser. It is the
+// default when no other presentation option is given.
+func WithBrowser() LocalhostOption {
+	return func(o *localhostOptions) {
+		o.present = append(o.present, utils.OSExec)
+	}
+}
+
+// WithPrintURL writes the authorization URL to w instead of opening a browser,
+// for servers and containers that have none.
+func WithPrintURL(w io.Writer) LocalhostOption {
+	return func(o *localhostOptions) {
+		o.present = append(o.present, func(authURL string) error {
+			_, err := fmt.Fprintf(w, "Open this URL to authorize:\n\n%s\n\n", authURL)
+			return err
+		})
+	}
+}
+
+// WithQRCode renders the authorization URL to w as a terminal QR code, to be
+// scanned with a phone. Authorization URLs requesting many scopes make large
+// codes.
+func WithQRCode(w io.Writer) LocalhostOption {
+	return func(o *localhostOptions) {
+		o.present = append(o.present, func(authURL string) error {
+			qrterminal.GenerateHalfBlock(authURL, qrterminal.L, w)
+			return nil
+		})
+	}
+}
+
+// WithURLHandler hands the authorization URL to handler instead of opening a
+// browser.
+func WithURLHandler(handler func(authURL string)) LocalhostOption {
+	return func(o *localhostOptions) {
+		o.present = append(o.present, func(authURL string) error {
+			handler(authURL)
+			return nil
+		})
+	}
+}
+
+// WithBindAddress sets the host the callback server listens on, such as
+// "127.0.0.1". The port always comes from the callback URL. By default it
+// listens on every interface.
+func WithBindAddress(host string) LocalhostOption {
+	return func(o *localhostOptions) {
+		o.bind = host
+	}
+}
+
+// LocalhostAuth serves the callback itself for a CLI or desktop flow. It
+// presents urlPath, by default by opening the browser, and blocks until one
+// callback arrives or 5 minutes pass. It is LocalhostAuthContext without a
+// context or a result.
+func (r *EVESSO) LocalhostAuth(urlPath string, opts ...LocalhostOption) error {
+	_, err := r.LocalhostAuthContext(context.Background(), urlPath, opts...)
+	return err
+}
+
+// LocalhostAuthContext is LocalhostAuth returning what was authorized. It
+// stops when ctx is done; without a deadline on ctx it gives up after 5
+// minutes, when the PKCE row expires anyway. A callback that fails returns a
+// *CallbackError.
+func (r *EVESSO) LocalhostAuthContext(ctx context.Context, urlPath string, opts ...LocalhostOption) (*Authorization, error) {
+	o := new(localhostOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+	if len(o.present) == 0 {
+		WithBrowser()(o)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 5*time.Minute)
+		defer cancel()
+	}
+
+	callback, err := url.Parse(r.AppConfig().Callback)
+	if err != nil {
+		return nil, err
+	}
+	outcomes := make(chan *Outcome, 1)
+	log := r.logger(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		callback.Path, func(w http.ResponseWriter, req *http.Request) {
+			reqCtx := logr.NewContext(req.Context(), log)
+			outcome := r.complete(reqCtx, CauseLocalhost, req.FormValue("code"), req.FormValue("state"))
+			r.renderer.Render(w, req, outcome)
+			select {
+			case outcomes <- outcome:
+			default:
+			}
+		},
+	)
+
+	srv, listener, err := r.localhostServer(callback, o.bind, mux)
+	if err != nil {
+		log.Error(err, "callback server could not listen", "error_class", errorClass(err))
+		return nil, err
+	}
+	log.V(logEvents).Info("waiting for callback", "addr", listener.Addr().String())
+	defer func() {
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+	errChannel := r.serveLocalhost(srv, listener, callback)
+
+	for _, present := range o.present {
+		if err = present(urlPath); err != nil {
+			log.Error(err, "authorization URL could not be presented", "error_class", errorClass(err))
+			return nil, err
+		}
+	}
+
+	select {
+	case serveErr := <-errChannel:
+		log.Error(serveErr, "callback server failed", "error_class", errorClass(serveErr))
+		return nil, serveErr
+	case outcome := <-outcomes:
+		return outcome.authorization()
+	case <-ctx.Done():
+		log.Info("stopped waiting for callback", "error_class", errorClass(ctx.Err()))
+		return nil, ctx.Err()
+	}
+}
+
+// localhostServer builds the callback server and binds its listener on host
+// and the callback URL's port.
+func (r *EVESSO) localhostServer(callback *url.URL, host string, handler http.Handler) (*http.Server, net.Listener, error) {
+	srv := &http.Server{Handler: handler}
+
+	if callback.Scheme == "https" && r.AppConfig().Autocert {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(callback.Hostname()),
+			Cache:      autocert.DirCache(r.AppConfig().AutocertCache),
+		}
+		srv.TLSConfig = manager.TLSConfig()
+	}
+
+	port := callback.Port()
+	switch {
+	case port == "" && callback.Scheme == "http":
+		port = "80"
+	case port == "":
+		port = "443"
+	}
+	// listen before presenting the URL, so a fast browser cannot beat the server
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, nil, err
+	}
+	return srv, listener, nil
+}
+
+// serveLocalhost serves srv on listener, with TLS when the callback URL needs
+// it. The channel receives the serve error, nil after a clean shutdown.
+func (r *EVESSO) serveLocalhost(srv *http.Server, listener net.Listener, callback *url.URL) <-chan error {
+	errChannel := make(chan error, 1)
+	go func() {
+		var serveErr error
+		switch {
+		case callback.Scheme == "https" && r.AppConfig().Autocert:
+			serveErr = srv.ServeTLS(listener, "", "")
+		case callback.Scheme == "https":
+			serveErr = srv.ServeTLS(listener, r.AppConfig().TLSCert, r.AppConfig().TLSKey)
+		default:
+			serveErr = srv.Serve(listener)
+		}
+		if errors.Is(serveErr, http.ErrServerClosed) {
+			serveErr = nil
+		}
+		errChannel <- serveErr
+	}()
+	return errChannel
+}
@@ -0,0 +1,124 @@
+package evesso_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+// freeCallback returns a loopback callback URL on a port nothing listens on.
+func freeCallback(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return fmt.Sprintf("http://%s/callback", l.Addr())
+}
+
+// tlsFiles writes a self-signed certificate for 127.0.0.1 and its key and
+// returns their paths.
+func tlsFiles(t *testing.T) (cert, key string) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	cert, key = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err = os.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// TestLocalhostAuthTLSFiles checks that an https callback on an explicit port
+// is served with the configured certificate.
+func TestLocalhostAuthTLSFiles(t *testing.T) {
+	cfg := evessotest.Config()
+	cfg.Callback = strings.Replace(freeCallback(t), "http:", "https:", 1)
+	cfg.TLSCert, cfg.TLSKey = tlsFiles(t)
+	srv, sso, store := evessotest.NewSSO(t, evesso.WithConfig(cfg))
+	profile, err := store.NewProfile(context.Background(), "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := sso.TokenSource(profile.GetID(), evessotest.Pilot.Name, "publicData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	urlPath, err := source.AuthURL(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	browser := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	browsed := make(chan error, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	auth, err := sso.LocalhostAuthContext(ctx, urlPath,
+		evesso.WithBindAddress("127.0.0.1"),
+		evesso.WithURLHandler(func(authURL string) {
+			go func() {
+				callback, err := srv.Approve(authURL)
+				if err != nil {
+					browsed <- err
+					return
+				}
+				resp, err := browser.Get(callback)
+				if err != nil {
+					browsed <- err
+					// the callback was not served over TLS; stop waiting for it
+					cancel()
+					return
+				}
+				resp.Body.Close()
+			}()
+		}),
+	)
+	if err != nil {
+		select {
+		case browseErr := <-browsed:
+			t.Fatal(browseErr)
+		default:
+			t.Fatal(err)
+		}
+	}
+	if auth.Character.GetCharacterID() != evessotest.Pilot.ID {
+		t.Errorf("authorized %d", auth.Character.GetCharacterID())
+	}
+}
@@ -0,0 +1,88 @@
+package evesso
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/oauth2"
+)
+
+// Log verbosity: failures and deactivations are logged at V(0), completed
+// authorizations, refreshes and deletions at V(1), and authorizations being
+// started at V(2). Tokens, codes and PKCE verifiers are never logged.
+const (
+	logEvents = 1
+	logDetail = 2
+)
+
+// logger returns the logger in ctx, or the one New was given.
+func (r *EVESSO) logger(ctx context.Context) logr.Logger {
+	if log, err := logr.FromContext(ctx); err == nil {
+		return log
+	}
+	return r.log
+}
+
+// errorClass names the kind of err for the error_class log field, so failures
+// can be grouped without parsing messages.
+func errorClass(err error) string {
+	var retrieveError *oauth2.RetrieveError
+	var netError net.Error
+	var callbackError *CallbackError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &callbackError):
+		return kindClass(callbackError.Kind)
+	case errors.As(err, &retrieveError):
+		return "sso_rejected"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &netError):
+		return "network"
+	default:
+		return "internal"
+	}
+}
+
+// kindClass is the error_class of a callback outcome.
+func kindClass(kind OutcomeKind) string {
+	return strings.ReplaceAll(kind.String(), " ", "_")
+}
+
+// characterFields are the log fields identifying c.
+func characterFields(c Character) []interface{} {
+	if c == nil {
+		return nil
+	}
+	return []interface{}{
+		"character_id", c.GetCharacterID(),
+		"profile_id", c.GetProfileID(),
+		"scope_count", len(c.GetScopes()),
+	}
+}
+
+// logOutcome logs how a callback ended. Unknown and expired states are routine
+// (reloads, bots, slow users) and logged as info; the rest are errors.
+func (r *EVESSO) logOutcome(ctx context.Context, cause Cause, state string, outcome *Outcome) {
+	log := r.logger(ctx).WithValues("cause", cause, "state", state)
+	if outcome.Profile != nil {
+		log = log.WithValues("profile_id", outcome.Profile.GetID())
+	}
+	switch outcome.Kind {
+	case OutcomeSuccess:
+		log.V(logEvents).Info("character authorized",
+			"character_id", outcome.Claims.CharacterID(),
+			"scope_count", len(outcome.Claims.Scopes()),
+		)
+	case OutcomeUnknownState, OutcomeExpired:
+		log.Info("callback rejected", "error_class", kindClass(outcome.Kind), "error", outcome.Err.Error())
+	default:
+		log.Error(outcome.Err, "callback failed", "error_class", kindClass(outcome.Kind))
+	}
+}
@@ -0,0 +1,211 @@
+package evesso
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures an EVESSO built by New.
+type Option func(*options)
+
+type options struct {
+	config     *Config
+	configPath string
+	secrets    SecretProvider
+	store      DataStore
+	client     *http.Client
+	issuer     string
+	metadata   *Metadata
+	intervals  intervals
+	retry      retryPolicy
+	log        *logr.Logger
+	renderer   Renderer
+	documents  DocumentCache
+	tracers    trace.TracerProvider
+	meters     metric.MeterProvider
+}
+
+// intervals are the timings of the JWKS cache and the Start jobs.
+type intervals struct {
+	jwks        time.Duration
+	jwksCheck   time.Duration
+	jwksRefetch time.Duration
+	discovery   time.Duration
+	pkceCleanup time.Duration
+	prune       time.Duration
+	pruneAfter  time.Duration
+}
+
+var defaultIntervals = intervals{
+	jwks:        5 * time.Minute,
+	jwksCheck:   time.Minute,
+	jwksRefetch: 30 * time.Second,
+	discovery:   time.Hour,
+	pkceCleanup: time.Minute,
+	prune:       time.Hour,
+}
+
+// WithConfig uses cfg as the application configuration as given; only a
+// SecretProvider is still consulted. It takes precedence over WithConfigFile.
+func WithConfig(cfg *Config) Option {
+	return func(o *options) {
+		o.config = cfg
+	}
+}
+
+// WithConfigFile loads the application configuration from a YAML or JSON file,
+// overridden by the environment as LoadConfig describes. Without WithConfig or
+// WithConfigFile, the configuration comes from the environment alone.
+func WithConfigFile(path string) Option {
+	return func(o *options) {
+		o.configPath = path
+	}
+}
+
+// WithSecretProvider resolves the client secret and DSN through secrets,
+// overriding every other configuration source.
+func WithSecretProvider(secrets SecretProvider) Option {
+	return func(o *options) {
+		o.secrets = secrets
+	}
+}
+
+// WithStore sets the DataStore characters and PKCE rows are kept in. New sets
+// it up with the configured DSN.
+func WithStore(store DataStore) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
+
+// WithHTTPClient sets the client used for discovery, JWKS fetches and every
+// token request. The default has a 5 minute timeout.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithIssuer points discovery at another SSO base URL, such as a local
+// stand-in or a different cluster, instead of ISSUER_URL. Tokens carrying the
+// issuer or its host name are accepted alongside VALID_ISSUERS.
+func WithIssuer(issuer string) Option {
+	return func(o *options) {
+		o.issuer = issuer
+	}
+}
+
+// WithMetadata skips discovery and uses md as the SSO metadata document.
+func WithMetadata(md *Metadata) Option {
+	return func(o *options) {
+		o.metadata = md
+	}
+}
+
+// WithDiscoveryRetry sets how many times discovery is attempted before New
+// gives up, and the wait before the first retry, which doubles after each.
+// The default is 4 attempts starting at half a second.
+func WithDiscoveryRetry(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retry = retryPolicy{attempts: attempts, backoff: backoff}
+	}
+}
+
+// WithJWKSInterval sets how often the JWKS is refetched. The default is 5
+// minutes.
+func WithJWKSInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.intervals.jwks = interval
+	}
+}
+
+// WithJWKSRefetchInterval sets the least time between refetches forced by a
+// token signed with a key the JWKS lacks. The default is 30 seconds.
+func WithJWKSRefetchInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.intervals.jwksRefetch = interval
+	}
+}
+
+// WithDocumentCache keeps the last good discovery document and JWKS in cache,
+// for example a NewFileCache, instead of the store. New falls back to them
+// when SSO is unreachable.
+func WithDocumentCache(cache DocumentCache) Option {
+	return func(o *options) {
+		o.documents = cache
+	}
+}
+
+// WithDiscoveryInterval sets how often Start's job fetches the discovery
+// document again to report changes. The default is an hour; zero disables the
+// job.
+func WithDiscoveryInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.intervals.discovery = interval
+	}
+}
+
+// WithLogger sets the logger. Without it, New uses the logger in its context,
+// if any.
+func WithLogger(log logr.Logger) Option {
+	return func(o *options) {
+		o.log = &log
+	}
+}
+
+// WithRenderer sets what writes the page a browser sees at the end of a
+// callback. The default is DefaultRenderer.
+func WithRenderer(renderer Renderer) Option {
+	return func(o *options) {
+		o.renderer = renderer
+	}
+}
+
+// WithPKCECleanupInterval sets how often Start's job deletes expired PKCE
+// rows. The default is a minute; zero disables the job.
+func WithPKCECleanupInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.intervals.pkceCleanup = interval
+	}
+}
+
+// WithPruneInactive makes Start delete characters that have been inactive for
+// longer than after, checking every interval. It needs a store that implements
+// CharacterPruner. Pruning is off by default.
+func WithPruneInactive(after, interval time.Duration) Option {
+	return func(o *options) {
+		o.intervals.pruneAfter = after
+		o.intervals.prune = interval
+	}
+}
+
+// WithJWKSCheckInterval sets how often Start's job checks that the JWKS is
+// still being refreshed, forcing a refetch if not. The default is a minute;
+// zero disables the job.
+func WithJWKSCheckInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.intervals.jwksCheck = interval
+	}
+}
+
+// WithTracerProvider traces discovery, JWKS lookups, code exchanges, refreshes
+// and token validation with provider, and hands it to a store that implements
+// TracerSetter. Without it nothing is traced.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracers = provider
+	}
+}
+
+// WithMeterProvider records refresh latency and outcome, deactivations, PKCE
+// creations and expiries, JWKS fetch errors and access token time to expiry
+// with provider. Without it nothing is measured.
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(o *options) {
+		o.meters = provider
+	}
+}
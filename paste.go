@@ -0,0 +1,87 @@
+package evesso
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// ErrPastedCallback is returned for pasted input that carries no code and state.
+var ErrPastedCallback = errors.New("pasted callback needs a code and a state")
+
+// Complete finishes an authorization from the code and state SSO sent to the
+// callback, for flows where the callback never reaches this process. It does
+// what ServeHTTP does, and a failed callback returns a *CallbackError.
+func (r *EVESSO) Complete(ctx context.Context, code, state string) (*Authorization, error) {
+	return r.complete(ctx, CausePaste, code, state).authorization()
+}
+
+// CompleteCallback is Complete for input pasted by a user: the callback URL the
+// browser ended up on, its query string, or the code and state separated by
+// whitespace.
+func (r *EVESSO) CompleteCallback(ctx context.Context, pasted string) (*Authorization, error) {
+	code, state, err := parseCallback(pasted)
+	if err != nil {
+		return nil, err
+	}
+	return r.Complete(ctx, code, state)
+}
+
+// PasteAuth is the out-of-band counterpart of LocalhostAuthContext, for when
+// the browser cannot reach this host. It writes urlPath to out, asks for the
+// callback URL the browser lands on and reads it as one line from in.
+//
+// When ctx ends first, PasteAuth returns at once, but the read it started on
+// in stays blocked until a line arrives or in fails, and that line is then
+// lost. Pass a reader you can close, such as a pipe, to end the read too; with
+// os.Stdin the read lasts until the user presses enter.
+func (r *EVESSO) PasteAuth(ctx context.Context, urlPath string, in io.Reader, out io.Writer) (*Authorization, error) {
+	if _, err := fmt.Fprintf(out, "Open this URL in any browser to authorize:\n\n%s\n\nThen paste the URL the browser was redirected to: ", urlPath); err != nil {
+		return nil, err
+	}
+	lines := make(chan string, 1)
+	errs := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+			errs <- err
+			return
+		}
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		return r.CompleteCallback(ctx, line)
+	case err := <-errs:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// parseCallback takes the code and state out of pasted input.
+func parseCallback(pasted string) (code, state string, err error) {
+	pasted = strings.TrimSpace(pasted)
+	if fields := strings.Fields(pasted); len(fields) == 2 && !strings.ContainsAny(pasted, "?=&") {
+		return fields[0], fields[1], nil
+	}
+	query := pasted
+	if i := strings.IndexByte(pasted, '?'); i >= 0 {
+		query = pasted[i+1:]
+	}
+	// a fragment would otherwise stick to the last parameter
+	query, _, _ = strings.Cut(query, "#")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %w", ErrPastedCallback, err)
+	}
+	code, state = values.Get("code"), values.Get("state")
+	if code == "" || state == "" {
+		return "", "", ErrPastedCallback
+	}
+	return code, state, nil
+}
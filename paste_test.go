@@ -0,0 +1,124 @@
+package evesso_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+func TestCompleteCallback(t *testing.T) {
+	tests := []struct {
+		name string
+		// paste turns the callback URL the browser landed on into what the
+		// user pastes
+		paste   func(callback *url.URL) string
+		wantErr error
+	}{
+		{name: "callback URL", paste: func(u *url.URL) string { return u.String() }},
+		{name: "callback URL with a newline", paste: func(u *url.URL) string { return "  " + u.String() + "\r\n" }},
+		{name: "callback URL with a fragment", paste: func(u *url.URL) string { return u.String() + "#_=_" }},
+		{name: "query string", paste: func(u *url.URL) string { return u.RawQuery }},
+		{name: "query string with its ?", paste: func(u *url.URL) string { return "?" + u.RawQuery }},
+		{name: "code and state", paste: func(u *url.URL) string { return u.Query().Get("code") + " " + u.Query().Get("state") }},
+		{name: "code and state on a tab", paste: func(u *url.URL) string { return u.Query().Get("code") + "\t" + u.Query().Get("state") + "\n" }},
+		{name: "nothing", paste: func(*url.URL) string { return "" }, wantErr: evesso.ErrPastedCallback},
+		{name: "a word", paste: func(*url.URL) string { return "hello" }, wantErr: evesso.ErrPastedCallback},
+		{name: "three words", paste: func(*url.URL) string { return "a b c" }, wantErr: evesso.ErrPastedCallback},
+		{name: "code only", paste: func(u *url.URL) string { return "code=" + u.Query().Get("code") }, wantErr: evesso.ErrPastedCallback},
+		{name: "URL without a query", paste: func(*url.URL) string { return "http://localhost/callback" }, wantErr: evesso.ErrPastedCallback},
+		{name: "malformed query", paste: func(*url.URL) string { return "?code=%zz&state=x" }, wantErr: evesso.ErrPastedCallback},
+		{
+			name:  "state of another authorization",
+			paste: func(u *url.URL) string { return u.Query().Get("code") + " 00000000-0000-0000-0000-000000000000" },
+			// parsed, then refused like an unknown state at the callback
+			wantErr: &evesso.CallbackError{Kind: evesso.OutcomeUnknownState},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, sso, store := evessotest.NewSSO(t)
+			profile, err := store.NewProfile(context.Background(), "default", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pkce, err := sso.CreatePKCE(context.Background(), profile, "", nil, "publicData")
+			if err != nil {
+				t.Fatal(err)
+			}
+			callback, err := url.Parse(approve(t, srv, sso.AuthUrl(pkce)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			auth, err := sso.CompleteCallback(context.Background(), tt.paste(callback))
+			var callbackErr *evesso.CallbackError
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if auth.Claims.CharacterID() != evessotest.Pilot.ID {
+					t.Errorf("authorized %d", auth.Claims.CharacterID())
+				}
+			case *evesso.CallbackError:
+				if !errors.As(err, &callbackErr) || callbackErr.Kind != want.Kind {
+					t.Fatalf("err = %v, want a callback %s", err, want.Kind)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Fatalf("err = %v, want %v", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPasteAuth(t *testing.T) {
+	srv, sso, store := evessotest.NewSSO(t)
+	profile, err := store.NewProfile(context.Background(), "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkce, err := sso.CreatePKCE(context.Background(), profile, "", nil, "publicData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL := sso.AuthUrl(pkce)
+	callback := approve(t, srv, authURL)
+
+	t.Run("pasted without a newline", func(t *testing.T) {
+		var out bytes.Buffer
+		auth, err := sso.PasteAuth(context.Background(), authURL, strings.NewReader(callback), &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth.Claims.CharacterID() != evessotest.Pilot.ID {
+			t.Errorf("authorized %d", auth.Claims.CharacterID())
+		}
+		if !strings.Contains(out.String(), authURL) {
+			t.Errorf("output does not show the URL:\n%s", out.String())
+		}
+	})
+	t.Run("nothing pasted", func(t *testing.T) {
+		if _, err := sso.PasteAuth(context.Background(), authURL, strings.NewReader(""), io.Discard); !errors.Is(err, io.EOF) {
+			t.Fatalf("err = %v, want io.EOF", err)
+		}
+	})
+	t.Run("context ends first", func(t *testing.T) {
+		in, w := io.Pipe()
+		defer w.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := sso.PasteAuth(ctx, authURL, in, io.Discard); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("err = %v, want the context's", err)
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package evesso
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ScopePickerOption configures ScopePicker.
+type ScopePickerOption func(*scopePicker)
+
+// WithRequiredScopes are always requested. The page shows them checked and
+// locked.
+func WithRequiredScopes(scopes ...string) ScopePickerOption {
+	return func(p *scopePicker) {
+		p.required = append(p.required, scopes...)
+	}
+}
+
+// WithPreselectedScopes are checked when the page opens; the user may uncheck
+// them.
+func WithPreselectedScopes(scopes ...string) ScopePickerOption {
+	return func(p *scopePicker) {
+		p.preselected = append(p.preselected, scopes...)
+	}
+}
+
+// WithOfferedScopes limits the page to scopes instead of ALL_SCOPES. Required
+// scopes are offered either way.
+func WithOfferedScopes(scopes ...string) ScopePickerOption {
+	return func(p *scopePicker) {
+		p.offered = scopes
+	}
+}
+
+// ScopePicker returns a handler that lets the user choose which scopes to
+// grant. GET renders a page of checkboxes grouped by category: wallet,
+// assets, skills and so on, from the scope names. POST creates a PKCE on the
+// profile that profile returns for the request, with exactly the required and
+// checked scopes, and redirects to SSO. A scope that was not offered is
+// rejected. A POST from another site is rejected with 403 Forbidden, so a
+// foreign page cannot start an authorization in the user's name. An error
+// from profile is answered with 403 Forbidden too; put the handler behind
+// your login to redirect instead.
+func (r *EVESSO) ScopePicker(profile func(req *http.Request) (Profile, error), opts ...ScopePickerOption) http.Handler {
+	p := &scopePicker{sso: r, profile: profile, offered: ALL_SCOPES}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.offered = append(slices.Clone(p.offered), p.required...)
+	slices.Sort(p.offered)
+	p.offered = slices.Compact(p.offered)
+	return p
+}
+
+type scopePicker struct {
+	sso         *EVESSO
+	profile     func(req *http.Request) (Profile, error)
+	required    []string
+	preselected []string
+	offered     []string
+}
+
+func (p *scopePicker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		p.page(w)
+	case http.MethodPost:
+		p.start(w, req)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (p *scopePicker) start(w http.ResponseWriter, req *http.Request) {
+	log := p.sso.logger(req.Context())
+	if !sameOrigin(req) {
+		log.V(logEvents).Info("scope picker rejected a cross-site request", "origin", req.Header.Get("Origin"))
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "the form could not be read", http.StatusBadRequest)
+		return
+	}
+	scopes := slices.Clone(p.required)
+	for _, scope := range req.PostForm["scope"] {
+		if _, ok := slices.BinarySearch(p.offered, scope); !ok {
+			http.Error(w, "scope "+scope+" is not offered", http.StatusBadRequest)
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+
+	profile, err := p.profile(req)
+	if err != nil {
+		log.V(logEvents).Info("scope picker has no profile for the request", "error_class", errorClass(err), "error", err.Error())
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	pkce, err := p.sso.CreatePKCE(req.Context(), profile, "", nil, scopes...)
+	if err != nil {
+		log.Error(err, "scope picker authorization could not start", "profile_id", profile.GetID(), "error_class", errorClass(err))
+		http.Error(w, "the authorization could not be started", http.StatusInternalServerError)
+		return
+	}
+	log.V(logDetail).Info("authorization started",
+		"profile_id", profile.GetID(),
+		"state", pkce.GetState(),
+		"scope_count", len(scopes),
+	)
+	http.Redirect(w, req, p.sso.AuthUrl(pkce), http.StatusSeeOther)
+}
+
+// sameOrigin reports whether req came from a page of the same site. Browsers
+// send Sec-Fetch-Site, older ones only Origin; a request with neither is not
+// from a browser, which a cross-site forgery needs.
+func sameOrigin(req *http.Request) bool {
+	if site := req.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == req.Host
+}
+
+// otherScopes is the category of scopes not named esi-<category>.<action>.
+const otherScopes = "Other"
+
+type pickerScope struct {
+	Name, Label       string
+	Checked, Required bool
+}
+
+type pickerCategory struct {
+	Name   string
+	Scopes []pickerScope
+}
+
+func (p *scopePicker) page(w http.ResponseWriter) {
+	var categories []pickerCategory
+	for _, scope := range p.offered {
+		category, label := describeScope(scope)
+		i := slices.IndexFunc(categories, func(c pickerCategory) bool { return c.Name == category })
+		if i < 0 {
+			i = len(categories)
+			categories = append(categories, pickerCategory{Name: category})
+		}
+		required := slices.Contains(p.required, scope)
+		categories[i].Scopes = append(categories[i].Scopes, pickerScope{
+			Name:     scope,
+			Label:    label,
+			Required: required,
+			Checked:  required || slices.Contains(p.preselected, scope),
+		})
+	}
+	// alphabetical, with what does not fit last
+	slices.SortStableFunc(categories, func(a, b pickerCategory) int {
+		if (a.Name == otherScopes) != (b.Name == otherScopes) {
+			if a.Name == otherScopes {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = pickerPage.Execute(w, categories)
+}
+
+// describeScope splits a scope such as esi-wallet.read_character_wallet.v1
+// into its category, "Wallet", and a label, "Read character wallet". Names
+// that do not follow the pattern are their own label under otherScopes.
+func describeScope(scope string) (category, label string) {
+	rest, ok := strings.CutPrefix(scope, "esi-")
+	if !ok {
+		return otherScopes, scope
+	}
+	category, action, ok := strings.Cut(rest, ".")
+	if !ok || category == "" {
+		return otherScopes, scope
+	}
+	action, _, _ = strings.Cut(action, ".")
+	category = strings.ToUpper(category[:1]) + strings.ReplaceAll(category[1:], "_", " ")
+	label = strings.ReplaceAll(action, "_", " ")
+	if label != "" {
+		label = strings.ToUpper(label[:1]) + label[1:]
+	}
+	return category, label
+}
+
+var pickerPage = template.Must(template.New("picker").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Choose what to share</title></head>
+<body style="font-family:sans-serif;max-width:40em;margin:4em auto">
+<h1>Choose what to share</h1>
+<p>Pick what this application may access for your character. You confirm the same list on the EVE SSO page next.</p>
+<form method="post">
+{{- range .}}
+<fieldset>
+<legend>{{.Name}}</legend>
+{{- range .Scopes}}
+<label title="{{.Name}}"><input type="checkbox" name="scope" value="{{.Name}}"{{if .Checked}} checked{{end}}{{if .Required}} disabled{{end}}> {{.Label}}{{if .Required}} (required){{end}}</label><br>
+{{- end}}
+</fieldset>
+{{- end}}
+<p><button type="submit">Log in with EVE Online</button></p>
+</form>
+</body>
+</html>
+`))
@@ -0,0 +1,78 @@
+package evesso_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+func TestScopePicker(t *testing.T) {
+	const wallet = "esi-wallet.read_character_wallet.v1"
+	tests := []struct {
+		name    string
+		method  string
+		scopes  []string
+		headers map[string]string
+		// noProfile makes the profile callback fail
+		noProfile bool
+		status    int
+		// granted are the scopes SSO is asked for on a redirect, sorted
+		granted []string
+	}{
+		{name: "page", method: http.MethodGet, status: http.StatusOK},
+		{name: "required only", method: http.MethodPost, status: http.StatusSeeOther, granted: []string{"publicData"}},
+		{name: "offered scope", method: http.MethodPost, scopes: []string{wallet}, status: http.StatusSeeOther, granted: []string{wallet, "publicData"}},
+		{name: "scope not offered", method: http.MethodPost, scopes: []string{"esi-mail.send_mail.v1"}, status: http.StatusBadRequest},
+		{name: "same origin", method: http.MethodPost, headers: map[string]string{"Sec-Fetch-Site": "same-origin"}, status: http.StatusSeeOther, granted: []string{"publicData"}},
+		{name: "cross site", method: http.MethodPost, headers: map[string]string{"Sec-Fetch-Site": "cross-site"}, status: http.StatusForbidden},
+		{name: "same site, other origin", method: http.MethodPost, headers: map[string]string{"Sec-Fetch-Site": "same-site"}, status: http.StatusForbidden},
+		{name: "Origin of this host", method: http.MethodPost, headers: map[string]string{"Origin": "http://app.example.com"}, status: http.StatusSeeOther, granted: []string{"publicData"}},
+		{name: "Origin of another host", method: http.MethodPost, headers: map[string]string{"Origin": "https://evil.example.com"}, status: http.StatusForbidden},
+		{name: "no profile", method: http.MethodPost, noProfile: true, status: http.StatusForbidden},
+		{name: "PUT", method: http.MethodPut, status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, sso, store := evessotest.NewSSO(t)
+			profile, err := store.NewProfile(context.Background(), "default", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			picker := sso.ScopePicker(func(*http.Request) (evesso.Profile, error) {
+				if tt.noProfile {
+					return nil, errors.New("not logged in")
+				}
+				return profile, nil
+			}, evesso.WithRequiredScopes("publicData"), evesso.WithOfferedScopes(wallet))
+
+			form := url.Values{"scope": tt.scopes}
+			req := httptest.NewRequest(tt.method, "http://app.example.com/scopes", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			picker.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code != http.StatusSeeOther {
+				return
+			}
+			authURL, err := url.Parse(rec.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := authURL.Query().Get("scope"); got != strings.Join(tt.granted, " ") {
+				t.Errorf("scope = %q, want %q", got, strings.Join(tt.granted, " "))
+			}
+		})
+	}
+}
@@ -0,0 +1,510 @@
+// Package admin is a JSON REST API over an EVESSO's store, for operators who
+// need to inspect or fix profiles, characters and pending authorizations
+// without a database shell. The API is described by the OpenAPI document it
+// serves at /openapi.json.
+package admin
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/uuid"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/httpapi"
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+// maxBody bounds request bodies.
+const maxBody = 1 << 20
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithLogger logs changes made through the API, with the principal that made
+// them. Nothing is logged by default.
+func WithLogger(log logr.Logger) Option {
+	return func(h *Handler) {
+		h.log = log
+	}
+}
+
+// WithErrorStatus sets how store errors map to HTTP statuses. The default is
+// httpapi.StoreStatus.
+func WithErrorStatus(status func(err error) int) Option {
+	return func(h *Handler) {
+		h.status = status
+	}
+}
+
+// Handler serves the API. Mount it under a prefix with http.StripPrefix.
+type Handler struct {
+	sso          *evesso.EVESSO
+	store        evesso.DataStore
+	authenticate httpapi.Authenticator
+	log          logr.Logger
+	status       func(err error) int
+	mux          *http.ServeMux
+}
+
+// New returns the API over sso's store. Every request goes through
+// authenticate first; a nil Authenticator rejects them all.
+func New(sso *evesso.EVESSO, authenticate httpapi.Authenticator, opts ...Option) *Handler {
+	h := &Handler{
+		sso:          sso,
+		store:        sso.Store(),
+		authenticate: authenticate,
+		log:          logr.Discard(),
+		status:       httpapi.StoreStatus,
+		mux:          http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.mux.HandleFunc("GET /openapi.json", h.openAPI)
+	h.mux.HandleFunc("GET /scopes", h.scopes)
+	h.mux.HandleFunc("GET /profiles", h.listProfiles)
+	h.mux.HandleFunc("POST /profiles", h.createProfile)
+	h.mux.HandleFunc("GET /profiles/{profile}", h.getProfile)
+	h.mux.HandleFunc("PATCH /profiles/{profile}", h.renameProfile)
+	h.mux.HandleFunc("DELETE /profiles/{profile}", h.deleteProfile)
+	h.mux.HandleFunc("GET /characters", h.listAllCharacters)
+	h.mux.HandleFunc("GET /profiles/{profile}/characters", h.listCharacters)
+	h.mux.HandleFunc("GET /profiles/{profile}/characters/{character}", h.getCharacter)
+	h.mux.HandleFunc("PATCH /profiles/{profile}/characters/{character}", h.setActive)
+	h.mux.HandleFunc("DELETE /profiles/{profile}/characters/{character}", h.deleteCharacter)
+	h.mux.HandleFunc("GET /authorizations", h.listAllAuthorizations)
+	h.mux.HandleFunc("GET /profiles/{profile}/authorizations", h.listAuthorizations)
+	h.mux.HandleFunc("POST /profiles/{profile}/authorizations", h.startAuthorization)
+	return h
+}
+
+type principalKey struct{}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.authenticate == nil {
+		writeError(w, http.StatusUnauthorized, httpapi.ErrUnauthenticated)
+		return
+	}
+	principal, err := h.authenticate(req)
+	if err != nil {
+		writeError(w, httpapi.AuthStatus(err), err)
+		return
+	}
+	h.mux.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), principalKey{}, principal)))
+}
+
+// logger returns the logger with the principal of req.
+func (h *Handler) logger(req *http.Request) logr.Logger {
+	principal, _ := req.Context().Value(principalKey{}).(string)
+	return h.log.WithValues("principal", principal)
+}
+
+// Profile is a profile as the API shows it.
+type Profile struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	Data any       `json:"data,omitempty"`
+}
+
+// Character is a character as the API shows it. Tokens are never shown.
+type Character struct {
+	ID            uuid.UUID `json:"id"`
+	ProfileID     uuid.UUID `json:"profile_id"`
+	CharacterID   int32     `json:"character_id"`
+	CharacterName string    `json:"character_name"`
+	Owner         string    `json:"owner"`
+	Scopes        []string  `json:"scopes"`
+	Active        bool      `json:"active"`
+	ReferenceData any       `json:"reference_data,omitempty"`
+}
+
+// Authorization is a pending PKCE row as the API shows it. The code verifier
+// is never shown.
+type Authorization struct {
+	ID        uuid.UUID `json:"id"`
+	ProfileID uuid.UUID `json:"profile_id"`
+	State     uuid.UUID `json:"state"`
+	Scopes    []string  `json:"scopes"`
+	ReturnURL string    `json:"return_url,omitempty"`
+	Created   time.Time `json:"created_at"`
+	Expires   time.Time `json:"expires_at"`
+	AuthURL   string    `json:"auth_url,omitempty"`
+}
+
+func profileView(p evesso.Profile) Profile {
+	return Profile{ID: p.GetID(), Name: p.GetName(), Data: p.GetData()}
+}
+
+func characterView(c evesso.Character) Character {
+	return Character{
+		ID:            c.GetID(),
+		ProfileID:     c.GetProfileID(),
+		CharacterID:   c.GetCharacterID(),
+		CharacterName: c.GetCharacterName(),
+		Owner:         c.GetOwner(),
+		Scopes:        nonNil(c.GetScopes()),
+		Active:        c.IsActive(),
+		ReferenceData: c.GetReferenceData(),
+	}
+}
+
+func authorizationView(p evesso.PKCE) Authorization {
+	return Authorization{
+		ID:        p.GetID(),
+		ProfileID: p.GetProfileID(),
+		State:     p.GetState(),
+		Scopes:    nonNil(p.GetScopes()),
+		ReturnURL: evesso.ReturnURL(p),
+		Created:   p.Time(),
+		Expires:   p.Time().Add(evesso.PKCELifetime),
+	}
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func (h *Handler) openAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPI)
+}
+
+func (h *Handler) scopes(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, evesso.ALL_SCOPES)
+}
+
+func (h *Handler) listProfiles(w http.ResponseWriter, req *http.Request) {
+	profiles, err := h.store.AllProfiles(req.Context())
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	views := make([]Profile, 0, len(profiles))
+	for _, p := range profiles {
+		views = append(views, profileView(p))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (h *Handler) createProfile(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Name string `json:"name"`
+		Data any    `json:"data"`
+	}
+	if !readJSON(w, req, &body) {
+		return
+	}
+	if body.Name == "" {
+		writeError(w, http.StatusBadRequest, errors.New("name is required"))
+		return
+	}
+	profile, err := h.store.NewProfile(req.Context(), body.Name, body.Data)
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	h.logger(req).Info("admin profile created", "profile_id", profile.GetID(), "profile_name", body.Name)
+	writeJSON(w, http.StatusCreated, profileView(profile))
+}
+
+func (h *Handler) getProfile(w http.ResponseWriter, req *http.Request) {
+	profile, ok := h.profile(w, req)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, profileView(profile))
+}
+
+func (h *Handler) renameProfile(w http.ResponseWriter, req *http.Request) {
+	profile, ok := h.profile(w, req)
+	if !ok {
+		return
+	}
+	var body struct {
+		Name string `json:"name"`
+	}
+	if !readJSON(w, req, &body) {
+		return
+	}
+	if body.Name == "" {
+		writeError(w, http.StatusBadRequest, errors.New("name is required"))
+		return
+	}
+	old := profile.GetName()
+	if err := profile.Rename(req.Context(), body.Name); err != nil {
+		h.fail(w, err)
+		return
+	}
+	h.logger(req).Info("admin profile renamed", "profile_id", profile.GetID(), "old_name", old, "profile_name", body.Name)
+	writeJSON(w, http.StatusOK, profileView(profile))
+}
+
+func (h *Handler) deleteProfile(w http.ResponseWriter, req *http.Request) {
+	profile, ok := h.profile(w, req)
+	if !ok {
+		return
+	}
+	err := h.sso.DeleteProfile(req.Context(), profile, deleteOptions(req)...)
+	if !h.deleted(w, err) {
+		return
+	}
+	h.logDeleted(req, err, "admin profile deleted", "profile_id", profile.GetID())
+}
+
+func (h *Handler) listAllCharacters(w http.ResponseWriter, req *http.Request) {
+	profiles, err := h.store.AllProfiles(req.Context())
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	views := make([]Character, 0, len(profiles))
+	for _, p := range profiles {
+		characters, err := p.AllCharacters(req.Context())
+		if err != nil {
+			h.fail(w, err)
+			return
+		}
+		for _, c := range characters {
+			views = append(views, characterView(c))
+		}
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (h *Handler) listCharacters(w http.ResponseWriter, req *http.Request) {
+	profile, ok := h.profile(w, req)
+	if !ok {
+		return
+	}
+	characters, err := profile.AllCharacters(req.Context())
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	views := make([]Character, 0, len(characters))
+	for _, c := range characters {
+		views = append(views, characterView(c))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (h *Handler) getCharacter(w http.ResponseWriter, req *http.Request) {
+	character, ok := h.character(w, req)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, characterView(character))
+}
+
+func (h *Handler) setActive(w http.ResponseWriter, req *http.Request) {
+	character, ok := h.character(w, req)
+	if !ok {
+		return
+	}
+	var body struct {
+		Active *bool `json:"active"`
+	}
+	if !readJSON(w, req, &body) {
+		return
+	}
+	if body.Active == nil {
+		writeError(w, http.StatusBadRequest, errors.New("active is required"))
+		return
+	}
+	if err := character.UpdateActiveState(req.Context(), *body.Active); err != nil {
+		h.fail(w, err)
+		return
+	}
+	h.logger(req).Info("admin character active state changed", "character_id", character.GetCharacterID(), "profile_id", character.GetProfileID(), "active", *body.Active)
+	// re-read, a store need not update the value it handed out
+	character, ok = h.character(w, req)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, characterView(character))
+}
+
+func (h *Handler) deleteCharacter(w http.ResponseWriter, req *http.Request) {
+	character, ok := h.character(w, req)
+	if !ok {
+		return
+	}
+	err := h.sso.DeleteCharacter(req.Context(), character, deleteOptions(req)...)
+	if !h.deleted(w, err) {
+		return
+	}
+	h.logDeleted(req, err, "admin character deleted", "character_id", character.GetCharacterID(), "profile_id", character.GetProfileID())
+}
+
+func (h *Handler) listAllAuthorizations(w http.ResponseWriter, req *http.Request) {
+	h.writeAuthorizations(w, req, uuid.Nil)
+}
+
+func (h *Handler) listAuthorizations(w http.ResponseWriter, req *http.Request) {
+	profile, ok := h.profile(w, req)
+	if !ok {
+		return
+	}
+	h.writeAuthorizations(w, req, profile.GetID())
+}
+
+// writeAuthorizations writes the pending authorizations of profileID, or all
+// of them for uuid.Nil.
+func (h *Handler) writeAuthorizations(w http.ResponseWriter, req *http.Request, profileID uuid.UUID) {
+	lister, ok := h.store.(evesso.PKCELister)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, errors.New("the store cannot list pending authorizations"))
+		return
+	}
+	pkces, err := lister.PendingPKCEs(req.Context())
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	views := make([]Authorization, 0, len(pkces))
+	for _, p := range pkces {
+		if profileID == uuid.Nil || p.GetProfileID() == profileID {
+			views = append(views, authorizationView(p))
+		}
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (h *Handler) startAuthorization(w http.ResponseWriter, req *http.Request) {
+	profile, ok := h.profile(w, req)
+	if !ok {
+		return
+	}
+	var body struct {
+		Scopes        []string `json:"scopes"`
+		ReturnURL     string   `json:"return_url"`
+		ReferenceData any      `json:"reference_data"`
+	}
+	if !readJSON(w, req, &body) {
+		return
+	}
+	if body.ReturnURL != "" {
+		if err := h.sso.CheckReturnURL(body.ReturnURL); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	pkce, err := h.sso.CreatePKCE(req.Context(), profile, body.ReturnURL, body.ReferenceData, body.Scopes...)
+	if err != nil {
+		h.fail(w, err)
+		return
+	}
+	view := authorizationView(pkce)
+	view.AuthURL = h.sso.AuthUrl(pkce)
+	h.logger(req).Info("admin authorization started", "profile_id", profile.GetID(), "state", pkce.GetState(), "scope_count", len(body.Scopes))
+	writeJSON(w, http.StatusCreated, view)
+}
+
+// profile looks up the {profile} path value, answering the request itself if
+// it cannot.
+func (h *Handler) profile(w http.ResponseWriter, req *http.Request) (evesso.Profile, bool) {
+	id, err := uuid.Parse(req.PathValue("profile"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	profile, err := h.store.GetProfile(req.Context(), id)
+	if err != nil {
+		h.fail(w, err)
+		return nil, false
+	}
+	return profile, true
+}
+
+// character looks up the {character} path value within {profile}.
+func (h *Handler) character(w http.ResponseWriter, req *http.Request) (evesso.Character, bool) {
+	profile, ok := h.profile(w, req)
+	if !ok {
+		return nil, false
+	}
+	id, err := uuid.Parse(req.PathValue("character"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	character, err := profile.GetCharacter(req.Context(), id)
+	if err != nil {
+		h.fail(w, err)
+		return nil, false
+	}
+	return character, true
+}
+
+func deleteOptions(req *http.Request) []evesso.DeleteOption {
+	if req.URL.Query().Has("revoke") {
+		return []evesso.DeleteOption{evesso.WithRevocation()}
+	}
+	return nil
+}
+
+// deleted answers a deletion. A *RevocationError means the deletion happened
+// but some tokens are still valid at SSO, which the caller needs to see.
+func (h *Handler) deleted(w http.ResponseWriter, err error) bool {
+	var revocationErr *evesso.RevocationError
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	case errors.As(err, &revocationErr):
+		writeJSON(w, http.StatusOK, map[string]string{"revocation_error": err.Error()})
+		return true
+	default:
+		h.fail(w, err)
+		return false
+	}
+}
+
+// logDeleted logs a deletion, as an error if some tokens could not be revoked.
+func (h *Handler) logDeleted(req *http.Request, err error, msg string, keysAndValues ...any) {
+	log := h.logger(req).WithValues("revoke", req.URL.Query().Has("revoke"))
+	if err != nil {
+		log.Error(err, msg+", revocation failed", keysAndValues...)
+		return
+	}
+	log.Info(msg, keysAndValues...)
+}
+
+func (h *Handler) fail(w http.ResponseWriter, err error) {
+	writeError(w, h.status(err), err)
+}
+
+func readJSON(w http.ResponseWriter, req *http.Request, v any) bool {
+	decoder := json.NewDecoder(io.LimitReader(req.Body, maxBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,109 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ferocious-space/evesso/pkg/admin"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+	"github.com/ferocious-space/evesso/pkg/httpapi"
+)
+
+// TestHandler runs its cases in order against one store: main holds an
+// authorized character and spare is empty.
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	srv, sso, store := evessotest.NewSSO(t)
+	mainProfile, err := store.NewProfile(ctx, "main", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spare, err := store.NewProfile(ctx, "spare", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	character := evessotest.Authorize(t, srv, sso, mainProfile, "publicData").Character
+	token, err := character.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := admin.New(sso, httpapi.BearerToken("admin-token"))
+	profilePath := "/profiles/" + mainProfile.GetID().String()
+	characterPath := profilePath + "/characters/" + character.GetID().String()
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		// token is the bearer token, admin-token unless set and none for "-"
+		token  string
+		status int
+		// count is the length of a list answer
+		count int
+	}{
+		{name: "no token", method: http.MethodGet, path: "/profiles", token: "-", status: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodGet, path: "/profiles", token: "guess", status: http.StatusUnauthorized},
+		{name: "profiles", method: http.MethodGet, path: "/profiles", status: http.StatusOK, count: 2},
+		{name: "create profile", method: http.MethodPost, path: "/profiles", body: `{"name":"third"}`, status: http.StatusCreated},
+		{name: "create a taken name", method: http.MethodPost, path: "/profiles", body: `{"name":"third"}`, status: http.StatusConflict},
+		{name: "create without a name", method: http.MethodPost, path: "/profiles", body: `{}`, status: http.StatusBadRequest},
+		{name: "create with an unknown field", method: http.MethodPost, path: "/profiles", body: `{"name":"x","owner":"y"}`, status: http.StatusBadRequest},
+		{name: "profile", method: http.MethodGet, path: profilePath, status: http.StatusOK},
+		{name: "malformed profile", method: http.MethodGet, path: "/profiles/nope", status: http.StatusBadRequest},
+		{name: "rename onto a taken name", method: http.MethodPatch, path: profilePath, body: `{"name":"spare"}`, status: http.StatusConflict},
+		{name: "rename", method: http.MethodPatch, path: profilePath, body: `{"name":"renamed"}`, status: http.StatusOK},
+		{name: "characters", method: http.MethodGet, path: profilePath + "/characters", status: http.StatusOK, count: 1},
+		{name: "all characters", method: http.MethodGet, path: "/characters", status: http.StatusOK, count: 1},
+		{name: "character", method: http.MethodGet, path: characterPath, status: http.StatusOK},
+		{name: "character of another profile", method: http.MethodGet, path: "/profiles/" + spare.GetID().String() + "/characters/" + character.GetID().String(), status: http.StatusNotFound},
+		{name: "set active without a value", method: http.MethodPatch, path: characterPath, body: `{}`, status: http.StatusBadRequest},
+		{name: "deactivate", method: http.MethodPatch, path: characterPath, body: `{"active":false}`, status: http.StatusOK},
+		{name: "start authorization", method: http.MethodPost, path: profilePath + "/authorizations", body: `{"scopes":["publicData"],"return_url":"/done"}`, status: http.StatusCreated},
+		{name: "start authorization to another host", method: http.MethodPost, path: profilePath + "/authorizations", body: `{"return_url":"https://evil.example.com/"}`, status: http.StatusBadRequest},
+		{name: "authorizations", method: http.MethodGet, path: profilePath + "/authorizations", status: http.StatusOK, count: 1},
+		{name: "all authorizations", method: http.MethodGet, path: "/authorizations", status: http.StatusOK, count: 1},
+		{name: "delete character and revoke", method: http.MethodDelete, path: characterPath + "?revoke", status: http.StatusNoContent},
+		{name: "deleted character", method: http.MethodGet, path: characterPath, status: http.StatusNotFound},
+		{name: "delete profile", method: http.MethodDelete, path: profilePath, status: http.StatusNoContent},
+		{name: "deleted profile", method: http.MethodGet, path: profilePath, status: http.StatusNotFound},
+		{name: "profiles left", method: http.MethodGet, path: "/profiles", status: http.StatusOK, count: 2},
+	}
+	for _, tt := range tests {
+		if !t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			switch tt.token {
+			case "":
+				req.Header.Set("Authorization", "Bearer admin-token")
+			case "-":
+			default:
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code != http.StatusOK || rec.Body.Bytes()[0] != '[' {
+				return
+			}
+			var list []json.RawMessage
+			if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != tt.count {
+				t.Errorf("%d entries, want %d", len(list), tt.count)
+			}
+		}) {
+			// later cases depend on earlier ones
+			break
+		}
+	}
+	if !srv.Revoked(token.RefreshToken) {
+		t.Error("?revoke did not revoke the refresh token")
+	}
+}
@@ -167,12 +167,37 @@ func (c *Character) Token() (*oauth2.Token, error) {
 	return &oauth2.Token{AccessToken: accessToken, RefreshToken: refreshToken, Expiry: expiration}, nil
 }
 
-func (c *Character) Delete(ctx context.Context) error {
+func (c *Character) Revoke(ctx context.Context) error {
+	if err := c.revoke(ctx); err != nil {
+		return err
+	}
+	return c.UpdateActiveState(ctx, false)
+}
+
+// revoke revokes the stored refresh token without touching the row.
+func (c *Character) revoke(ctx context.Context) error {
+	if c.store.revoker == nil {
+		return evesso.ErrNoRevoker
+	}
+	token, err := c.Token()
+	if err != nil {
+		return err
+	}
+	return c.store.revoker.RevokeToken(ctx, token.RefreshToken)
+}
+
+func (c *Character) Delete(ctx context.Context, opts ...evesso.DeleteOption) error {
+	revokeErr := new(evesso.RevocationError)
+	if evesso.NewDeleteOptions(opts...).Revoke {
+		if err := c.revoke(ctx); err != nil {
+			revokeErr.Add(c, err)
+		}
+	}
 	err := c.store.Query(ctx, sq.Delete("evesso.characters").Where(sq.Eq{"id": c.ID}), nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	return revokeErr.Err()
 }
 
 var _ evesso.Character = &Character{}
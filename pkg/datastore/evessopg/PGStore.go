@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"hash/crc32"
+	"io"
 	"reflect"
 	"sync"
 	"time"
@@ -22,14 +23,31 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/lann/builder"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 
 	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/webstore"
 )
 
 //go:embed migrations/*.sql
 var migrations embed.FS
 
 var _ evesso.DataStore = &PGStore{}
+var _ evesso.DSNValidator = &PGStore{}
+var _ evesso.RevokerSetter = &PGStore{}
+var _ evesso.CharacterPruner = &PGStore{}
+var _ evesso.TracerSetter = &PGStore{}
+var _ evesso.DocumentCache = &PGStore{}
+var _ evesso.Pinger = &PGStore{}
+var _ evesso.MigrationVersioner = &PGStore{}
+var _ evesso.CharacterCounter = &PGStore{}
+var _ evesso.PKCELister = &PGStore{}
+var _ webstore.SessionStore = &PGStore{}
+var _ webstore.OIDCStore = &PGStore{}
+var _ io.Closer = &PGStore{}
 
 type PGStore struct {
 	sync.Mutex
@@ -37,6 +55,8 @@ type PGStore struct {
 	pool       *pgxpool.Pool
 	lock       *pgxpool.Conn
 	migrations *migrate.Migrate
+	revoker    evesso.Revoker
+	tracer     trace.Tracer
 }
 
 func (x *PGStore) Setup(ctx context.Context, dsn string) error {
@@ -51,12 +71,52 @@ func (x *PGStore) Setup(ctx context.Context, dsn string) error {
 	return nil
 }
 
-func (x *PGStore) Query(ctx context.Context, queryer sq.Sqlizer, output interface{}) error {
+// SetRevoker sets what Character.Revoke and WithRevocation revoke tokens with.
+func (x *PGStore) SetRevoker(revoker evesso.Revoker) {
+	x.revoker = revoker
+}
+
+// ValidateDSN checks that dsn parses as a pgx connection string.
+func (x *PGStore) ValidateDSN(dsn string) error {
+	_, err := pgxpool.ParseConfig(dsn)
+	return err
+}
+
+// SetTracerProvider traces every Query and Transaction with provider.
+func (x *PGStore) SetTracerProvider(provider trace.TracerProvider) {
+	x.tracer = provider.Tracer("github.com/ferocious-space/evesso/pkg/datastore/evessopg")
+}
+
+// startSpan starts a span named name, a no-op one until SetTracerProvider.
+func (x *PGStore) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := x.tracer
+	if tracer == nil {
+		tracer = noop.Tracer{}
+	}
+	return tracer.Start(
+		ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, attribute.String("db.system.name", "postgresql"))...),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (x *PGStore) Query(ctx context.Context, queryer sq.Sqlizer, output interface{}) (err error) {
 	q := builder.Set(queryer, "PlaceholderFormat", sq.Dollar).(sq.Sqlizer)
 	rsql, args, err := q.ToSql()
 	if err != nil {
 		return err
 	}
+	// arguments stay out of the span, they include tokens
+	ctx, span := x.startSpan(ctx, "evessopg.Query", attribute.String("db.query.text", rsql))
+	defer func() { endSpan(span, err) }()
 	typ := reflect.TypeOf(output)
 	switch typ {
 	case nil:
@@ -99,18 +159,20 @@ func (x *PGStore) Connection(ctx context.Context, f func(ctx context.Context, tx
 		return err
 	}
 	defer tx.Release()
-	return f(ctx, tx)
+	return storeError(f(ctx, tx))
 }
 
-func (x *PGStore) Transaction(ctx context.Context, f func(ctx context.Context, tx pgx.Tx) error) error {
-	return pgx.BeginTxFunc(
+func (x *PGStore) Transaction(ctx context.Context, f func(ctx context.Context, tx pgx.Tx) error) (err error) {
+	ctx, span := x.startSpan(ctx, "evessopg.Transaction")
+	defer func() { endSpan(span, err) }()
+	return storeError(pgx.BeginTxFunc(
 		ctx, x.pool, pgx.TxOptions{
 			IsoLevel:   pgx.RepeatableRead,
 			AccessMode: pgx.ReadWrite,
 		}, func(tx pgx.Tx) error {
 			return f(ctx, tx)
 		},
-	)
+	))
 }
 
 func (x *PGStore) GLock(key1 interface{}) {
@@ -313,18 +375,30 @@ func (x *PGStore) FindProfile(ctx context.Context, profileName string) (evesso.P
 	return profile, nil
 }
 
-func (x *PGStore) DeleteProfile(ctx context.Context, profileID uuid.UUID) error {
+func (x *PGStore) DeleteProfile(ctx context.Context, profileID uuid.UUID, opts ...evesso.DeleteOption) error {
+	revokeErr := new(evesso.RevocationError)
+	if evesso.NewDeleteOptions(opts...).Revoke {
+		var characters []*Character
+		err := x.Query(ctx, sq.Select("*").From("evesso.characters").Where(sq.Eq{"profile_ref": profileID}), &characters)
+		if err != nil {
+			return err
+		}
+		for _, c := range characters {
+			c.store = x
+			if err = c.revoke(ctx); err != nil {
+				revokeErr.Add(c, err)
+			}
+		}
+	}
 	err := x.Query(ctx, sq.Delete("evesso.profiles").Where(sq.Eq{"id": profileID}), nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	return revokeErr.Err()
 }
 
 func (x *PGStore) FindCharacter(ctx context.Context, characterID int32, characterName string, Owner string) (evesso.Profile, evesso.Character, error) {
-	character := new(Character)
-	character.store = x
-
+	var characters []*Character
 	wh := sq.Select("*").From("evesso.characters")
 	wcl := sq.And{}
 	if characterID > 0 {
@@ -337,10 +411,18 @@ func (x *PGStore) FindCharacter(ctx context.Context, characterID int32, characte
 		wcl = append(wcl, sq.Eq{"owner": Owner})
 	}
 	wcl = append(wcl, sq.Eq{"active": true})
-	err := x.Query(ctx, wh.Where(wcl), character)
+	err := x.Query(ctx, wh.Where(wcl).Limit(2), &characters)
 	if err != nil {
 		return nil, nil, err
 	}
+	switch len(characters) {
+	case 0:
+		return nil, nil, storeError(pgx.ErrNoRows)
+	case 2:
+		return nil, nil, fmt.Errorf("%w: more than one active character matches", evesso.ErrAmbiguous)
+	}
+	character := characters[0]
+	character.store = x
 	profile, err := x.GetProfile(ctx, character.GetProfileID())
 	if err != nil {
 		return nil, nil, err
@@ -348,6 +430,75 @@ func (x *PGStore) FindCharacter(ctx context.Context, characterID int32, characte
 	return profile, character, nil
 }
 
+// PruneCharacters deletes the characters that have been inactive since before
+// inactiveSince and returns them.
+func (x *PGStore) PruneCharacters(ctx context.Context, inactiveSince time.Time) ([]evesso.Character, error) {
+	var characters []*Character
+	err := x.Query(ctx,
+		sq.Delete("evesso.characters").
+			Where(sq.And{
+				sq.Eq{"active": false},
+				sq.Lt{"updated_at": inactiveSince},
+			}).
+			Suffix("RETURNING *"),
+		&characters)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]evesso.Character, 0, len(characters))
+	for _, c := range characters {
+		c.store = x
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+// Ping checks that a pool connection can reach the database.
+func (x *PGStore) Ping(ctx context.Context) error {
+	return x.pool.Ping(ctx)
+}
+
+// MigrationVersion returns the latest migration applied to the evesso schema.
+func (x *PGStore) MigrationVersion() (uint, bool, error) {
+	return x.migrations.Version()
+}
+
+// CountCharacters counts the active and inactive characters.
+func (x *PGStore) CountCharacters(ctx context.Context) (int64, int64, error) {
+	var counts struct {
+		Active   int64 `db:"active"`
+		Inactive int64 `db:"inactive"`
+	}
+	err := x.Query(ctx, sq.Select(
+		"count(*) filter (where active) as active",
+		"count(*) filter (where not active) as inactive",
+	).From("evesso.characters"), &counts)
+	if err != nil {
+		return 0, 0, err
+	}
+	return counts.Active, counts.Inactive, nil
+}
+
+// Close releases the advisory lock connection, the migration driver and the
+// pool.
+func (x *PGStore) Close() error {
+	x.Lock()
+	defer x.Unlock()
+	if x.lock != nil {
+		x.lock.Release()
+		x.lock = nil
+	}
+	var errs []error
+	if x.migrations != nil {
+		srcErr, dbErr := x.migrations.Close()
+		errs = append(errs, srcErr, dbErr)
+	}
+	if x.pool != nil {
+		x.pool.Close()
+	}
+	return errors.Join(errs...)
+}
+
 func (x *PGStore) GetPKCE(ctx context.Context, pkceID uuid.UUID) (evesso.PKCE, error) {
 	pkce := new(PKCE)
 	pkce.store = x
@@ -356,7 +507,7 @@ func (x *PGStore) GetPKCE(ctx context.Context, pkceID uuid.UUID) (evesso.PKCE, e
 		Where(
 			sq.And{
 				sq.Eq{"id": pkceID},
-				sq.Gt{"created_at": time.Now().Add(-5 * time.Minute)},
+				sq.Gt{"created_at": time.Now().Add(-evesso.PKCELifetime)},
 			}),
 		pkce)
 	if err != nil {
@@ -371,11 +522,7 @@ func (x *PGStore) FindPKCE(ctx context.Context, state uuid.UUID) (evesso.PKCE, e
 	err := x.Query(ctx,
 		sq.Select("*").
 			From("evesso.pkces").
-			Where(
-				sq.And{
-					sq.Eq{"state": state},
-					sq.Gt{"created_at": time.Now().Add(-5 * time.Minute)},
-				}),
+			Where(sq.Eq{"state": state}),
 		pkce)
 	if err != nil {
 		return nil, err
@@ -383,13 +530,192 @@ func (x *PGStore) FindPKCE(ctx context.Context, state uuid.UUID) (evesso.PKCE, e
 	return pkce, nil
 }
 
+// PendingPKCEs returns the PKCE rows that have not expired, oldest first.
+func (x *PGStore) PendingPKCEs(ctx context.Context) ([]evesso.PKCE, error) {
+	var pkces []*PKCE
+	err := x.Query(ctx, sq.Select("*").
+		From("evesso.pkces").
+		Where(sq.Gt{"created_at": time.Now().Add(-evesso.PKCELifetime)}).
+		OrderBy("created_at"),
+		&pkces)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]evesso.PKCE, 0, len(pkces))
+	for _, p := range pkces {
+		p.store = x
+		result = append(result, p)
+	}
+	return result, nil
+}
+
 func (x *PGStore) CleanPKCE(ctx context.Context) error {
 	err := x.Query(ctx, sq.Delete("evesso.pkces").
 		Where(
-			sq.Lt{"created_at": time.Now().Add(-(5*time.Minute + 1*time.Second))},
+			sq.Lt{"created_at": time.Now().Add(-(evesso.PKCELifetime + time.Second))},
 		), nil)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+func (x *PGStore) LoadDocument(ctx context.Context, key string) ([]byte, error) {
+	var row struct {
+		Document []byte `db:"document"`
+	}
+	err := x.Query(ctx, sq.Select("document").
+		From("evesso.sso_documents").
+		Where(sq.Eq{"key": key}),
+		&row)
+	if pgxscan.NotFound(err) {
+		return nil, evesso.ErrNoDocument
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.Document, nil
+}
+
+func (x *PGStore) StoreDocument(ctx context.Context, key string, document []byte) error {
+	return x.Query(ctx, sq.Insert("evesso.sso_documents").
+		Columns("key", "document", "updated_at").
+		Values(key, document, time.Now()).
+		Suffix("ON CONFLICT (key) DO UPDATE SET document = excluded.document, updated_at = excluded.updated_at"),
+		nil)
+}
+
+// CreateSession stores a web login session, dropping expired ones.
+func (x *PGStore) CreateSession(ctx context.Context, session webstore.Session) error {
+	err := x.Query(ctx, sq.Delete("evesso.web_sessions").Where(sq.Lt{"expires_at": time.Now()}), nil)
+	if err != nil {
+		return err
+	}
+	return x.Query(ctx, sq.Insert("evesso.web_sessions").
+		Columns("id", "profile_ref", "character_ref", "created_at", "expires_at").
+		Values(session.ID, session.ProfileID, session.CharacterID, session.Created, session.Expires),
+		nil)
+}
+
+func (x *PGStore) SessionActive(ctx context.Context, sessionID uuid.UUID) (bool, error) {
+	var row struct {
+		Active bool `db:"active"`
+	}
+	err := x.Query(ctx, sq.Select("count(*) > 0 as active").
+		From("evesso.web_sessions").
+		Where(sq.And{
+			sq.Eq{"id": sessionID},
+			sq.Gt{"expires_at": time.Now()},
+		}),
+		&row)
+	if err != nil {
+		return false, err
+	}
+	return row.Active, nil
+}
+
+func (x *PGStore) DeleteSession(ctx context.Context, sessionID uuid.UUID) error {
+	return x.Query(ctx, sq.Delete("evesso.web_sessions").Where(sq.Eq{"id": sessionID}), nil)
+}
+
+func (x *PGStore) DeleteProfileSessions(ctx context.Context, profileID uuid.UUID) error {
+	return x.Query(ctx, sq.Delete("evesso.web_sessions").Where(sq.Eq{"profile_ref": profileID}), nil)
+}
+
+// oidcClient is a row of evesso.oidc_clients.
+type oidcClient struct {
+	ID           string    `db:"client_id"`
+	Name         string    `db:"client_name"`
+	SecretHash   []byte    `db:"secret_hash"`
+	RedirectURIs []string  `db:"redirect_uris"`
+	Created      time.Time `db:"created_at"`
+}
+
+func (c oidcClient) client() webstore.Client {
+	return webstore.Client(c)
+}
+
+func (x *PGStore) CreateClient(ctx context.Context, client webstore.Client) error {
+	return x.Query(ctx, sq.Insert("evesso.oidc_clients").
+		Columns("client_id", "client_name", "secret_hash", "redirect_uris", "created_at").
+		Values(client.ID, client.Name, client.SecretHash, client.RedirectURIs, client.Created),
+		nil)
+}
+
+func (x *PGStore) GetClient(ctx context.Context, clientID string) (webstore.Client, bool, error) {
+	var row oidcClient
+	err := x.Query(ctx, sq.Select("*").
+		From("evesso.oidc_clients").
+		Where(sq.Eq{"client_id": clientID}),
+		&row)
+	if pgxscan.NotFound(err) {
+		return webstore.Client{}, false, nil
+	}
+	if err != nil {
+		return webstore.Client{}, false, err
+	}
+	return row.client(), true, nil
+}
+
+func (x *PGStore) AllClients(ctx context.Context) ([]webstore.Client, error) {
+	var rows []oidcClient
+	err := x.Query(ctx, sq.Select("*").
+		From("evesso.oidc_clients").
+		OrderBy("client_id"),
+		&rows)
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]webstore.Client, 0, len(rows))
+	for _, row := range rows {
+		clients = append(clients, row.client())
+	}
+	return clients, nil
+}
+
+func (x *PGStore) DeleteClient(ctx context.Context, clientID string) error {
+	return x.Query(ctx, sq.Delete("evesso.oidc_clients").Where(sq.Eq{"client_id": clientID}), nil)
+}
+
+// oidcCode is a row of evesso.oidc_codes.
+type oidcCode struct {
+	Hash        []byte    `db:"hash"`
+	ClientID    string    `db:"client_id"`
+	RedirectURI string    `db:"redirect_uri"`
+	ProfileID   uuid.UUID `db:"profile_ref"`
+	CharacterID uuid.UUID `db:"character_ref"`
+	Scopes      []string  `db:"scopes"`
+	Nonce       string    `db:"nonce"`
+	Challenge   string    `db:"challenge"`
+	AuthTime    time.Time `db:"auth_time"`
+	Expires     time.Time `db:"expires_at"`
+}
+
+// CreateCode stores an authorization code, dropping expired ones.
+func (x *PGStore) CreateCode(ctx context.Context, code webstore.Code) error {
+	err := x.Query(ctx, sq.Delete("evesso.oidc_codes").Where(sq.Lt{"expires_at": time.Now()}), nil)
+	if err != nil {
+		return err
+	}
+	return x.Query(ctx, sq.Insert("evesso.oidc_codes").
+		Columns("hash", "client_id", "redirect_uri", "profile_ref", "character_ref", "scopes", "nonce", "challenge", "auth_time", "expires_at").
+		Values(code.Hash, code.ClientID, code.RedirectURI, code.ProfileID, code.CharacterID, code.Scopes, code.Nonce, code.Challenge, code.AuthTime, code.Expires),
+		nil)
+}
+
+// TakeCode deletes the code and returns it, so two requests racing with the
+// same code cannot both get it.
+func (x *PGStore) TakeCode(ctx context.Context, hash []byte) (webstore.Code, bool, error) {
+	var row oidcCode
+	err := x.Query(ctx, sq.Delete("evesso.oidc_codes").
+		Where(sq.Eq{"hash": hash}).
+		Suffix("RETURNING *"),
+		&row)
+	if pgxscan.NotFound(err) {
+		return webstore.Code{}, false, nil
+	}
+	if err != nil {
+		return webstore.Code{}, false, err
+	}
+	return webstore.Code(row), true, nil
+}
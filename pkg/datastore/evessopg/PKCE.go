@@ -27,6 +27,7 @@ type PKCE struct {
 	CodeChallangeMethod string    `json:"code_challange_method" db:"code_challange_method"`
 	Scopes              []string  `json:"scopes" db:"scopes"`
 	ReferenceData       []byte    `json:"reference_data" db:"reference_data"`
+	ReturnURL           string    `json:"return_url" db:"return_url"`
 
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
@@ -42,6 +43,10 @@ func (p *PKCE) GetReferenceData() interface{} {
 	return out
 }
 
+func (p *PKCE) GetReturnURL() string {
+	return p.ReturnURL
+}
+
 func (p *PKCE) GetScopes() []string {
 	return p.Scopes
 }
@@ -106,3 +111,4 @@ func MakePKCE(profile *Profile) *PKCE {
 }
 
 var _ evesso.PKCE = &PKCE{}
+var _ evesso.ReturnURLHolder = &PKCE{}
@@ -93,6 +93,17 @@ func (p *Profile) FindCharacter(ctx context.Context, characterID int32, characte
 	character := new(Character)
 	character.store = p.store
 	wh := sq.Select("*").From("evesso.characters")
+	err := p.store.Query(ctx, wh.Where(characterFilter(p.ID, characterID, characterName, owner, scopes)), character)
+	if err != nil {
+		return nil, err
+	}
+	return character, nil
+}
+
+// characterFilter selects the active characters of profileID matching the
+// given fields; empty ones match anything. No scopes must leave the scopes
+// out, since a nil slice is sent as NULL, which no array contains.
+func characterFilter(profileID uuid.UUID, characterID int32, characterName string, owner string, scopes []string) sq.And {
 	and := sq.And{}
 	if characterID > 0 {
 		and = append(and, sq.Eq{"character_id": characterID})
@@ -103,14 +114,12 @@ func (p *Profile) FindCharacter(ctx context.Context, characterID int32, characte
 	if len(owner) > 0 {
 		and = append(and, sq.Eq{"owner": owner})
 	}
-	and = append(and, sq.Eq{"profile_ref": p.ID})
-	and = append(and, sq.Expr("scopes @> (?)", scopes))
-	and = append(and, sq.Eq{"active": true})
-	err := p.store.Query(ctx, wh.Where(and), character)
-	if err != nil {
-		return nil, err
+	and = append(and, sq.Eq{"profile_ref": profileID})
+	if len(scopes) > 0 {
+		and = append(and, sq.Expr("scopes @> (?)", scopes))
 	}
-	return character, nil
+	and = append(and, sq.Eq{"active": true})
+	return and
 }
 
 func (p *Profile) CreateCharacter(ctx context.Context, claims evesso.CharacterClaims, token *oauth2.Token, referenceData interface{}) (evesso.Character, error) {
@@ -145,6 +154,10 @@ func (p *Profile) CreateCharacter(ctx context.Context, claims evesso.CharacterCl
 }
 
 func (p *Profile) CreatePKCE(ctx context.Context, referenceData interface{}, scopes ...string) (evesso.PKCE, error) {
+	return p.CreatePKCEWithReturnURL(ctx, "", referenceData, scopes...)
+}
+
+func (p *Profile) CreatePKCEWithReturnURL(ctx context.Context, returnURL string, referenceData interface{}, scopes ...string) (evesso.PKCE, error) {
 	pkce := MakePKCE(p)
 	pkce.store = p.store
 	marshal, err := json.Marshal(referenceData)
@@ -153,9 +166,10 @@ func (p *Profile) CreatePKCE(ctx context.Context, referenceData interface{}, sco
 	}
 	pkce.ReferenceData = marshal
 	pkce.Scopes = scopes
+	pkce.ReturnURL = returnURL
 	sqlb := sq.Insert("evesso.pkces").
-		Columns("profile_ref", "code_verifier", "code_challange", "code_challange_method", "scopes", "reference_data", "created_at").
-		Values(pkce.ProfileReference, pkce.CodeVerifier, pkce.CodeChallange, pkce.CodeChallangeMethod, pkce.Scopes, pkce.ReferenceData, pkce.CreatedAt).
+		Columns("profile_ref", "code_verifier", "code_challange", "code_challange_method", "scopes", "reference_data", "return_url", "created_at").
+		Values(pkce.ProfileReference, pkce.CodeVerifier, pkce.CodeChallange, pkce.CodeChallangeMethod, pkce.Scopes, pkce.ReferenceData, pkce.ReturnURL, pkce.CreatedAt).
 		Suffix("RETURNING id,state")
 	err = p.store.Query(ctx, sqlb, pkce)
 	if err != nil {
@@ -164,12 +178,23 @@ func (p *Profile) CreatePKCE(ctx context.Context, referenceData interface{}, sco
 	return pkce, nil
 }
 
-func (p *Profile) Delete(ctx context.Context) error {
-	err := p.store.Query(ctx, sq.Delete("evesso.profiles").Where(sq.Eq{"id": p.ID}), nil)
+func (p *Profile) RevokeAll(ctx context.Context) error {
+	characters, err := p.AllCharacters(ctx)
 	if err != nil {
 		return err
 	}
-	return nil
+	revokeErr := new(evesso.RevocationError)
+	for _, c := range characters {
+		if err = c.Revoke(ctx); err != nil {
+			revokeErr.Add(c, err)
+		}
+	}
+	return revokeErr.Err()
+}
+
+func (p *Profile) Delete(ctx context.Context, opts ...evesso.DeleteOption) error {
+	return p.store.DeleteProfile(ctx, p.ID, opts...)
 }
 
 var _ evesso.Profile = &Profile{}
+var _ evesso.ReturnURLCreator = &Profile{}
@@ -0,0 +1,57 @@
+package evessopg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCharacterFilter(t *testing.T) {
+	profileID := uuid.MustParse("6f1c2b1e-9a4b-4c1d-8e2f-0a1b2c3d4e5f")
+	// a uuid.UUID is sent as its string
+	profileArg := profileID.String()
+	tests := []struct {
+		name   string
+		id     int32
+		owner  string
+		scopes []string
+		sql    string
+		args   []interface{}
+	}{
+		{
+			name: "any scopes",
+			id:   90000001,
+			sql:  "(character_id = ? AND profile_ref = ? AND active = ?)",
+			args: []interface{}{int32(90000001), profileArg, true},
+		},
+		{
+			name:   "empty scopes",
+			owner:  "owner-hash",
+			scopes: []string{},
+			sql:    "(owner = ? AND profile_ref = ? AND active = ?)",
+			args:   []interface{}{"owner-hash", profileArg, true},
+		},
+		{
+			name:   "scopes",
+			id:     90000001,
+			scopes: []string{"publicData"},
+			sql:    "(character_id = ? AND profile_ref = ? AND scopes @> (?) AND active = ?)",
+			args:   []interface{}{int32(90000001), profileArg, []string{"publicData"}, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := characterFilter(profileID, tt.id, "", tt.owner, tt.scopes).ToSql()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sql != tt.sql {
+				t.Errorf("sql = %q, want %q", sql, tt.sql)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
@@ -2,6 +2,12 @@ package evessopg
 
 import (
 	"errors"
+	"fmt"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/ferocious-space/evesso"
 )
 
 var (
@@ -11,3 +17,21 @@ var (
 	ErrTranscationOpen   = errors.New("Transaction already exist in this context")
 	ErrNoTranscationOpen = errors.New("no Transaction in this context")
 )
+
+// uniqueViolation is the SQLSTATE of a unique constraint violation.
+const uniqueViolation = "23505"
+
+// storeError wraps err in the evesso error callers test for, keeping the pgx
+// error underneath.
+func storeError(err error) error {
+	var pgErr *pgconn.PgError
+	switch {
+	case err == nil, errors.Is(err, evesso.ErrNotFound), errors.Is(err, evesso.ErrConflict):
+		return err
+	case pgxscan.NotFound(err):
+		return fmt.Errorf("%w: %w", evesso.ErrNotFound, err)
+	case errors.As(err, &pgErr) && pgErr.Code == uniqueViolation:
+		return fmt.Errorf("%w: %w", evesso.ErrConflict, err)
+	}
+	return err
+}
@@ -0,0 +1,4 @@
+begin;
+alter table evesso.pkces
+    drop column if exists return_url;
+commit;
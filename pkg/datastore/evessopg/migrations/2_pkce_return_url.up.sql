@@ -0,0 +1,4 @@
+begin;
+alter table evesso.pkces
+    add column if not exists return_url text not null default '';
+commit;
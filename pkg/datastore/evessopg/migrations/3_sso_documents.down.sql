@@ -0,0 +1,3 @@
+begin;
+drop table if exists evesso.sso_documents;
+commit;
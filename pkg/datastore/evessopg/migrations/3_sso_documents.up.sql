@@ -0,0 +1,10 @@
+begin;
+create table if not exists evesso.sso_documents
+(
+    key        text        not null,
+    document   jsonb       not null,
+    updated_at timestamptz not null,
+    constraint sso_documents_pkey
+        primary key (key)
+);
+commit;
@@ -0,0 +1,21 @@
+begin;
+create table if not exists evesso.web_sessions
+(
+    id            uuid        not null,
+    profile_ref   uuid        not null,
+    character_ref uuid        not null,
+    created_at    timestamptz not null,
+    expires_at    timestamptz not null,
+    constraint web_sessions_pkey
+        primary key (id),
+    constraint web_session_profile_fk
+        foreign key (profile_ref) references evesso.profiles
+            on delete cascade,
+    constraint web_session_character_fk
+        foreign key (character_ref) references evesso.characters
+            on delete cascade
+);
+
+create index if not exists web_sessions_profile_ref_idx
+    on evesso.web_sessions (profile_ref);
+commit;
@@ -0,0 +1,4 @@
+begin;
+drop table if exists evesso.oidc_codes;
+drop table if exists evesso.oidc_clients;
+commit;
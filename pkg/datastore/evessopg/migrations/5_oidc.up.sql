@@ -0,0 +1,37 @@
+begin;
+create table if not exists evesso.oidc_clients
+(
+    client_id     text        not null,
+    client_name   text        not null,
+    secret_hash   bytea,
+    redirect_uris text[]      not null,
+    created_at    timestamptz not null,
+    constraint oidc_clients_pkey
+        primary key (client_id)
+);
+
+create table if not exists evesso.oidc_codes
+(
+    hash          bytea       not null,
+    client_id     text        not null,
+    redirect_uri  text        not null,
+    profile_ref   uuid        not null,
+    character_ref uuid        not null,
+    scopes        text[]      not null,
+    nonce         text        not null,
+    challenge     text        not null,
+    auth_time     timestamptz not null,
+    expires_at    timestamptz not null,
+    constraint oidc_codes_pkey
+        primary key (hash),
+    constraint oidc_code_client_fk
+        foreign key (client_id) references evesso.oidc_clients
+            on delete cascade,
+    constraint oidc_code_profile_fk
+        foreign key (profile_ref) references evesso.profiles
+            on delete cascade,
+    constraint oidc_code_character_fk
+        foreign key (character_ref) references evesso.characters
+            on delete cascade
+);
+commit;
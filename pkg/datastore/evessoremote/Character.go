@@ -0,0 +1,100 @@
+package evessoremote
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/tokenserver"
+)
+
+var _ evesso.RemoteRefresher = &Character{}
+
+type Character struct {
+	store *RemoteStore
+	view  tokenserver.Character
+}
+
+func (c *Character) GetID() uuid.UUID {
+	return c.view.ID
+}
+
+func (c *Character) GetProfileID() uuid.UUID {
+	return c.view.ProfileID
+}
+
+func (c *Character) GetCharacterName() string {
+	return c.view.CharacterName
+}
+
+func (c *Character) GetCharacterID() int32 {
+	return c.view.CharacterID
+}
+
+func (c *Character) GetOwner() string {
+	return c.view.Owner
+}
+
+func (c *Character) GetScopes() []string {
+	return c.view.Scopes
+}
+
+func (c *Character) GetReferenceData() interface{} {
+	return c.view.ReferenceData
+}
+
+// IsActive is the state when the character was read; the server deactivates
+// characters whose refresh token SSO rejects.
+func (c *Character) IsActive() bool {
+	return c.view.Active
+}
+
+func (c *Character) GetProfile(ctx context.Context) (evesso.Profile, error) {
+	return c.store.GetProfile(ctx, c.view.ProfileID)
+}
+
+func (c *Character) UpdateAccessToken(context.Context, string) error {
+	return ErrNotSupported
+}
+
+func (c *Character) UpdateRefreshToken(context.Context, string) error {
+	return ErrNotSupported
+}
+
+func (c *Character) UpdateActiveState(context.Context, bool) error {
+	return ErrNotSupported
+}
+
+// Token returns the current access token from the server. It has no refresh
+// token.
+func (c *Character) Token() (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return c.RemoteToken(ctx)
+}
+
+// RemoteToken asks the server for the current access token, which it
+// refreshes first if it has expired.
+func (c *Character) RemoteToken(ctx context.Context) (*oauth2.Token, error) {
+	var token tokenserver.Token
+	err := c.store.do(ctx, http.MethodPost, "/token", nil, tokenserver.Request{
+		ProfileID: c.view.ProfileID,
+		ID:        c.view.ID,
+	}, &token)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{AccessToken: token.AccessToken, TokenType: token.TokenType, Expiry: token.Expiry}, nil
+}
+
+func (c *Character) Revoke(context.Context) error {
+	return ErrNotSupported
+}
+
+func (c *Character) Delete(context.Context, ...evesso.DeleteOption) error {
+	return ErrNotSupported
+}
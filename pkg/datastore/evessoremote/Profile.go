@@ -0,0 +1,95 @@
+package evessoremote
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/tokenserver"
+)
+
+type Profile struct {
+	store *RemoteStore
+	view  tokenserver.Profile
+}
+
+func (p *Profile) GetID() uuid.UUID {
+	return p.view.ID
+}
+
+func (p *Profile) GetName() string {
+	return p.view.Name
+}
+
+func (p *Profile) GetData() any {
+	return p.view.Data
+}
+
+func (p *Profile) Rename(context.Context, string) error {
+	return ErrNotSupported
+}
+
+// AllCharacters returns the characters of the profile the server's rules let
+// this client have tokens for.
+func (p *Profile) AllCharacters(ctx context.Context) ([]evesso.Character, error) {
+	var views []tokenserver.Character
+	if err := p.store.do(ctx, http.MethodGet, "/profiles/"+p.view.ID.String()+"/characters", nil, nil, &views); err != nil {
+		return nil, err
+	}
+	characters := make([]evesso.Character, 0, len(views))
+	for _, v := range views {
+		characters = append(characters, &Character{store: p.store, view: v})
+	}
+	return characters, nil
+}
+
+func (p *Profile) GetCharacter(ctx context.Context, id uuid.UUID) (evesso.Character, error) {
+	character := &Character{store: p.store}
+	err := p.store.do(ctx, http.MethodGet, "/profiles/"+p.view.ID.String()+"/characters/"+id.String(), nil, nil, &character.view)
+	if err != nil {
+		return nil, err
+	}
+	return character, nil
+}
+
+// FindCharacter matches like evessopg's: on the fields that are given, among
+// the active characters that have at least scopes.
+func (p *Profile) FindCharacter(ctx context.Context, characterID int32, characterName string, owner string, scopes []string) (evesso.Character, error) {
+	characters, err := p.AllCharacters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range characters {
+		switch {
+		case !c.IsActive(),
+			characterID > 0 && c.GetCharacterID() != characterID,
+			characterName != "" && c.GetCharacterName() != characterName,
+			owner != "" && c.GetOwner() != owner,
+			slices.ContainsFunc(scopes, func(scope string) bool { return !slices.Contains(c.GetScopes(), scope) }):
+			continue
+		}
+		return c, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (p *Profile) CreateCharacter(context.Context, evesso.CharacterClaims, *oauth2.Token, interface{}) (evesso.Character, error) {
+	return nil, ErrNotSupported
+}
+
+// CreatePKCE fails: authorizations start and complete on the central server.
+func (p *Profile) CreatePKCE(context.Context, interface{}, ...string) (evesso.PKCE, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *Profile) RevokeAll(context.Context) error {
+	return ErrNotSupported
+}
+
+func (p *Profile) Delete(context.Context, ...evesso.DeleteOption) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,523 @@
+// Package evessotest runs an in-process stand-in for EVE SSO on an
+// httptest.Server, so code built on evesso can be exercised without reaching
+// login.eveonline.com.
+//
+// The server publishes discovery metadata, an authorize endpoint that approves
+// the configured character without any UI, a token endpoint for both the
+// authorization-code (with PKCE) and refresh grants, a JWKS and a revocation
+// endpoint. Access tokens are RS256 JWTs carrying the same claims CCP issues,
+// so they pass evesso's verification unchanged.
+package evessotest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// Issuer is the issuer claim of every token the server mints and the
+	// issuer advertised in its metadata. It is CCP's own, the issuer evesso
+	// accepts by default.
+	Issuer = "https://login.eveonline.com"
+
+	DiscoveryPath = "/.well-known/oauth-authorization-server"
+	AuthorizePath = "/v2/oauth/authorize"
+	TokenPath     = "/v2/oauth/token"
+	RevokePath    = "/v2/oauth/revoke"
+	JWKSPath      = "/oauth/jwks"
+)
+
+// Endpoint names one of the server's endpoints for FailNext.
+type Endpoint string
+
+const (
+	EndpointDiscovery Endpoint = DiscoveryPath
+	EndpointAuthorize Endpoint = AuthorizePath
+	EndpointToken     Endpoint = TokenPath
+	EndpointRevoke    Endpoint = RevokePath
+	EndpointJWKS      Endpoint = JWKSPath
+)
+
+// Character is the identity the server approves authorizations for.
+type Character struct {
+	ID    int32
+	Name  string
+	Owner string
+}
+
+// grant is what a code or refresh token stands for.
+type grant struct {
+	characterID   int32
+	scopes        []string
+	redirectURI   string
+	challenge     string
+	challengeMode string
+	created       time.Time
+}
+
+type failure struct {
+	remaining int
+	status    int
+}
+
+// Server is a running SSO stand-in. The embedded httptest.Server gives its URL
+// and Close; Client is overridden to route the real SSO host here.
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	clientID     string
+	clientSecret string
+	tokenTTL     time.Duration
+	character    int32
+	characters   map[int32]Character
+	keys         []jwk.Key
+	codes        map[string]*grant
+	refresh      map[string]*grant
+	revoked      map[string]bool
+	failures     map[Endpoint]*failure
+	keySerial    int
+}
+
+// NewServer starts a server that accepts clientID and clientSecret and
+// approves every authorization as character. Close it when done.
+func NewServer(clientID, clientSecret string, character Character) *Server {
+	s := &Server{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		tokenTTL:     20 * time.Minute,
+		character:    character.ID,
+		characters:   map[int32]Character{character.ID: character},
+		codes:        make(map[string]*grant),
+		refresh:      make(map[string]*grant),
+		revoked:      make(map[string]bool),
+		failures:     make(map[Endpoint]*failure),
+	}
+	if err := s.rotate(); err != nil {
+		panic(fmt.Sprintf("evessotest: %s", err))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+DiscoveryPath, s.guard(EndpointDiscovery, s.serveDiscovery))
+	mux.HandleFunc("GET "+AuthorizePath, s.guard(EndpointAuthorize, s.serveAuthorize))
+	mux.HandleFunc("POST "+TokenPath, s.guard(EndpointToken, s.serveToken))
+	mux.HandleFunc("POST "+RevokePath, s.guard(EndpointRevoke, s.serveRevoke))
+	mux.HandleFunc("GET "+JWKSPath, s.guard(EndpointJWKS, s.serveJWKS))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Client returns an http.Client that sends requests for login.eveonline.com
+// to this server instead, so code with the real SSO host baked in talks to the
+// stand-in.
+func (s *Server) Client() *http.Client {
+	target, _ := url.Parse(s.URL)
+	base := s.Server.Client().Transport
+	return &http.Client{
+		Timeout: time.Minute,
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Hostname() == "login.eveonline.com" {
+				req = req.Clone(req.Context())
+				req.URL.Scheme = target.Scheme
+				req.URL.Host = target.Host
+				req.Host = target.Host
+			}
+			return base.RoundTrip(req)
+		}),
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// SetCharacter changes which character future authorizations approve.
+func (s *Server) SetCharacter(character Character) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.character = character.ID
+	s.characters[character.ID] = character
+}
+
+// SetOwner changes the owner hash minted for characterID from now on,
+// including on refreshes of grants issued before the change. It simulates a
+// character transfer.
+func (s *Server) SetOwner(characterID int32, owner string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	character := s.characters[characterID]
+	character.ID = characterID
+	character.Owner = owner
+	s.characters[characterID] = character
+}
+
+// SetTokenTTL changes the lifetime of access tokens minted from now on.
+func (s *Server) SetTokenTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenTTL = ttl
+}
+
+// FailNext makes the next n requests to endpoint answer with status and an
+// OAuth2 style error body instead of being served.
+func (s *Server) FailNext(endpoint Endpoint, n int, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[endpoint] = &failure{remaining: n, status: status}
+}
+
+// RotateKey starts signing with a fresh key. The previous keys stay in the
+// JWKS until DropRetiredKeys, as they do at CCP during a rotation.
+func (s *Server) RotateKey() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotate()
+}
+
+// DropRetiredKeys removes every key but the current one from the JWKS, so
+// tokens signed before the last RotateKey no longer verify.
+func (s *Server) DropRetiredKeys() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = s.keys[len(s.keys)-1:]
+}
+
+// KeyID returns the kid of the current signing key.
+func (s *Server) KeyID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kid, _ := s.keys[len(s.keys)-1].KeyID()
+	return kid
+}
+
+// RevokeRefreshToken makes refreshToken fail with invalid_grant, as if the
+// user had revoked the application.
+func (s *Server) RevokeRefreshToken(refreshToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[refreshToken] = true
+	delete(s.refresh, refreshToken)
+}
+
+// RevokeAll revokes every outstanding refresh token.
+func (s *Server) RevokeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token := range s.refresh {
+		s.revoked[token] = true
+	}
+	clear(s.refresh)
+}
+
+// Revoked reports whether refreshToken was revoked, either through the
+// revocation endpoint or one of the Revoke knobs.
+func (s *Server) Revoked(refreshToken string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revoked[refreshToken]
+}
+
+// IssueToken mints a token pair for the configured character without going
+// through the authorize endpoint. The refresh token is live on the server.
+func (s *Server) IssueToken(scopes ...string) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issue(&grant{characterID: s.character, scopes: scopes, created: time.Now()})
+}
+
+// Approve performs the authorize step for authURL the way a browser would and
+// returns the callback URL SSO redirects to, code and state included.
+func (s *Server) Approve(authURL string) (string, error) {
+	client := s.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return "", fmt.Errorf("evessotest: authorize returned %s", resp.Status)
+	}
+	return resp.Header.Get("Location"), nil
+}
+
+func (s *Server) guard(endpoint Endpoint, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		f := s.failures[endpoint]
+		status := 0
+		if f != nil && f.remaining > 0 {
+			f.remaining--
+			status = f.status
+		}
+		s.mu.Unlock()
+		if status != 0 {
+			writeError(w, status, "server_error", "simulated failure")
+			return
+		}
+		next(w, req)
+	}
+}
+
+func (s *Server) serveDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                   Issuer,
+		"authorization_endpoint":   s.URL + AuthorizePath,
+		"token_endpoint":           s.URL + TokenPath,
+		"response_types_supported": []string{"code", "token"},
+		"jwks_uri":                 s.URL + JWKSPath,
+		"revocation_endpoint":      s.URL + RevokePath,
+		"revocation_endpoint_auth_methods_supported":       []string{"client_secret_basic", "client_secret_post", "client_secret_jwt"},
+		"token_endpoint_auth_methods_supported":            []string{"client_secret_basic", "client_secret_post", "client_secret_jwt"},
+		"token_endpoint_auth_signing_alg_values_supported": []string{"HS256"},
+		"code_challenge_methods_supported":                 []string{"S256"},
+	})
+}
+
+func (s *Server) serveAuthorize(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	if q.Get("client_id") != s.clientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	if q.Get("response_type") != "code" {
+		http.Error(w, "unsupported response_type", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	challenge := q.Get("code_challenge")
+	method := q.Get("code_challenge_method")
+	if challenge == "" || method != "S256" {
+		// evesso always uses PKCE; a request without it is a bug worth failing on
+		http.Error(w, "an S256 code_challenge is required", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	code := randomString()
+	s.codes[code] = &grant{
+		characterID:   s.character,
+		scopes:        strings.Fields(q.Get("scope")),
+		redirectURI:   redirect.String(),
+		challenge:     challenge,
+		challengeMode: method,
+		created:       time.Now(),
+	}
+	s.mu.Unlock()
+
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", q.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, req, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, req *http.Request) {
+	if !s.authenticate(req) {
+		writeError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.PostFormValue("grant_type") {
+	case "authorization_code":
+		code := req.PostFormValue("code")
+		g, ok := s.codes[code]
+		delete(s.codes, code)
+		if !ok || time.Since(g.created) > 5*time.Minute {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "authorization code is invalid or expired")
+			return
+		}
+		if req.PostFormValue("redirect_uri") != "" && req.PostFormValue("redirect_uri") != g.redirectURI {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri mismatch")
+			return
+		}
+		sum := sha256.Sum256([]byte(req.PostFormValue("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match code_challenge")
+			return
+		}
+		s.respond(w, g)
+	case "refresh_token":
+		refreshToken := req.PostFormValue("refresh_token")
+		g, ok := s.refresh[refreshToken]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "refresh token is invalid, expired or revoked")
+			return
+		}
+		delete(s.refresh, refreshToken)
+		s.respond(w, g)
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", "unsupported grant_type")
+	}
+}
+
+func (s *Server) respond(w http.ResponseWriter, g *grant) {
+	token, err := s.issue(g)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  token.AccessToken,
+		"expires_in":    int(time.Until(token.Expiry).Seconds()),
+		"token_type":    "Bearer",
+		"refresh_token": token.RefreshToken,
+	})
+}
+
+func (s *Server) serveRevoke(w http.ResponseWriter, req *http.Request) {
+	if !s.authenticate(req) {
+		writeError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	token := req.PostFormValue("token")
+	if token == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+	s.mu.Lock()
+	if _, ok := s.refresh[token]; ok {
+		s.revoked[token] = true
+		delete(s.refresh, token)
+	}
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) serveJWKS(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	set := jwk.NewSet()
+	for _, key := range s.keys {
+		public, err := key.PublicKey()
+		if err == nil {
+			err = set.AddKey(public)
+		}
+		if err != nil {
+			s.mu.Unlock()
+			writeError(w, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, set)
+}
+
+// authenticate accepts client credentials in the form body or as basic auth.
+func (s *Server) authenticate(req *http.Request) bool {
+	id, secret, ok := req.BasicAuth()
+	if !ok {
+		id = req.PostFormValue("client_id")
+		secret = req.PostFormValue("client_secret")
+	}
+	return subtle.ConstantTimeCompare([]byte(id), []byte(s.clientID)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(secret), []byte(s.clientSecret)) == 1
+}
+
+// issue mints a token pair for g and registers the refresh token. s.mu must
+// be held.
+func (s *Server) issue(g *grant) (*oauth2.Token, error) {
+	now := time.Now()
+	expiry := now.Add(s.tokenTTL)
+	character := s.characters[g.characterID]
+	b := jwt.NewBuilder().
+		Issuer(Issuer).
+		Subject(fmt.Sprintf("CHARACTER:EVE:%d", g.characterID)).
+		Audience([]string{s.clientID, "EVE Online"}).
+		IssuedAt(now).
+		Expiration(expiry).
+		JwtID(randomString()).
+		Claim("name", character.Name).
+		Claim("owner", character.Owner).
+		Claim("azp", s.clientID).
+		Claim("tenant", "tranquility").
+		Claim("tier", "live").
+		Claim("region", "world")
+	// SSO sends a lone scope as a string rather than a one-element array.
+	switch len(g.scopes) {
+	case 0:
+	case 1:
+		b = b.Claim("scp", g.scopes[0])
+	default:
+		b = b.Claim("scp", g.scopes)
+	}
+	tok, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	signed, err := jwt.Sign(tok, jwt.WithKey(jwa.RS256(), s.keys[len(s.keys)-1]))
+	if err != nil {
+		return nil, err
+	}
+	refreshToken := randomString()
+	s.refresh[refreshToken] = g
+	return &oauth2.Token{
+		AccessToken:  string(signed),
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		Expiry:       expiry,
+	}, nil
+}
+
+// rotate appends a new signing key. s.mu must be held.
+func (s *Server) rotate() error {
+	raw, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	key, err := jwk.Import(raw)
+	if err != nil {
+		return err
+	}
+	s.keySerial++
+	if err = key.Set(jwk.KeyIDKey, fmt.Sprintf("JWT-Signature-Key-%d", s.keySerial)); err != nil {
+		return err
+	}
+	if err = key.Set(jwk.AlgorithmKey, jwa.RS256()); err != nil {
+		return err
+	}
+	if err = key.Set(jwk.KeyUsageKey, "sig"); err != nil {
+		return err
+	}
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func randomString() string {
+	buf := make([]byte, 32)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,201 @@
+package evessotest_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+const redirectURI = "http://localhost/callback"
+
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestAuthorizationCode(t *testing.T) {
+	verifier := "a-verifier-long-enough-to-be-a-real-one-0123456789"
+	tests := []struct {
+		name string
+		// authorize are the authorize request parameters over the defaults
+		authorize url.Values
+		// exchange are the token request parameters over the defaults
+		exchange url.Values
+		// authorizeStatus is the authorize response status; a code is only
+		// exchanged after a redirect
+		authorizeStatus int
+		tokenStatus     int
+	}{
+		{
+			name:            "S256",
+			authorizeStatus: http.StatusFound,
+			tokenStatus:     http.StatusOK,
+		},
+		{
+			name:            "no challenge",
+			authorize:       url.Values{"code_challenge": {""}},
+			authorizeStatus: http.StatusBadRequest,
+		},
+		{
+			name:            "plain method",
+			authorize:       url.Values{"code_challenge_method": {"plain"}, "code_challenge": {verifier}},
+			authorizeStatus: http.StatusBadRequest,
+		},
+		{
+			name:            "unknown client",
+			authorize:       url.Values{"client_id": {"someone-else"}},
+			authorizeStatus: http.StatusBadRequest,
+		},
+		{
+			name:            "relative redirect_uri",
+			authorize:       url.Values{"redirect_uri": {"/callback"}},
+			authorizeStatus: http.StatusBadRequest,
+		},
+		{
+			name:            "wrong verifier",
+			exchange:        url.Values{"code_verifier": {"not-the-verifier"}},
+			authorizeStatus: http.StatusFound,
+			tokenStatus:     http.StatusBadRequest,
+		},
+		{
+			name:            "no verifier",
+			exchange:        url.Values{"code_verifier": {""}},
+			authorizeStatus: http.StatusFound,
+			tokenStatus:     http.StatusBadRequest,
+		},
+		{
+			name:            "other redirect_uri",
+			exchange:        url.Values{"redirect_uri": {"http://localhost/elsewhere"}},
+			authorizeStatus: http.StatusFound,
+			tokenStatus:     http.StatusBadRequest,
+		},
+		{
+			name:            "wrong secret",
+			exchange:        url.Values{"client_secret": {"guess"}},
+			authorizeStatus: http.StatusFound,
+			tokenStatus:     http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := evessotest.NewServer(evessotest.ClientID, evessotest.ClientSecret, evessotest.Pilot)
+			defer srv.Close()
+			client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+			q := url.Values{
+				"client_id":             {evessotest.ClientID},
+				"response_type":         {"code"},
+				"redirect_uri":          {redirectURI},
+				"scope":                 {"publicData"},
+				"state":                 {"state"},
+				"code_challenge":        {challenge(verifier)},
+				"code_challenge_method": {"S256"},
+			}
+			for k, v := range tt.authorize {
+				q[k] = v
+			}
+			resp, err := client.Get(srv.URL + evessotest.AuthorizePath + "?" + q.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.authorizeStatus {
+				t.Fatalf("authorize = %d, want %d", resp.StatusCode, tt.authorizeStatus)
+			}
+			if resp.StatusCode != http.StatusFound {
+				return
+			}
+			callback, err := url.Parse(resp.Header.Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if callback.Query().Get("state") != "state" {
+				t.Errorf("state = %q", callback.Query().Get("state"))
+			}
+
+			form := url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {callback.Query().Get("code")},
+				"redirect_uri":  {redirectURI},
+				"code_verifier": {verifier},
+				"client_id":     {evessotest.ClientID},
+				"client_secret": {evessotest.ClientSecret},
+			}
+			for k, v := range tt.exchange {
+				form[k] = v
+			}
+			if resp, err = http.PostForm(srv.URL+evessotest.TokenPath, form); err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.tokenStatus {
+				t.Fatalf("token = %d, want %d", resp.StatusCode, tt.tokenStatus)
+			}
+
+			// a code is spent by any attempt to redeem it
+			form = url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {callback.Query().Get("code")},
+				"code_verifier": {verifier},
+				"client_id":     {evessotest.ClientID},
+				"client_secret": {evessotest.ClientSecret},
+			}
+			if resp, err = http.PostForm(srv.URL+evessotest.TokenPath, form); err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if tt.tokenStatus != http.StatusUnauthorized && resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("second redemption = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestFailNext(t *testing.T) {
+	srv := evessotest.NewServer(evessotest.ClientID, evessotest.ClientSecret, evessotest.Pilot)
+	defer srv.Close()
+	srv.FailNext(evessotest.EndpointDiscovery, 2, http.StatusServiceUnavailable)
+	for i, want := range []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK} {
+		resp, err := http.Get(srv.URL + evessotest.DiscoveryPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("request %d = %d, want %d", i, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	srv := evessotest.NewServer(evessotest.ClientID, evessotest.ClientSecret, evessotest.Pilot)
+	defer srv.Close()
+	token, err := srv.IssueToken("publicData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh := func() int {
+		resp, err := http.PostForm(srv.URL+evessotest.TokenPath, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {token.RefreshToken},
+			"client_id":     {evessotest.ClientID},
+			"client_secret": {evessotest.ClientSecret},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	srv.RevokeRefreshToken(token.RefreshToken)
+	if !srv.Revoked(token.RefreshToken) {
+		t.Error("not reported revoked")
+	}
+	if status := refresh(); status != http.StatusBadRequest {
+		t.Errorf("refresh after revocation = %d, want %d", status, http.StatusBadRequest)
+	}
+}
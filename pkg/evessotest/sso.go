@@ -0,0 +1,70 @@
+package evessotest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ferocious-space/evesso"
+)
+
+// The client NewSSO registers with its server.
+const (
+	ClientID     = "client-id"
+	ClientSecret = "secret"
+)
+
+// Pilot is the character NewSSO's server approves until SetCharacter.
+var Pilot = Character{ID: 90000001, Name: "Test Pilot", Owner: "owner-hash"}
+
+// Config returns the configuration NewSSO uses: its server's client, the
+// callback http://localhost/callback, redirecting to http://localhost/, and
+// localhost as the one return host. Change it and hand it to NewSSO with
+// evesso.WithConfig.
+func Config() *evesso.Config {
+	return &evesso.Config{
+		Key:         ClientID,
+		Secret:      ClientSecret,
+		Callback:    "http://localhost/callback",
+		Redirect:    "http://localhost/",
+		ReturnHosts: []string{"localhost"},
+		DSN:         "memory",
+	}
+}
+
+// NewSSO starts a Server approving Pilot and returns it with an EVESSO over a
+// new Store, set up against it. opts come after NewSSO's own, so they can
+// replace Config or the store. Both are closed when t ends.
+func NewSSO(t testing.TB, opts ...evesso.Option) (*Server, *evesso.EVESSO, *Store) {
+	t.Helper()
+	srv := NewServer(ClientID, ClientSecret, Pilot)
+	t.Cleanup(srv.Close)
+	store := NewStore()
+	opts = append([]evesso.Option{evesso.WithConfig(Config()), evesso.WithStore(store), evesso.WithIssuer(srv.URL)}, opts...)
+	sso, err := evesso.New(context.Background(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sso.Close() })
+	return srv, sso, store
+}
+
+// Authorize authorizes scopes into profile the way a browser would, through
+// srv's authorize endpoint and sso's callback, and returns the result.
+func Authorize(t testing.TB, srv *Server, sso *evesso.EVESSO, profile evesso.Profile, scopes ...string) *evesso.Authorization {
+	t.Helper()
+	pkce, err := sso.CreatePKCE(context.Background(), profile, "", nil, scopes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := srv.Approve(sso.AuthUrl(pkce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, _, err := sso.CompleteRequest(httptest.NewRequest(http.MethodGet, callback, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
@@ -0,0 +1,481 @@
+package evessotest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"golang.org/x/oauth2"
+
+	"github.com/ferocious-space/evesso"
+)
+
+var _ evesso.DataStore = &Store{}
+var _ evesso.RevokerSetter = &Store{}
+var _ evesso.PKCELister = &Store{}
+var _ evesso.ReturnURLCreator = &profile{}
+var _ evesso.ReturnURLHolder = &pkce{}
+
+// ErrNotFound is what Store returns for what it does not hold. It wraps
+// evesso.ErrNotFound.
+var ErrNotFound = fmt.Errorf("evessotest: %w", evesso.ErrNotFound)
+
+// Store is a DataStore held in memory, for tests that need evesso end to end
+// without a database. It behaves like evessopg where the library depends on
+// it: profile names are unique, FindCharacter reports ambiguity, and errors
+// wrap evesso's sentinels. The DSN is ignored.
+type Store struct {
+	mu         sync.Mutex
+	revoker    evesso.Revoker
+	profiles   []*profile
+	characters []*character
+	pkces      []*pkce
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{}
+}
+
+func (s *Store) Setup(context.Context, string) error {
+	return nil
+}
+
+func (s *Store) SetRevoker(revoker evesso.Revoker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoker = revoker
+}
+
+func (s *Store) NewProfile(_ context.Context, profileName string, data interface{}) (evesso.Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slices.ContainsFunc(s.profiles, func(p *profile) bool { return p.name == profileName }) {
+		return nil, fmt.Errorf("evessotest: profile %q: %w", profileName, evesso.ErrConflict)
+	}
+	p := &profile{store: s, id: uuid.New(), name: profileName, data: data}
+	s.profiles = append(s.profiles, p)
+	return p, nil
+}
+
+// AllProfiles returns the profiles in the order they were created.
+func (s *Store) AllProfiles(context.Context) ([]evesso.Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	profiles := make([]evesso.Profile, 0, len(s.profiles))
+	for _, p := range s.profiles {
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+func (s *Store) GetProfile(_ context.Context, profileID uuid.UUID) (evesso.Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.profile(profileID)
+}
+
+// profile returns the profile with id. The caller holds s.mu.
+func (s *Store) profile(id uuid.UUID) (*profile, error) {
+	i := slices.IndexFunc(s.profiles, func(p *profile) bool { return p.id == id })
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	return s.profiles[i], nil
+}
+
+func (s *Store) FindProfile(_ context.Context, profileName string) (evesso.Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.profiles, func(p *profile) bool { return p.name == profileName })
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	return s.profiles[i], nil
+}
+
+// FindCharacter looks among the active characters of every profile, where
+// empty fields match anything. More than one match wraps evesso.ErrAmbiguous,
+// as in evessopg.
+func (s *Store) FindCharacter(_ context.Context, characterID int32, characterName string, owner string) (evesso.Profile, evesso.Character, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found []*character
+	for _, c := range s.characters {
+		if c.matches(characterID, characterName, owner, nil) {
+			found = append(found, c)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, nil, ErrNotFound
+	case 1:
+		p, err := s.profile(found[0].profileID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return p, found[0], nil
+	default:
+		return nil, nil, fmt.Errorf("evessotest: character %d: %w", characterID, evesso.ErrAmbiguous)
+	}
+}
+
+func (s *Store) DeleteProfile(ctx context.Context, profileID uuid.UUID, opts ...evesso.DeleteOption) error {
+	s.mu.Lock()
+	p, err := s.profile(profileID)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	revokeErr := new(evesso.RevocationError)
+	if evesso.NewDeleteOptions(opts...).Revoke {
+		for _, c := range p.characters() {
+			if err := c.revoke(ctx); err != nil {
+				revokeErr.Add(c, err)
+			}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiles = slices.DeleteFunc(s.profiles, func(p *profile) bool { return p.id == profileID })
+	s.characters = slices.DeleteFunc(s.characters, func(c *character) bool { return c.profileID == profileID })
+	s.pkces = slices.DeleteFunc(s.pkces, func(k *pkce) bool { return k.profileID == profileID })
+	return revokeErr.Err()
+}
+
+// GetPKCE returns the row only while it has not expired.
+func (s *Store) GetPKCE(_ context.Context, pkceID uuid.UUID) (evesso.PKCE, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.pkces, func(k *pkce) bool { return k.id == pkceID && !evesso.PKCEExpired(k) })
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	return s.pkces[i], nil
+}
+
+// FindPKCE returns the row for state even when it has expired.
+func (s *Store) FindPKCE(_ context.Context, state uuid.UUID) (evesso.PKCE, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.pkces, func(k *pkce) bool { return k.state == state })
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	return s.pkces[i], nil
+}
+
+func (s *Store) CleanPKCE(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pkces = slices.DeleteFunc(s.pkces, func(k *pkce) bool { return evesso.PKCEExpired(k) })
+	return nil
+}
+
+// PendingPKCEs returns the rows that have not expired, oldest first.
+func (s *Store) PendingPKCEs(context.Context) ([]evesso.PKCE, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pending []evesso.PKCE
+	for _, k := range s.pkces {
+		if !evesso.PKCEExpired(k) {
+			pending = append(pending, k)
+		}
+	}
+	return pending, nil
+}
+
+type profile struct {
+	store *Store
+	id    uuid.UUID
+	name  string
+	data  any
+}
+
+func (p *profile) GetID() uuid.UUID {
+	return p.id
+}
+
+func (p *profile) GetName() string {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	return p.name
+}
+
+func (p *profile) GetData() any {
+	return p.data
+}
+
+func (p *profile) Rename(_ context.Context, name string) error {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	if slices.ContainsFunc(p.store.profiles, func(other *profile) bool { return other != p && other.name == name }) {
+		return fmt.Errorf("evessotest: profile %q: %w", name, evesso.ErrConflict)
+	}
+	p.name = name
+	return nil
+}
+
+// characters returns the profile's characters in the order they were created.
+func (p *profile) characters() []*character {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	var characters []*character
+	for _, c := range p.store.characters {
+		if c.profileID == p.id {
+			characters = append(characters, c)
+		}
+	}
+	return characters
+}
+
+func (p *profile) AllCharacters(context.Context) ([]evesso.Character, error) {
+	var characters []evesso.Character
+	for _, c := range p.characters() {
+		characters = append(characters, c)
+	}
+	return characters, nil
+}
+
+func (p *profile) GetCharacter(_ context.Context, id uuid.UUID) (evesso.Character, error) {
+	for _, c := range p.characters() {
+		if c.id == id {
+			return c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (p *profile) FindCharacter(_ context.Context, characterID int32, characterName string, owner string, scopes []string) (evesso.Character, error) {
+	for _, c := range p.characters() {
+		p.store.mu.Lock()
+		ok := c.matches(characterID, characterName, owner, scopes)
+		p.store.mu.Unlock()
+		if ok {
+			return c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// CreateCharacter stores claims as given. Authorizing the same character with
+// the same scopes again only replaces the refresh token, as evessopg does.
+func (p *profile) CreateCharacter(_ context.Context, claims evesso.CharacterClaims, token *oauth2.Token, referenceData interface{}) (evesso.Character, error) {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	for _, c := range p.store.characters {
+		if c.profileID == p.id && c.characterID == claims.CharacterID() && c.name == claims.CharacterName() &&
+			c.owner == claims.Owner() && slices.Equal(c.scopes, claims.Scopes()) {
+			c.refreshToken = token.RefreshToken
+			return c, nil
+		}
+	}
+	c := &character{
+		store:         p.store,
+		id:            uuid.New(),
+		profileID:     p.id,
+		characterID:   claims.CharacterID(),
+		name:          claims.CharacterName(),
+		owner:         claims.Owner(),
+		scopes:        claims.Scopes(),
+		referenceData: referenceData,
+		active:        true,
+		accessToken:   token.AccessToken,
+		refreshToken:  token.RefreshToken,
+	}
+	p.store.characters = append(p.store.characters, c)
+	return c, nil
+}
+
+func (p *profile) CreatePKCE(ctx context.Context, referenceData interface{}, scopes ...string) (evesso.PKCE, error) {
+	return p.CreatePKCEWithReturnURL(ctx, "", referenceData, scopes...)
+}
+
+func (p *profile) CreatePKCEWithReturnURL(_ context.Context, returnURL string, referenceData interface{}, scopes ...string) (evesso.PKCE, error) {
+	verifier := rand.Text() + rand.Text()
+	sum := sha256.Sum256([]byte(verifier))
+	k := &pkce{
+		store:         p.store,
+		id:            uuid.New(),
+		profileID:     p.id,
+		state:         uuid.New(),
+		verifier:      verifier,
+		challenge:     base64.RawURLEncoding.EncodeToString(sum[:]),
+		scopes:        scopes,
+		referenceData: referenceData,
+		returnURL:     returnURL,
+		created:       time.Now(),
+	}
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	p.store.pkces = append(p.store.pkces, k)
+	return k, nil
+}
+
+func (p *profile) RevokeAll(ctx context.Context) error {
+	revokeErr := new(evesso.RevocationError)
+	for _, c := range p.characters() {
+		if err := c.Revoke(ctx); err != nil {
+			revokeErr.Add(c, err)
+		}
+	}
+	return revokeErr.Err()
+}
+
+func (p *profile) Delete(ctx context.Context, opts ...evesso.DeleteOption) error {
+	return p.store.DeleteProfile(ctx, p.id, opts...)
+}
+
+type pkce struct {
+	store         *Store
+	id            uuid.UUID
+	profileID     uuid.UUID
+	state         uuid.UUID
+	verifier      string
+	challenge     string
+	scopes        []string
+	referenceData interface{}
+	returnURL     string
+	created       time.Time
+}
+
+func (k *pkce) GetID() uuid.UUID               { return k.id }
+func (k *pkce) GetProfileID() uuid.UUID        { return k.profileID }
+func (k *pkce) GetState() uuid.UUID            { return k.state }
+func (k *pkce) GetCodeVerifier() string        { return k.verifier }
+func (k *pkce) GetCodeChallange() string       { return k.challenge }
+func (k *pkce) GetCodeChallangeMethod() string { return "S256" }
+func (k *pkce) GetScopes() []string            { return k.scopes }
+func (k *pkce) GetReferenceData() interface{}  { return k.referenceData }
+func (k *pkce) GetReturnURL() string           { return k.returnURL }
+func (k *pkce) Time() time.Time                { return k.created }
+
+func (k *pkce) GetProfile(ctx context.Context) (evesso.Profile, error) {
+	return k.store.GetProfile(ctx, k.profileID)
+}
+
+func (k *pkce) Destroy(context.Context) error {
+	k.store.mu.Lock()
+	defer k.store.mu.Unlock()
+	k.store.pkces = slices.DeleteFunc(k.store.pkces, func(other *pkce) bool { return other == k })
+	return nil
+}
+
+// character's mutable fields are guarded by store.mu.
+type character struct {
+	store         *Store
+	id            uuid.UUID
+	profileID     uuid.UUID
+	characterID   int32
+	name          string
+	owner         string
+	scopes        []string
+	referenceData interface{}
+	active        bool
+	accessToken   string
+	refreshToken  string
+}
+
+// matches reports whether c is active and matches the fields that are given;
+// no scopes match any, as in evessopg. The caller holds store.mu.
+func (c *character) matches(characterID int32, characterName string, owner string, scopes []string) bool {
+	switch {
+	case !c.active,
+		characterID > 0 && c.characterID != characterID,
+		characterName != "" && c.name != characterName,
+		owner != "" && c.owner != owner,
+		slices.ContainsFunc(scopes, func(scope string) bool { return !slices.Contains(c.scopes, scope) }):
+		return false
+	}
+	return true
+}
+
+func (c *character) GetID() uuid.UUID              { return c.id }
+func (c *character) GetProfileID() uuid.UUID       { return c.profileID }
+func (c *character) GetCharacterName() string      { return c.name }
+func (c *character) GetCharacterID() int32         { return c.characterID }
+func (c *character) GetOwner() string              { return c.owner }
+func (c *character) GetScopes() []string           { return c.scopes }
+func (c *character) GetReferenceData() interface{} { return c.referenceData }
+
+func (c *character) IsActive() bool {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	return c.active
+}
+
+func (c *character) GetProfile(ctx context.Context) (evesso.Profile, error) {
+	return c.store.GetProfile(ctx, c.profileID)
+}
+
+func (c *character) UpdateAccessToken(_ context.Context, accessToken string) error {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	c.accessToken = accessToken
+	return nil
+}
+
+func (c *character) UpdateRefreshToken(_ context.Context, refreshToken string) error {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	c.refreshToken = refreshToken
+	return nil
+}
+
+func (c *character) UpdateActiveState(_ context.Context, active bool) error {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	c.active = active
+	return nil
+}
+
+// Token returns the stored tokens, expiring when the access token does.
+func (c *character) Token() (*oauth2.Token, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	token := &oauth2.Token{AccessToken: c.accessToken, RefreshToken: c.refreshToken, Expiry: time.Now()}
+	if parsed, err := jwt.ParseInsecure([]byte(c.accessToken)); err == nil {
+		if exp, ok := parsed.Expiration(); ok {
+			token.Expiry = exp
+		}
+	}
+	return token, nil
+}
+
+func (c *character) Revoke(ctx context.Context) error {
+	if err := c.revoke(ctx); err != nil {
+		return err
+	}
+	return c.UpdateActiveState(ctx, false)
+}
+
+// revoke revokes the stored refresh token without touching the character.
+func (c *character) revoke(ctx context.Context) error {
+	c.store.mu.Lock()
+	revoker, refreshToken := c.store.revoker, c.refreshToken
+	c.store.mu.Unlock()
+	if revoker == nil {
+		return evesso.ErrNoRevoker
+	}
+	return revoker.RevokeToken(ctx, refreshToken)
+}
+
+func (c *character) Delete(ctx context.Context, opts ...evesso.DeleteOption) error {
+	revokeErr := new(evesso.RevocationError)
+	if evesso.NewDeleteOptions(opts...).Revoke {
+		if err := c.revoke(ctx); err != nil {
+			revokeErr.Add(c, err)
+		}
+	}
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	c.store.characters = slices.DeleteFunc(c.store.characters, func(other *character) bool { return other == c })
+	return revokeErr.Err()
+}
@@ -0,0 +1,110 @@
+package evessotest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+// authorized returns a store whose profiles, named by profiles, each hold the
+// server's character, authorized through sso.
+func authorized(t *testing.T, profiles ...string) (*evessotest.Server, *evesso.EVESSO, *evessotest.Store) {
+	t.Helper()
+	srv, sso, store := evessotest.NewSSO(t)
+	for _, name := range profiles {
+		profile, err := store.NewProfile(context.Background(), name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		evessotest.Authorize(t, srv, sso, profile, "publicData")
+	}
+	return srv, sso, store
+}
+
+func TestStoreProfiles(t *testing.T) {
+	ctx := context.Background()
+	_, _, store := authorized(t)
+	first, err := store.NewProfile(ctx, "first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.NewProfile(ctx, "second", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		op   func() error
+		want error
+	}{
+		{"duplicate name", func() error { _, err := store.NewProfile(ctx, "first", nil); return err }, evesso.ErrConflict},
+		{"rename onto a taken name", func() error { return first.Rename(ctx, "second") }, evesso.ErrConflict},
+		{"rename", func() error { return first.Rename(ctx, "renamed") }, nil},
+		{"find by new name", func() error { _, err := store.FindProfile(ctx, "renamed"); return err }, nil},
+		{"find by old name", func() error { _, err := store.FindProfile(ctx, "first"); return err }, evesso.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreFindCharacter(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		profiles []string
+		// remove is deleted with evesso.WithRevocation before the lookup
+		remove string
+		want   error
+	}{
+		{name: "in no profile", want: evesso.ErrNotFound},
+		{name: "in one profile", profiles: []string{"main"}},
+		{name: "in two profiles", profiles: []string{"main", "alt"}, want: evesso.ErrAmbiguous},
+		{name: "the other profile deleted", profiles: []string{"main", "alt"}, remove: "alt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, sso, store := authorized(t, tt.profiles...)
+			var revoked []string
+			if tt.remove != "" {
+				profile, err := store.FindProfile(ctx, tt.remove)
+				if err != nil {
+					t.Fatal(err)
+				}
+				characters, err := profile.AllCharacters(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, c := range characters {
+					token, err := c.Token()
+					if err != nil {
+						t.Fatal(err)
+					}
+					revoked = append(revoked, token.RefreshToken)
+				}
+				if err = sso.DeleteProfile(ctx, profile, evesso.WithRevocation()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			profile, character, err := store.FindCharacter(ctx, evessotest.Pilot.ID, "", "")
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if err == nil && (profile.GetID() != character.GetProfileID() || profile.GetName() != tt.profiles[0]) {
+				t.Errorf("found in %q", profile.GetName())
+			}
+			for _, token := range revoked {
+				if !srv.Revoked(token) {
+					t.Errorf("refresh token of the deleted profile not revoked")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+// Package httpapi holds what evesso's HTTP APIs share: how callers
+// authenticate, and how store errors map to statuses. It knows no store, so
+// the APIs stay independent of the backend.
+package httpapi
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ferocious-space/evesso"
+)
+
+var (
+	// ErrUnauthenticated is returned by an Authenticator for a request that
+	// carries no credentials it recognises; it is answered with 401.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrForbidden is returned by an Authenticator for a caller it knows but
+	// will not let in; it is answered with 403.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Authenticator decides whether req may use an API, returning who made it.
+// An error other than ErrForbidden is answered with 401.
+type Authenticator func(req *http.Request) (principal string, err error)
+
+// BearerToken accepts requests whose Authorization header carries one of
+// tokens. The principal is the index of the token, so tokens never reach the
+// log.
+func BearerToken(tokens ...string) Authenticator {
+	return func(req *http.Request) (string, error) {
+		got, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || got == "" {
+			return "", ErrUnauthenticated
+		}
+		for i, token := range tokens {
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+				return "token-" + strconv.Itoa(i), nil
+			}
+		}
+		return "", ErrUnauthenticated
+	}
+}
+
+// NamedBearerTokens is BearerToken with a name for each token, keyed by name.
+// The principal is the name, for handlers that tell callers apart.
+func NamedBearerTokens(tokens map[string]string) Authenticator {
+	return func(req *http.Request) (string, error) {
+		got, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || got == "" {
+			return "", ErrUnauthenticated
+		}
+		for name, token := range tokens {
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+				return name, nil
+			}
+		}
+		return "", ErrUnauthenticated
+	}
+}
+
+// AuthStatus is the status a failed Authenticator is answered with.
+func AuthStatus(err error) int {
+	if errors.Is(err, ErrForbidden) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// StoreStatus maps a store error to a status: 404 for evesso.ErrNotFound, 409
+// for evesso.ErrConflict and evesso.ErrAmbiguous, and 500 for anything else.
+func StoreStatus(err error) int {
+	switch {
+	case errors.Is(err, evesso.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, evesso.ErrConflict), errors.Is(err, evesso.ErrAmbiguous):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,74 @@
+package httpapi_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/httpapi"
+)
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticate  httpapi.Authenticator
+		authorization string
+		principal     string
+		wantErr       bool
+	}{
+		{"first token", httpapi.BearerToken("a", "b"), "Bearer a", "token-0", false},
+		{"second token", httpapi.BearerToken("a", "b"), "Bearer b", "token-1", false},
+		{"unknown token", httpapi.BearerToken("a", "b"), "Bearer c", "", true},
+		{"empty token", httpapi.BearerToken(""), "Bearer ", "", true},
+		{"basic auth", httpapi.BearerToken("a"), "Basic YTpi", "", true},
+		{"no header", httpapi.BearerToken("a"), "", "", true},
+		{"named token", httpapi.NamedBearerTokens(map[string]string{"billing": "a", "alerts": "b"}), "Bearer b", "alerts", false},
+		{"unknown named token", httpapi.NamedBearerTokens(map[string]string{"billing": "a"}), "Bearer b", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			principal, err := tt.authenticate(req)
+			if tt.wantErr {
+				if !errors.Is(err, httpapi.ErrUnauthenticated) {
+					t.Fatalf("err = %v, want ErrUnauthenticated", err)
+				}
+				return
+			}
+			if err != nil || principal != tt.principal {
+				t.Fatalf("principal %q (%v), want %q", principal, err, tt.principal)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		err   error
+		auth  int
+		store int
+	}{
+		{httpapi.ErrUnauthenticated, http.StatusUnauthorized, http.StatusInternalServerError},
+		{fmt.Errorf("wrapped: %w", httpapi.ErrForbidden), http.StatusForbidden, http.StatusInternalServerError},
+		{fmt.Errorf("store: %w", evesso.ErrNotFound), http.StatusUnauthorized, http.StatusNotFound},
+		{fmt.Errorf("store: %w", evesso.ErrConflict), http.StatusUnauthorized, http.StatusConflict},
+		{fmt.Errorf("store: %w", evesso.ErrAmbiguous), http.StatusUnauthorized, http.StatusConflict},
+		{errors.New("connection refused"), http.StatusUnauthorized, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			if got := httpapi.AuthStatus(tt.err); got != tt.auth {
+				t.Errorf("AuthStatus = %d, want %d", got, tt.auth)
+			}
+			if got := httpapi.StoreStatus(tt.err); got != tt.store {
+				t.Errorf("StoreStatus = %d, want %d", got, tt.store)
+			}
+		})
+	}
+}
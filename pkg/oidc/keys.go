@@ -0,0 +1,76 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// signingKey is a private key with the algorithm it signs with.
+type signingKey struct {
+	alg jwa.SignatureAlgorithm
+	key jwk.Key
+}
+
+// newSigningKey imports key, picks its algorithm and names it by its
+// thumbprint, so the kid stays the same across restarts.
+func newSigningKey(key crypto.Signer) (signingKey, error) {
+	var alg jwa.SignatureAlgorithm
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return signingKey{}, fmt.Errorf("oidc: RSA signing key is %d bits, want at least 2048", k.N.BitLen())
+		}
+		alg = jwa.RS256()
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			alg = jwa.ES256()
+		case elliptic.P384():
+			alg = jwa.ES384()
+		case elliptic.P521():
+			alg = jwa.ES512()
+		default:
+			return signingKey{}, fmt.Errorf("oidc: unsupported ECDSA curve %s", k.Curve.Params().Name)
+		}
+	case ed25519.PrivateKey:
+		alg = jwa.EdDSA()
+	default:
+		return signingKey{}, fmt.Errorf("oidc: unsupported signing key %T", key)
+	}
+	jk, err := jwk.Import(key)
+	if err != nil {
+		return signingKey{}, err
+	}
+	if err = jwk.AssignKeyID(jk); err != nil {
+		return signingKey{}, err
+	}
+	if err = jk.Set(jwk.AlgorithmKey, alg); err != nil {
+		return signingKey{}, err
+	}
+	if err = jk.Set(jwk.KeyUsageKey, "sig"); err != nil {
+		return signingKey{}, err
+	}
+	return signingKey{alg: alg, key: jk}, nil
+}
+
+// publicSet is the JWKS of keys.
+func publicSet(keys []signingKey) (jwk.Set, error) {
+	set := jwk.NewSet()
+	for _, k := range keys {
+		public, err := k.key.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		if err = set.AddKey(public); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,644 @@
+// Package oidc runs evesso as an OpenID Connect provider, so internal tools
+// can "log in with EVE" through one registered SSO application. Users log in
+// upstream through weblogin, which runs the usual EVESSO PKCE flow; the
+// provider then issues its own signed ID and access tokens to the registered
+// client that asked.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/weblogin"
+)
+
+// ScopeOpenID is the one scope the provider understands. Others are ignored,
+// as the specification asks.
+const ScopeOpenID = "openid"
+
+// DiscoveryPath is where the discovery document is served under the issuer.
+const DiscoveryPath = "/.well-known/openid-configuration"
+
+// Claims the tokens carry about the user besides the standard ones. The
+// subject is the profile ID, so every character of a profile is the same user.
+const (
+	ClaimCharacterID = "character_id"
+	ClaimName        = "name"
+	ClaimOwner       = "owner"
+	ClaimProfileID   = "profile_id"
+)
+
+// codeLifetime is how long an authorization code can be redeemed.
+const codeLifetime = time.Minute
+
+// ErrInvalidRedirectURI is returned by RegisterClient for a redirect URI that
+// is not https, or plain http on a loopback host, or that has a fragment.
+var ErrInvalidRedirectURI = errors.New("oidc: invalid redirect URI")
+
+// Option configures a Provider.
+type Option func(*Provider)
+
+// WithSigningKeys sets the keys tokens are signed with: RSA of at least 2048
+// bits, ECDSA or Ed25519. The first signs and all are published, so a new key
+// can be put first while tokens signed with the old one are still in use.
+// Required.
+func WithSigningKeys(keys ...crypto.Signer) Option {
+	return func(p *Provider) {
+		p.signers = keys
+	}
+}
+
+// WithTokenLifetime sets how long ID and access tokens are valid. The default
+// is 15 minutes.
+func WithTokenLifetime(lifetime time.Duration) Option {
+	return func(p *Provider) {
+		p.lifetime = lifetime
+	}
+}
+
+// WithStore keeps clients and codes in store. By default a DataStore that
+// implements Store is used, and MemoryStore otherwise.
+func WithStore(store Store) Option {
+	return func(p *Provider) {
+		p.store = store
+	}
+}
+
+// WithLogger logs issued tokens and rejected requests to log.
+func WithLogger(log logr.Logger) Option {
+	return func(p *Provider) {
+		p.log = log
+	}
+}
+
+// Provider serves the discovery document, the JWKS and the authorize, token
+// and userinfo endpoints.
+type Provider struct {
+	sso      *evesso.EVESSO
+	login    *weblogin.Login
+	issuer   string
+	base     string
+	signers  []crypto.Signer
+	keys     []signingKey
+	public   jwk.Set
+	lifetime time.Duration
+	store    Store
+	log      logr.Logger
+	mux      *http.ServeMux
+}
+
+// New returns the provider for issuer, the URL it is mounted at. Users who
+// are not logged in are sent to login's /login and come back to /authorize:
+//
+//	mux.Handle("/auth/", http.StripPrefix("/auth", login))
+//	mux.Handle("/oidc/", http.StripPrefix("/oidc", provider))
+//
+// with the issuer https://app.example.com/oidc.
+func New(sso *evesso.EVESSO, login *weblogin.Login, issuer string, opts ...Option) (*Provider, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return nil, err
+	}
+	if !u.IsAbs() || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("oidc: issuer %q must be an absolute URL without query or fragment", issuer)
+	}
+	p := &Provider{
+		sso:      sso,
+		login:    login,
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		base:     strings.TrimSuffix(u.Path, "/"),
+		lifetime: 15 * time.Minute,
+		log:      logr.Discard(),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if len(p.signers) == 0 {
+		return nil, errors.New("oidc: no signing keys")
+	}
+	for _, signer := range p.signers {
+		key, err := newSigningKey(signer)
+		if err != nil {
+			return nil, err
+		}
+		p.keys = append(p.keys, key)
+	}
+	if p.public, err = publicSet(p.keys); err != nil {
+		return nil, err
+	}
+	if p.store == nil {
+		if store, ok := sso.Store().(Store); ok {
+			p.store = store
+		} else {
+			p.store = NewMemoryStore()
+		}
+	}
+
+	p.mux = http.NewServeMux()
+	p.mux.HandleFunc("GET "+DiscoveryPath, p.discovery)
+	p.mux.HandleFunc("GET /jwks", p.jwks)
+	p.mux.HandleFunc("GET /authorize", p.authorize)
+	p.mux.HandleFunc("POST /token", p.token)
+	p.mux.HandleFunc("GET /userinfo", p.userinfo)
+	p.mux.HandleFunc("POST /userinfo", p.userinfo)
+	return p, nil
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p.login.Middleware(p.mux).ServeHTTP(w, req)
+}
+
+// RegisterClient registers a client and returns it with its secret, which is
+// not stored and cannot be shown again. A public client gets no secret.
+func (p *Provider) RegisterClient(ctx context.Context, name string, redirectURIs []string, public bool) (Client, string, error) {
+	for _, uri := range redirectURIs {
+		if !validRedirectURI(uri) {
+			return Client{}, "", fmt.Errorf("%w: %q", ErrInvalidRedirectURI, uri)
+		}
+	}
+	if len(redirectURIs) == 0 {
+		return Client{}, "", fmt.Errorf("%w: none given", ErrInvalidRedirectURI)
+	}
+	client := Client{
+		ID:           uuid.NewString(),
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		Created:      time.Now(),
+	}
+	var secret string
+	if !public {
+		secret = randomString()
+		client.SecretHash = hash(secret)
+	}
+	if err := p.store.CreateClient(ctx, client); err != nil {
+		return Client{}, "", err
+	}
+	p.log.V(1).Info("client registered", "client_id", client.ID, "client_name", name, "public", public)
+	return client, secret, nil
+}
+
+// validRedirectURI reports whether codes may be sent to uri: https, or plain
+// http to a native app listening on the loopback interface.
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		if u.Hostname() == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(u.Hostname())
+		return ip != nil && ip.IsLoopback()
+	default:
+		return false
+	}
+}
+
+// Clients returns the registered clients.
+func (p *Provider) Clients(ctx context.Context) ([]Client, error) {
+	return p.store.AllClients(ctx)
+}
+
+// DeleteClient removes a client. Tokens already issued to it stay valid until
+// they expire.
+func (p *Provider) DeleteClient(ctx context.Context, clientID string) error {
+	return p.store.DeleteClient(ctx, clientID)
+}
+
+// Metadata is the discovery document.
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	ScopesSupported       []string `json:"scopes_supported"`
+	ResponseTypes         []string `json:"response_types_supported"`
+	GrantTypes            []string `json:"grant_types_supported"`
+	SubjectTypes          []string `json:"subject_types_supported"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported       []string `json:"claims_supported"`
+}
+
+// Metadata returns the discovery document.
+func (p *Provider) Metadata() Metadata {
+	var algs []string
+	for _, key := range p.keys {
+		if !slices.Contains(algs, key.alg.String()) {
+			algs = append(algs, key.alg.String())
+		}
+	}
+	return Metadata{
+		Issuer:                p.issuer,
+		AuthorizationEndpoint: p.issuer + "/authorize",
+		TokenEndpoint:         p.issuer + "/token",
+		UserinfoEndpoint:      p.issuer + "/userinfo",
+		JWKSURI:               p.issuer + "/jwks",
+		ScopesSupported:       []string{ScopeOpenID},
+		ResponseTypes:         []string{"code"},
+		GrantTypes:            []string{"authorization_code"},
+		SubjectTypes:          []string{"public"},
+		SigningAlgorithms:     algs,
+		TokenAuthMethods:      []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethods:  []string{"S256"},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce",
+			ClaimCharacterID, ClaimName, ClaimOwner, ClaimProfileID,
+		},
+	}
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, p.Metadata())
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, p.public)
+}
+
+// authorize checks the client and redirect URI, sends a user who is not
+// logged in through weblogin first, and redirects back to the client with a
+// code. Until the redirect URI is known to be the client's, errors are shown
+// to the user rather than sent to it.
+//
+// There is no consent page: a logged in user is sent back with a code at
+// once, since clients are registered by the operator, not by third parties.
+// prompt=login, and a max_age the login is older than, send the user through
+// SSO again.
+func (p *Provider) authorize(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	q := req.URL.Query()
+	client, found, err := p.store.GetClient(ctx, q.Get("client_id"))
+	if err != nil {
+		p.log.Error(err, "client could not be loaded", "client_id", q.Get("client_id"))
+		http.Error(w, "the login could not be started", http.StatusInternalServerError)
+		return
+	}
+	redirectURI := q.Get("redirect_uri")
+	if !found || !slices.Contains(client.RedirectURIs, redirectURI) {
+		p.log.V(1).Info("authorization rejected", "client_id", q.Get("client_id"), "redirect_uri", redirectURI)
+		http.Error(w, "unknown client or redirect URI", http.StatusBadRequest)
+		return
+	}
+	state := q.Get("state")
+	fail := func(code, description string) {
+		p.log.V(1).Info("authorization rejected", "client_id", client.ID, "error", code, "error_description", description)
+		redirect(w, req, redirectURI, url.Values{"error": {code}, "error_description": {description}, "state": {state}})
+	}
+	if q.Get("response_type") != "code" {
+		fail("unsupported_response_type", "only the code flow is supported")
+		return
+	}
+	scopes := strings.Fields(q.Get("scope"))
+	if !slices.Contains(scopes, ScopeOpenID) {
+		fail("invalid_scope", "the openid scope is required")
+		return
+	}
+	challenge := q.Get("code_challenge")
+	if method := q.Get("code_challenge_method"); challenge != "" && method != "S256" {
+		fail("invalid_request", "code_challenge_method must be S256")
+		return
+	}
+	if challenge == "" && client.Public() {
+		fail("invalid_request", "public clients must use PKCE")
+		return
+	}
+
+	prompt := strings.Fields(q.Get("prompt"))
+	maxAge := -1
+	if v := q.Get("max_age"); v != "" {
+		if maxAge, err = strconv.Atoi(v); err != nil || maxAge < 0 {
+			fail("invalid_request", "max_age must be a number of seconds")
+			return
+		}
+	}
+
+	session, ok := weblogin.SessionFromRequest(req)
+	if !ok || slices.Contains(prompt, "login") || maxAge >= 0 && time.Since(session.Created) > time.Duration(maxAge)*time.Second {
+		if slices.Contains(prompt, "none") {
+			fail("login_required", "the user must log in")
+			return
+		}
+		// the login that follows is fresh, so coming back must not ask again
+		back := maps.Clone(q)
+		back.Del("max_age")
+		back.Set("prompt", strings.Join(slices.DeleteFunc(prompt, func(v string) bool { return v == "login" }), " "))
+		if back.Get("prompt") == "" {
+			back.Del("prompt")
+		}
+		http.Redirect(w, req, p.login.LoginURL(p.base+"/authorize?"+back.Encode()), http.StatusFound)
+		return
+	}
+
+	code := randomString()
+	err = p.store.CreateCode(ctx, Code{
+		Hash:        hash(code),
+		ClientID:    client.ID,
+		RedirectURI: redirectURI,
+		ProfileID:   session.ProfileID,
+		CharacterID: session.CharacterID,
+		Scopes:      []string{ScopeOpenID},
+		Nonce:       q.Get("nonce"),
+		Challenge:   challenge,
+		AuthTime:    session.Created,
+		Expires:     time.Now().Add(codeLifetime),
+	})
+	if err != nil {
+		p.log.Error(err, "authorization code could not be stored", "client_id", client.ID)
+		fail("server_error", "the authorization could not be saved")
+		return
+	}
+	redirect(w, req, redirectURI, url.Values{"code": {code}, "state": {state}})
+}
+
+// TokenResponse is the token endpoint's answer.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// token redeems an authorization code for an ID token and an access token.
+func (p *Provider) token(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	w.Header().Set("Cache-Control", "no-store")
+	if req.PostFormValue("grant_type") != "authorization_code" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+	client, err := p.authenticate(req)
+	if err != nil {
+		if errors.Is(err, errInvalidClient) {
+			p.log.V(1).Info("token request rejected", "error", err.Error())
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+p.issuer+`"`)
+			writeError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+			return
+		}
+		p.log.Error(err, "client could not be loaded")
+		writeError(w, http.StatusInternalServerError, "server_error", "the client could not be loaded")
+		return
+	}
+	code, found, err := p.store.TakeCode(ctx, hash(req.PostFormValue("code")))
+	if err != nil {
+		p.log.Error(err, "authorization code could not be loaded", "client_id", client.ID)
+		writeError(w, http.StatusInternalServerError, "server_error", "the code could not be loaded")
+		return
+	}
+	if problem := checkCode(code, found, client, req); problem != "" {
+		p.log.V(1).Info("token request rejected", "client_id", client.ID, "error", problem)
+		writeError(w, http.StatusBadRequest, "invalid_grant", problem)
+		return
+	}
+
+	profile, err := p.sso.Store().GetProfile(ctx, code.ProfileID)
+	var character evesso.Character
+	if err == nil {
+		character, err = profile.GetCharacter(ctx, code.CharacterID)
+	}
+	if err != nil {
+		p.log.V(1).Info("token request for a removed login", "client_id", client.ID, "profile_id", code.ProfileID, "error", err.Error())
+		writeError(w, http.StatusBadRequest, "invalid_grant", "the user is no longer known")
+		return
+	}
+	now := time.Now()
+	expires := now.Add(p.lifetime)
+	identity := func(b *jwt.Builder) *jwt.Builder {
+		return b.Issuer(p.issuer).
+			Subject(profile.GetID().String()).
+			IssuedAt(now).
+			Expiration(expires).
+			Claim(ClaimCharacterID, character.GetCharacterID()).
+			Claim(ClaimName, character.GetCharacterName()).
+			Claim(ClaimOwner, character.GetOwner()).
+			Claim(ClaimProfileID, profile.GetID().String())
+	}
+	idb := identity(jwt.NewBuilder()).
+		Audience([]string{client.ID}).
+		Claim("auth_time", code.AuthTime.Unix())
+	if code.Nonce != "" {
+		idb = idb.Claim("nonce", code.Nonce)
+	}
+	idToken, err := p.sign(idb, "JWT")
+	if err != nil {
+		p.log.Error(err, "ID token could not be signed", "client_id", client.ID)
+		writeError(w, http.StatusInternalServerError, "server_error", "the token could not be signed")
+		return
+	}
+	scope := strings.Join(code.Scopes, " ")
+	accessToken, err := p.sign(identity(jwt.NewBuilder()).
+		Audience([]string{p.issuer}).
+		JwtID(uuid.NewString()).
+		Claim("client_id", client.ID).
+		Claim("scope", scope), "at+jwt")
+	if err != nil {
+		p.log.Error(err, "access token could not be signed", "client_id", client.ID)
+		writeError(w, http.StatusInternalServerError, "server_error", "the token could not be signed")
+		return
+	}
+	p.log.V(1).Info("token issued",
+		"client_id", client.ID,
+		"profile_id", profile.GetID(),
+		"character_id", character.GetCharacterID(),
+	)
+	writeJSON(w, http.StatusOK, TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(p.lifetime / time.Second),
+		IDToken:     idToken,
+		Scope:       scope,
+	})
+}
+
+var errInvalidClient = errors.New("invalid client")
+
+// authenticate identifies the client by basic auth or the form, checking the
+// secret of confidential clients.
+func (p *Provider) authenticate(req *http.Request) (Client, error) {
+	id, secret, basic := req.BasicAuth()
+	if basic {
+		// RFC 6749 form-encodes the credentials before basic auth
+		var err error
+		if id, err = url.QueryUnescape(id); err != nil {
+			return Client{}, fmt.Errorf("%w: %w", errInvalidClient, err)
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return Client{}, fmt.Errorf("%w: %w", errInvalidClient, err)
+		}
+	} else {
+		id, secret = req.PostFormValue("client_id"), req.PostFormValue("client_secret")
+	}
+	client, found, err := p.store.GetClient(req.Context(), id)
+	if err != nil {
+		return Client{}, err
+	}
+	if !found {
+		return Client{}, fmt.Errorf("%w: unknown client %q", errInvalidClient, id)
+	}
+	if !client.Public() && subtle.ConstantTimeCompare(hash(secret), client.SecretHash) != 1 {
+		return Client{}, fmt.Errorf("%w: wrong secret for %q", errInvalidClient, id)
+	}
+	return client, nil
+}
+
+// checkCode reports why code cannot be redeemed by client, or "".
+func checkCode(code Code, found bool, client Client, req *http.Request) string {
+	switch {
+	case !found:
+		return "unknown or used code"
+	case time.Now().After(code.Expires):
+		return "expired code"
+	case code.ClientID != client.ID:
+		return "the code was issued to another client"
+	case code.RedirectURI != req.PostFormValue("redirect_uri"):
+		return "redirect_uri does not match the authorization request"
+	}
+	verifier := req.PostFormValue("code_verifier")
+	if code.Challenge == "" {
+		if verifier != "" {
+			return "no code_challenge was sent for this code"
+		}
+		return ""
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(code.Challenge)) != 1 {
+		return "code_verifier does not match"
+	}
+	return ""
+}
+
+// sign signs the token b builds with the current key, typed typ.
+func (p *Provider) sign(b *jwt.Builder, typ string) (string, error) {
+	tok, err := b.Build()
+	if err != nil {
+		return "", err
+	}
+	headers := jws.NewHeaders()
+	if err = headers.Set(jws.TypeKey, typ); err != nil {
+		return "", err
+	}
+	key := p.keys[0]
+	signed, err := jwt.Sign(tok, jwt.WithKey(key.alg, key.key, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		return "", err
+	}
+	return string(signed), nil
+}
+
+// VerifyAccessToken checks an access token the provider issued and returns
+// it, for APIs that accept them. Tokens from other issuers, ID tokens and
+// expired tokens are rejected.
+func (p *Provider) VerifyAccessToken(accessToken string) (jwt.Token, error) {
+	msg, err := jws.ParseString(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if sigs := msg.Signatures(); len(sigs) != 1 {
+		return nil, errors.New("oidc: access token must have one signature")
+	} else if typ, _ := sigs[0].ProtectedHeaders().Type(); typ != "at+jwt" {
+		return nil, errors.New("oidc: not an access token")
+	}
+	return jwt.ParseString(accessToken,
+		jwt.WithKeySet(p.public),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.issuer),
+		jwt.WithAcceptableSkew(30*time.Second),
+	)
+}
+
+// userinfo answers with the claims of the bearer access token.
+func (p *Provider) userinfo(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	accessToken, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "invalid_token", "a bearer token is required")
+		return
+	}
+	tok, err := p.VerifyAccessToken(accessToken)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, "invalid_token", "the token is not valid")
+		return
+	}
+	info := make(map[string]any)
+	info["sub"], _ = tok.Subject()
+	for _, claim := range []string{ClaimCharacterID, ClaimName, ClaimOwner, ClaimProfileID} {
+		var v any
+		if tok.Get(claim, &v) == nil {
+			info[claim] = v
+		}
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// redirect sends the browser to uri with params added to its query. Empty
+// params are left out.
+func redirect(w http.ResponseWriter, req *http.Request, uri string, params url.Values) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		http.Error(w, "invalid redirect URI", http.StatusBadRequest)
+		return
+	}
+	q := u.Query()
+	for k, v := range params {
+		if len(v) > 0 && v[0] != "" {
+			q[k] = v
+		}
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, req, u.String(), http.StatusFound)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+// randomString is 32 random bytes, for codes and client secrets.
+func randomString() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hash(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+	return sum[:]
+}
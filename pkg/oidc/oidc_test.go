@@ -0,0 +1,426 @@
+package oidc_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+	"github.com/ferocious-space/evesso/pkg/oidc"
+	"github.com/ferocious-space/evesso/pkg/weblogin"
+)
+
+const (
+	issuer      = "http://localhost/oidc"
+	redirectURI = "https://rp.example.com/cb"
+	verifier    = "a-verifier-long-enough-to-be-a-real-one-0123456789"
+)
+
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+type fixture struct {
+	provider *oidc.Provider
+	mux      *http.ServeMux
+	// session is the cookie of a user logged in through weblogin
+	session *http.Cookie
+	// confidential and public are registered clients, secret is the former's
+	confidential oidc.Client
+	secret       string
+	public       oidc.Client
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	ctx := context.Background()
+	cfg := evessotest.Config()
+	cfg.Callback = "http://localhost/auth/callback"
+	srv, sso, _ := evessotest.NewSSO(t, evesso.WithConfig(cfg))
+	login, err := weblogin.New(sso, weblogin.WithKeys(bytes.Repeat([]byte{1}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := oidc.New(sso, login, issuer, oidc.WithSigningKeys(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fixture{provider: provider, mux: http.NewServeMux()}
+	f.mux.Handle("/auth/", http.StripPrefix("/auth", login))
+	f.mux.Handle("/oidc/", http.StripPrefix("/oidc", provider))
+	if f.confidential, f.secret, err = provider.RegisterClient(ctx, "confidential", []string{redirectURI}, false); err != nil {
+		t.Fatal(err)
+	}
+	if f.public, _, err = provider.RegisterClient(ctx, "public", []string{"http://127.0.0.1:8400/cb"}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := f.get("/auth/login")
+	var state *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == weblogin.DefaultCookieName+"_state" {
+			state = c
+		}
+	}
+	callback, err := srv.Approve(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = f.get(callback, state)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == weblogin.DefaultCookieName {
+			f.session = c
+		}
+	}
+	if f.session == nil {
+		t.Fatalf("login did not set a session: %d %s", rec.Code, rec.Body)
+	}
+	return f
+}
+
+func (f *fixture) get(target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, c := range cookies {
+		if c != nil {
+			req.AddCookie(c)
+		}
+	}
+	rec := httptest.NewRecorder()
+	f.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func (f *fixture) post(target string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	f.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// authorizeQuery is a valid authorization request of the confidential client.
+func (f *fixture) authorizeQuery() url.Values {
+	return url.Values{
+		"client_id":             {f.confidential.ID},
+		"redirect_uri":          {redirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid"},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+// code runs q through /authorize as the logged in user and returns the code.
+func (f *fixture) code(t *testing.T, q url.Values) string {
+	t.Helper()
+	rec := f.get("/oidc/authorize?"+q.Encode(), f.session)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || location.Query().Get("code") == "" {
+		t.Fatalf("authorize = %d to %q, want a code", rec.Code, rec.Header().Get("Location"))
+	}
+	return location.Query().Get("code")
+}
+
+func TestRegisterClient(t *testing.T) {
+	f := newFixture(t)
+	tests := []struct {
+		uri   string
+		valid bool
+	}{
+		{"https://rp.example.com/cb", true},
+		{"http://localhost:8400/cb", true},
+		{"http://127.0.0.1/cb", true},
+		{"http://[::1]:8400/cb", true},
+		{"http://rp.example.com/cb", false},
+		{"https://rp.example.com/cb#fragment", false},
+		{"/cb", false},
+		{"com.example.app:/cb", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			_, _, err := f.provider.RegisterClient(context.Background(), "client", []string{tt.uri}, true)
+			if valid := err == nil; valid != tt.valid {
+				t.Fatalf("err = %v, want valid %v", err, tt.valid)
+			}
+			if err != nil && !errors.Is(err, oidc.ErrInvalidRedirectURI) {
+				t.Errorf("err = %v, want ErrInvalidRedirectURI", err)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	f := newFixture(t)
+	tests := []struct {
+		name string
+		// change adjusts the valid request of the confidential client
+		change    func(q url.Values)
+		loggedOut bool
+		status    int
+		// redirect is the start of the Location; error is its error
+		// parameter and code whether it carries a code
+		redirect string
+		error    string
+		code     bool
+	}{
+		{name: "logged in", status: http.StatusFound, redirect: redirectURI, code: true},
+		{name: "unknown client", change: func(q url.Values) { q.Set("client_id", "nobody") }, status: http.StatusBadRequest},
+		{name: "unregistered redirect_uri", change: func(q url.Values) { q.Set("redirect_uri", "https://evil.example.com/cb") }, status: http.StatusBadRequest},
+		{name: "token flow", change: func(q url.Values) { q.Set("response_type", "token") }, status: http.StatusFound, redirect: redirectURI, error: "unsupported_response_type"},
+		{name: "no openid scope", change: func(q url.Values) { q.Set("scope", "profile") }, status: http.StatusFound, redirect: redirectURI, error: "invalid_scope"},
+		{name: "plain challenge", change: func(q url.Values) { q.Set("code_challenge_method", "plain") }, status: http.StatusFound, redirect: redirectURI, error: "invalid_request"},
+		{
+			name: "public client without PKCE",
+			change: func(q url.Values) {
+				q.Set("client_id", f.public.ID)
+				q.Set("redirect_uri", "http://127.0.0.1:8400/cb")
+				q.Del("code_challenge")
+				q.Del("code_challenge_method")
+			},
+			status:   http.StatusFound,
+			redirect: "http://127.0.0.1:8400/cb",
+			error:    "invalid_request",
+		},
+		{name: "bad max_age", change: func(q url.Values) { q.Set("max_age", "soon") }, status: http.StatusFound, redirect: redirectURI, error: "invalid_request"},
+		{name: "logged out", loggedOut: true, status: http.StatusFound, redirect: "/auth/login?return="},
+		{name: "logged out with prompt=none", change: func(q url.Values) { q.Set("prompt", "none") }, loggedOut: true, status: http.StatusFound, redirect: redirectURI, error: "login_required"},
+		{name: "prompt=login", change: func(q url.Values) { q.Set("prompt", "login") }, status: http.StatusFound, redirect: "/auth/login?return="},
+		{name: "max_age exceeded", change: func(q url.Values) { q.Set("max_age", "0") }, status: http.StatusFound, redirect: "/auth/login?return="},
+		{name: "max_age not exceeded", change: func(q url.Values) { q.Set("max_age", "3600") }, status: http.StatusFound, redirect: redirectURI, code: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := f.authorizeQuery()
+			if tt.change != nil {
+				tt.change(q)
+			}
+			session := f.session
+			if tt.loggedOut {
+				session = nil
+			}
+			rec := f.get("/oidc/authorize?"+q.Encode(), session)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code != http.StatusFound {
+				return
+			}
+			location := rec.Header().Get("Location")
+			if !strings.HasPrefix(location, tt.redirect) {
+				t.Fatalf("location = %q, want %q...", location, tt.redirect)
+			}
+			u, err := url.Parse(location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := u.Query().Get("error"); got != tt.error {
+				t.Errorf("error = %q, want %q", got, tt.error)
+			}
+			if got := u.Query().Get("code") != ""; got != tt.code {
+				t.Errorf("code given = %v, want %v", got, tt.code)
+			}
+			if tt.redirect == redirectURI && u.Query().Get("state") != "xyz" {
+				t.Errorf("state = %q, want xyz", u.Query().Get("state"))
+			}
+		})
+	}
+}
+
+// TestAuthorizeFreshLogin checks that the login prompt=login and max_age
+// send the user to does not demand another one on the way back.
+func TestAuthorizeFreshLogin(t *testing.T) {
+	f := newFixture(t)
+	for _, change := range []url.Values{{"prompt": {"login"}}, {"max_age": {"0"}}, {"prompt": {"login consent"}}} {
+		q := f.authorizeQuery()
+		for k, v := range change {
+			q[k] = v
+		}
+		rec := f.get("/oidc/authorize?"+q.Encode(), f.session)
+		login, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := url.Parse(login.Query().Get("return"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if back.Path != "/oidc/authorize" || back.Query().Has("max_age") || strings.Contains(back.Query().Get("prompt"), "login") {
+			t.Errorf("%v returns to %s", change, back)
+		}
+		if rec = f.get(back.String(), f.session); rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), redirectURI+"?code=") {
+			t.Errorf("%v: return = %d to %q, want a code", change, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	f := newFixture(t)
+	other, otherSecret, err := f.provider.RegisterClient(context.Background(), "other", []string{redirectURI}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		// authorize adjusts the authorization request, form the token
+		// request, which is sent after a first redemption when reuse is set
+		authorize func(q url.Values)
+		form      func(form url.Values)
+		reuse     bool
+		status    int
+		error     string
+	}{
+		{name: "code and verifier", status: http.StatusOK},
+		{name: "wrong verifier", form: func(form url.Values) { form.Set("code_verifier", "another-verifier") }, status: http.StatusBadRequest, error: "invalid_grant"},
+		{name: "no verifier", form: func(form url.Values) { form.Del("code_verifier") }, status: http.StatusBadRequest, error: "invalid_grant"},
+		{
+			name: "verifier without challenge",
+			authorize: func(q url.Values) {
+				q.Del("code_challenge")
+				q.Del("code_challenge_method")
+			},
+			status: http.StatusBadRequest,
+			error:  "invalid_grant",
+		},
+		{
+			name: "confidential client without PKCE",
+			authorize: func(q url.Values) {
+				q.Del("code_challenge")
+				q.Del("code_challenge_method")
+			},
+			form:   func(form url.Values) { form.Del("code_verifier") },
+			status: http.StatusOK,
+		},
+		{name: "other redirect_uri", form: func(form url.Values) { form.Set("redirect_uri", "https://rp.example.com/other") }, status: http.StatusBadRequest, error: "invalid_grant"},
+		{name: "reused code", reuse: true, status: http.StatusBadRequest, error: "invalid_grant"},
+		{name: "unknown code", form: func(form url.Values) { form.Set("code", "guess") }, status: http.StatusBadRequest, error: "invalid_grant"},
+		{
+			name: "another client's code",
+			form: func(form url.Values) {
+				form.Set("client_id", other.ID)
+				form.Set("client_secret", otherSecret)
+			},
+			status: http.StatusBadRequest,
+			error:  "invalid_grant",
+		},
+		{name: "wrong secret", form: func(form url.Values) { form.Set("client_secret", "guess") }, status: http.StatusUnauthorized, error: "invalid_client"},
+		{name: "unknown client", form: func(form url.Values) { form.Set("client_id", "nobody") }, status: http.StatusUnauthorized, error: "invalid_client"},
+		{name: "refresh grant", form: func(form url.Values) { form.Set("grant_type", "refresh_token") }, status: http.StatusBadRequest, error: "unsupported_grant_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := f.authorizeQuery()
+			if tt.authorize != nil {
+				tt.authorize(q)
+			}
+			form := url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {f.code(t, q)},
+				"redirect_uri":  {redirectURI},
+				"code_verifier": {verifier},
+				"client_id":     {f.confidential.ID},
+				"client_secret": {f.secret},
+			}
+			if tt.form != nil {
+				tt.form(form)
+			}
+			if tt.reuse {
+				if rec := f.post("/oidc/token", form); rec.Code != http.StatusOK {
+					t.Fatalf("first redemption = %d: %s", rec.Code, rec.Body)
+				}
+			}
+			rec := f.post("/oidc/token", form)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				var body struct{ Error string }
+				if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error != tt.error {
+					t.Errorf("error = %q (%v), want %q", body.Error, err, tt.error)
+				}
+				return
+			}
+			var token oidc.TokenResponse
+			if err := json.NewDecoder(rec.Body).Decode(&token); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.provider.VerifyAccessToken(token.AccessToken); err != nil {
+				t.Errorf("access token does not verify: %v", err)
+			}
+			if token.IDToken == "" || token.Scope != oidc.ScopeOpenID {
+				t.Errorf("token response %+v", token)
+			}
+		})
+	}
+}
+
+func TestUserinfo(t *testing.T) {
+	f := newFixture(t)
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {f.code(t, f.authorizeQuery())},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/oidc/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// RFC 6749 form-encodes basic auth credentials
+	req.SetBasicAuth(url.QueryEscape(f.confidential.ID), url.QueryEscape(f.secret))
+	rec := httptest.NewRecorder()
+	f.mux.ServeHTTP(rec, req)
+	var token oidc.TokenResponse
+	if err := json.NewDecoder(rec.Body).Decode(&token); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("token = %d (%v)", rec.Code, err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"access token", "Bearer " + token.AccessToken, http.StatusOK},
+		{"ID token", "Bearer " + token.IDToken, http.StatusUnauthorized},
+		{"no token", "", http.StatusUnauthorized},
+		{"not a token", "Bearer nope", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/oidc/userinfo", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			f.mux.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var info map[string]any
+			if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+				t.Fatal(err)
+			}
+			if info[oidc.ClaimName] != evessotest.Pilot.Name {
+				t.Errorf("userinfo %v", info)
+			}
+		})
+	}
+}
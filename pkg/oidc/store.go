@@ -0,0 +1,93 @@
+package oidc
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ferocious-space/evesso/pkg/webstore"
+)
+
+// Client is a registered downstream application. A client without a secret is
+// public, such as a single-page or native app, and must use PKCE.
+type Client = webstore.Client
+
+// Code is an authorization code waiting to be redeemed at the token endpoint.
+type Code = webstore.Code
+
+// Store keeps clients and authorization codes. A DataStore that implements it
+// is used unless WithStore names another.
+type Store = webstore.OIDCStore
+
+// MemoryStore is a Store for a single process, for tests and clients
+// registered at startup. Nothing survives a restart.
+type MemoryStore struct {
+	mu      sync.Mutex
+	clients map[string]Client
+	codes   map[string]Code
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{clients: make(map[string]Client), codes: make(map[string]Code)}
+}
+
+func (m *MemoryStore) CreateClient(_ context.Context, client Client) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clients[client.ID] = client
+	return nil
+}
+
+func (m *MemoryStore) GetClient(_ context.Context, clientID string) (Client, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	client, ok := m.clients[clientID]
+	return client, ok, nil
+}
+
+func (m *MemoryStore) AllClients(_ context.Context) ([]Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clients := make([]Client, 0, len(m.clients))
+	for _, client := range m.clients {
+		clients = append(clients, client)
+	}
+	slices.SortFunc(clients, func(a, b Client) int { return strings.Compare(a.ID, b.ID) })
+	return clients, nil
+}
+
+func (m *MemoryStore) DeleteClient(_ context.Context, clientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.clients, clientID)
+	for hash, code := range m.codes {
+		if code.ClientID == clientID {
+			delete(m.codes, hash)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateCode(_ context.Context, code Code) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for hash, c := range m.codes {
+		if now.After(c.Expires) {
+			delete(m.codes, hash)
+		}
+	}
+	m.codes[string(code.Hash)] = code
+	return nil
+}
+
+func (m *MemoryStore) TakeCode(_ context.Context, hash []byte) (Code, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.codes[string(hash)]
+	delete(m.codes, string(hash))
+	return code, ok, nil
+}
@@ -0,0 +1,273 @@
+package tokenserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/google/uuid"
+
+	"github.com/ferocious-space/evesso"
+)
+
+// Profile is a profile as the lookup endpoints show it.
+type Profile struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	Data any       `json:"data,omitempty"`
+}
+
+// Character is a character as the lookup endpoints show it, without tokens.
+type Character struct {
+	ID            uuid.UUID `json:"id"`
+	ProfileID     uuid.UUID `json:"profile_id"`
+	CharacterID   int32     `json:"character_id"`
+	CharacterName string    `json:"character_name"`
+	Owner         string    `json:"owner"`
+	Scopes        []string  `json:"scopes"`
+	Active        bool      `json:"active"`
+	ReferenceData any       `json:"reference_data,omitempty"`
+}
+
+// Found is the answer to GET /characters: the character and its profile.
+type Found struct {
+	Profile   Profile   `json:"profile"`
+	Character Character `json:"character"`
+}
+
+func profileView(p evesso.Profile) Profile {
+	return Profile{ID: p.GetID(), Name: p.GetName(), Data: p.GetData()}
+}
+
+func characterView(c evesso.Character) Character {
+	scopes := c.GetScopes()
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return Character{
+		ID:            c.GetID(),
+		ProfileID:     c.GetProfileID(),
+		CharacterID:   c.GetCharacterID(),
+		CharacterName: c.GetCharacterName(),
+		Owner:         c.GetOwner(),
+		Scopes:        scopes,
+		Active:        c.IsActive(),
+		ReferenceData: c.GetReferenceData(),
+	}
+}
+
+var errNotFound = errors.New("not found")
+
+// allows reports whether any rule lets client have tokens for character.
+func (s *Server) allows(client string, profile evesso.Profile, character evesso.Character) bool {
+	return slices.ContainsFunc(s.rules, func(rule Rule) bool { return rule.allows(client, profile, character) })
+}
+
+// sees reports whether client may see profile: whether any rule allows it
+// one of the profile's characters, active or not.
+func (s *Server) sees(ctx context.Context, client string, profile evesso.Profile) (bool, error) {
+	if !slices.ContainsFunc(s.rules, func(rule Rule) bool { return rule.sees(client, profile) }) {
+		return false, nil
+	}
+	characters, err := profile.AllCharacters(ctx)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(characters, func(c evesso.Character) bool { return s.allows(client, profile, c) }), nil
+}
+
+func clientOf(req *http.Request) string {
+	client, _ := req.Context().Value(clientKey{}).(string)
+	return client
+}
+
+// fail answers a lookup error. Whatever the client may not see is not found,
+// so the lookups do not reveal what exists beyond its rules. Other store
+// errors are logged and answered with errInternal.
+func (s *Server) fail(w http.ResponseWriter, req *http.Request, err error) {
+	if errors.Is(err, evesso.ErrAmbiguous) {
+		writeError(w, http.StatusConflict, errAmbiguous)
+		return
+	}
+	status := http.StatusNotFound
+	if !errors.Is(err, errNotFound) {
+		status = s.status(err)
+	}
+	if status == http.StatusNotFound {
+		writeError(w, status, errNotFound)
+		return
+	}
+	s.log.Error(err, "lookup failed", "client", clientOf(req), "path", req.URL.Path)
+	writeError(w, status, errInternal)
+}
+
+// listProfiles lists the profiles the client sees, or with ?name= the one
+// with that name. A profile is seen only with a character the client may
+// have tokens for.
+func (s *Server) listProfiles(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	var profiles []evesso.Profile
+	if name := req.URL.Query().Get("name"); name != "" {
+		profile, err := s.sso.Store().FindProfile(ctx, name)
+		if err != nil && s.status(err) != http.StatusNotFound {
+			s.fail(w, req, err)
+			return
+		}
+		if err == nil {
+			profiles = append(profiles, profile)
+		}
+	} else {
+		var err error
+		if profiles, err = s.sso.Store().AllProfiles(ctx); err != nil {
+			s.fail(w, req, err)
+			return
+		}
+	}
+	views := make([]Profile, 0, len(profiles))
+	for _, p := range profiles {
+		visible, err := s.sees(ctx, clientOf(req), p)
+		if err != nil {
+			s.fail(w, req, err)
+			return
+		}
+		if visible {
+			views = append(views, profileView(p))
+		}
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// profile loads the {profile} of req if the client sees it.
+func (s *Server) profile(w http.ResponseWriter, req *http.Request) (evesso.Profile, bool) {
+	id, err := uuid.Parse(req.PathValue("profile"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	profile, err := s.sso.Store().GetProfile(req.Context(), id)
+	visible := false
+	if err == nil {
+		visible, err = s.sees(req.Context(), clientOf(req), profile)
+	}
+	if err == nil && !visible {
+		err = errNotFound
+	}
+	if err != nil {
+		s.fail(w, req, err)
+		return nil, false
+	}
+	return profile, true
+}
+
+func (s *Server) getProfile(w http.ResponseWriter, req *http.Request) {
+	profile, ok := s.profile(w, req)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, profileView(profile))
+}
+
+// listCharacters lists the characters of a profile the client may have
+// tokens for, inactive ones included.
+func (s *Server) listCharacters(w http.ResponseWriter, req *http.Request) {
+	profile, ok := s.profile(w, req)
+	if !ok {
+		return
+	}
+	characters, err := profile.AllCharacters(req.Context())
+	if err != nil {
+		s.fail(w, req, err)
+		return
+	}
+	views := make([]Character, 0, len(characters))
+	for _, c := range characters {
+		if s.allows(clientOf(req), profile, c) {
+			views = append(views, characterView(c))
+		}
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) getCharacter(w http.ResponseWriter, req *http.Request) {
+	profile, ok := s.profile(w, req)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(req.PathValue("character"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	character, err := profile.GetCharacter(req.Context(), id)
+	if err == nil && !s.allows(clientOf(req), profile, character) {
+		err = errNotFound
+	}
+	if err != nil {
+		s.fail(w, req, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, characterView(character))
+}
+
+// findCharacter is DataStore.FindCharacter by ?character_id=, ?character_name=
+// and ?owner=: an active character matching all that are given.
+func (s *Server) findCharacter(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	var characterID int64
+	if v := q.Get("character_id"); v != "" {
+		var err error
+		if characterID, err = strconv.ParseInt(v, 10, 32); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	client := clientOf(req)
+	profile, character, err := s.sso.Store().FindCharacter(req.Context(), int32(characterID), q.Get("character_name"), q.Get("owner"))
+	if errors.Is(err, evesso.ErrAmbiguous) {
+		profile, character, err = s.findAllowed(req.Context(), client, int32(characterID), q.Get("character_name"), q.Get("owner"))
+	}
+	if err == nil && !s.allows(client, profile, character) {
+		err = errNotFound
+	}
+	if err != nil {
+		s.fail(w, req, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Found{Profile: profileView(profile), Character: characterView(character)})
+}
+
+// findAllowed looks for a character that is in several profiles among those
+// client may have it in, so the answer does not reveal the others.
+func (s *Server) findAllowed(ctx context.Context, client string, characterID int32, characterName string, owner string) (evesso.Profile, evesso.Character, error) {
+	profiles, err := s.sso.Store().AllProfiles(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var foundProfile evesso.Profile
+	var found evesso.Character
+	for _, profile := range profiles {
+		if !slices.ContainsFunc(s.rules, func(rule Rule) bool { return rule.sees(client, profile) }) {
+			continue
+		}
+		character, err := profile.FindCharacter(ctx, characterID, characterName, owner, nil)
+		if errors.Is(err, evesso.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if !s.allows(client, profile, character) {
+			continue
+		}
+		if found != nil {
+			return nil, nil, evesso.ErrAmbiguous
+		}
+		foundProfile, found = profile, character
+	}
+	if found == nil {
+		return nil, nil, errNotFound
+	}
+	return foundProfile, found, nil
+}
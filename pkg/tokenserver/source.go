@@ -0,0 +1,71 @@
+package tokenserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// NewSource returns a token source for services that use a token server: it
+// asks the server at endpoint, its /token URL, for r's token with credential
+// as the bearer token, and reuses each token until it expires. client is
+// http.DefaultClient if nil.
+func NewSource(ctx context.Context, endpoint, credential string, r Request, client *http.Client) oauth2.TokenSource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return oauth2.ReuseTokenSource(nil, &source{
+		ctx:        ctx,
+		endpoint:   endpoint,
+		credential: credential,
+		request:    r,
+		client:     client,
+	})
+}
+
+type source struct {
+	ctx        context.Context
+	endpoint   string
+	credential string
+	request    Request
+	client     *http.Client
+}
+
+func (s *source) Token() (*oauth2.Token, error) {
+	body, err := json.Marshal(s.request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.credential)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(data, &e)
+		return nil, fmt.Errorf("tokenserver: %s: %s", resp.Status, e.Error)
+	}
+	var t Token
+	if err = json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{AccessToken: t.AccessToken, TokenType: t.TokenType, Expiry: t.Expiry}, nil
+}
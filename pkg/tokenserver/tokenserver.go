@@ -0,0 +1,308 @@
+// Package tokenserver vends access tokens to internal services, so that only
+// one process holds refresh tokens and rotates them. Callers authenticate,
+// name a character, or a profile and the scopes they need, and get the
+// current access token and its expiry; never the refresh token.
+package tokenserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/httpapi"
+)
+
+// maxBody bounds request bodies.
+const maxBody = 1 << 16
+
+// Rule lets a client have tokens. A request is allowed if any rule for its
+// client allows it. Empty fields other than Client do not restrict.
+type Rule struct {
+	// Client is the principal the Authenticator returns.
+	Client string
+	// Profiles are the profiles whose characters the client may have.
+	Profiles []uuid.UUID
+	// Characters are the EVE character IDs the client may have.
+	Characters []int32
+	// Scopes bound the scopes of the tokens the client gets. A character
+	// whose token carries any other scope is refused, since the token grants
+	// all of them whatever the client asked for.
+	Scopes []string
+}
+
+func (rule Rule) allows(client string, profile evesso.Profile, character evesso.Character) bool {
+	if rule.Client != client {
+		return false
+	}
+	if len(rule.Profiles) > 0 && !slices.Contains(rule.Profiles, profile.GetID()) {
+		return false
+	}
+	if len(rule.Characters) > 0 && !slices.Contains(rule.Characters, character.GetCharacterID()) {
+		return false
+	}
+	if len(rule.Scopes) > 0 {
+		for _, scope := range character.GetScopes() {
+			if !slices.Contains(rule.Scopes, scope) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sees reports whether rule can allow client characters of profile at all,
+// so that profiles it cannot are passed over without loading their
+// characters.
+func (rule Rule) sees(client string, profile evesso.Profile) bool {
+	return rule.Client == client && (len(rule.Profiles) == 0 || slices.Contains(rule.Profiles, profile.GetID()))
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithLogger logs vended and refused tokens, with the client. Nothing is
+// logged by default.
+func WithLogger(log logr.Logger) Option {
+	return func(s *Server) {
+		s.log = log
+	}
+}
+
+// WithErrorStatus sets how store errors from looking up a character map to
+// HTTP statuses. The default is httpapi.StoreStatus.
+func WithErrorStatus(status func(err error) int) Option {
+	return func(s *Server) {
+		s.status = status
+	}
+}
+
+// Server serves POST /token, and read-only lookups of the profiles and
+// characters a client may have tokens for. Mount it under a prefix with
+// http.StripPrefix.
+type Server struct {
+	sso          *evesso.EVESSO
+	authenticate httpapi.Authenticator
+	rules        []Rule
+	log          logr.Logger
+	status       func(err error) int
+	mux          *http.ServeMux
+	// locks serializes refreshes per character, so concurrent requests do
+	// not race to rotate the same refresh token.
+	locks sync.Map
+}
+
+// New returns the token server over sso. Every request goes through
+// authenticate and then rules; a nil Authenticator rejects them all, and so
+// does a client without rules.
+func New(sso *evesso.EVESSO, authenticate httpapi.Authenticator, rules []Rule, opts ...Option) *Server {
+	s := &Server{
+		sso:          sso,
+		authenticate: authenticate,
+		rules:        rules,
+		log:          logr.Discard(),
+		status:       httpapi.StoreStatus,
+		mux:          http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.mux.HandleFunc("POST /token", s.token)
+	s.mux.HandleFunc("GET /profiles", s.listProfiles)
+	s.mux.HandleFunc("GET /profiles/{profile}", s.getProfile)
+	s.mux.HandleFunc("GET /profiles/{profile}/characters", s.listCharacters)
+	s.mux.HandleFunc("GET /profiles/{profile}/characters/{character}", s.getCharacter)
+	s.mux.HandleFunc("GET /characters", s.findCharacter)
+	return s
+}
+
+type clientKey struct{}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if s.authenticate == nil {
+		writeError(w, http.StatusUnauthorized, httpapi.ErrUnauthenticated)
+		return
+	}
+	client, err := s.authenticate(req)
+	if err != nil {
+		s.log.V(1).Info("request rejected", "path", req.URL.Path, "error", err.Error())
+		writeError(w, httpapi.AuthStatus(err), err)
+		return
+	}
+	s.mux.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), clientKey{}, client)))
+}
+
+// Request names the character a token is for: CharacterID alone, ProfileID
+// with the scopes the token needs, or both. Without a ProfileID the character
+// is looked up across profiles and must have Scopes too. ID, with ProfileID,
+// names one character record, as the lookup endpoints return it.
+type Request struct {
+	CharacterID int32     `json:"character_id,omitempty"`
+	ProfileID   uuid.UUID `json:"profile_id,omitzero"`
+	ID          uuid.UUID `json:"id,omitzero"`
+	Scopes      []string  `json:"scopes,omitempty"`
+}
+
+// Token is the answer to a Request.
+type Token struct {
+	AccessToken   string    `json:"access_token"`
+	TokenType     string    `json:"token_type"`
+	Expiry        time.Time `json:"expires_at"`
+	ExpiresIn     int64     `json:"expires_in"`
+	CharacterID   int32     `json:"character_id"`
+	CharacterName string    `json:"character_name"`
+	ProfileID     uuid.UUID `json:"profile_id"`
+	Scopes        []string  `json:"scopes"`
+}
+
+var (
+	errBadRequest  = errors.New("a character_id or profile_id is required, and id needs a profile_id")
+	errNoCharacter = errors.New("no active character matches the request")
+	errAmbiguous   = errors.New("the character is in more than one profile, give its profile_id")
+	errNotAllowed  = errors.New("the client may not have tokens for this character")
+	errDeactivated = errors.New("SSO rejected the refresh token, the character needs to be authorized again")
+	errUpstream    = errors.New("the token could not be refreshed")
+	// errInternal stands in for store errors, which are logged instead
+	errInternal = errors.New("internal error")
+)
+
+func (s *Server) token(w http.ResponseWriter, req *http.Request) {
+	client := clientOf(req)
+	var r Request
+	decoder := json.NewDecoder(io.LimitReader(req.Body, maxBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&r); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if r.ProfileID == uuid.Nil && (r.CharacterID == 0 || r.ID != uuid.Nil) {
+		writeError(w, http.StatusBadRequest, errBadRequest)
+		return
+	}
+	log := s.log.WithValues("client", client)
+
+	profile, character, err := s.find(req.Context(), r)
+	if err != nil {
+		log = log.WithValues("character_id", r.CharacterID, "profile_id", r.ProfileID)
+		if errors.Is(err, evesso.ErrAmbiguous) {
+			log.V(1).Info("ambiguous request", "error", err.Error())
+			writeError(w, http.StatusConflict, errAmbiguous)
+			return
+		}
+		status := http.StatusNotFound
+		if !errors.Is(err, errNoCharacter) {
+			status = s.status(err)
+		}
+		if status == http.StatusNotFound {
+			log.V(1).Info("no character for the request", "error", err.Error())
+			writeError(w, status, errNoCharacter)
+			return
+		}
+		log.Error(err, "character could not be loaded")
+		writeError(w, status, errInternal)
+		return
+	}
+	log = log.WithValues("character_id", character.GetCharacterID(), "profile_id", profile.GetID())
+	if !s.allows(client, profile, character) {
+		log.V(1).Info("token refused", "character_name", character.GetCharacterName())
+		writeError(w, http.StatusForbidden, errNotAllowed)
+		return
+	}
+
+	token, err := s.current(character)
+	if err != nil {
+		var retrieveError *oauth2.RetrieveError
+		if errors.As(err, &retrieveError) {
+			log.V(1).Info("token refused by SSO", "character_name", character.GetCharacterName())
+			writeError(w, http.StatusConflict, errDeactivated)
+			return
+		}
+		log.Error(err, "token could not be vended")
+		writeError(w, http.StatusBadGateway, errUpstream)
+		return
+	}
+	log.V(1).Info("token vended", "character_name", character.GetCharacterName(), "expiry", token.Expiry)
+	writeJSON(w, http.StatusOK, Token{
+		AccessToken:   token.AccessToken,
+		TokenType:     "Bearer",
+		Expiry:        token.Expiry,
+		ExpiresIn:     int64(time.Until(token.Expiry) / time.Second),
+		CharacterID:   character.GetCharacterID(),
+		CharacterName: character.GetCharacterName(),
+		ProfileID:     profile.GetID(),
+		Scopes:        character.GetScopes(),
+	})
+}
+
+// find resolves r to an active character.
+func (s *Server) find(ctx context.Context, r Request) (evesso.Profile, evesso.Character, error) {
+	store := s.sso.Store()
+	if r.ProfileID != uuid.Nil {
+		profile, err := store.GetProfile(ctx, r.ProfileID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if r.ID != uuid.Nil {
+			character, err := profile.GetCharacter(ctx, r.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !character.IsActive() {
+				return nil, nil, errNoCharacter
+			}
+			return profile, character, nil
+		}
+		character, err := profile.FindCharacter(ctx, r.CharacterID, "", "", r.Scopes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return profile, character, nil
+	}
+	profile, character, err := store.FindCharacter(ctx, r.CharacterID, "", "")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, scope := range r.Scopes {
+		if !slices.Contains(character.GetScopes(), scope) {
+			return nil, nil, errNoCharacter
+		}
+	}
+	return profile, character, nil
+}
+
+// current returns the character's access token, refreshing it if it has
+// expired. Each call reads the tokens from the store, so a character that
+// was authorized again since is refreshed with its new refresh token.
+func (s *Server) current(character evesso.Character) (*oauth2.Token, error) {
+	lock, _ := s.locks.LoadOrStore(character.GetID(), new(sync.Mutex))
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+	source, err := s.sso.CharacterSource(character)
+	if err != nil {
+		return nil, err
+	}
+	return source.Token()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,296 @@
+package tokenserver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+	"github.com/ferocious-space/evesso/pkg/httpapi"
+	"github.com/ferocious-space/evesso/pkg/tokenserver"
+)
+
+const wallet = "esi-wallet.read_character_wallet.v1"
+
+var (
+	mainPilot = evessotest.Character{ID: 90000001, Name: "Main Pilot", Owner: "owner-1"}
+	altPilot  = evessotest.Character{ID: 90000002, Name: "Alt Pilot", Owner: "owner-2"}
+	twin      = evessotest.Character{ID: 90000003, Name: "Twin Pilot", Owner: "owner-3"}
+)
+
+// fixture is a token server over four profiles: main holds mainPilot with
+// publicData, alt holds altPilot with publicData and the wallet scope, and
+// corp-a and corp-b both hold twin. The clients are those of rules, and
+// nobody, who has none.
+type fixture struct {
+	handler  http.Handler
+	profiles map[string]uuid.UUID
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	ctx := context.Background()
+	srv, sso, store := evessotest.NewSSO(t)
+
+	f := &fixture{profiles: make(map[string]uuid.UUID)}
+	for _, p := range []struct {
+		name      string
+		character evessotest.Character
+		scopes    []string
+	}{
+		{"main", mainPilot, []string{"publicData"}},
+		{"alt", altPilot, []string{"publicData", wallet}},
+		{"corp-a", twin, []string{"publicData"}},
+		{"corp-b", twin, []string{"publicData"}},
+	} {
+		profile, err := store.NewProfile(ctx, p.name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.SetCharacter(p.character)
+		evessotest.Authorize(t, srv, sso, profile, p.scopes...)
+		f.profiles[p.name] = profile.GetID()
+	}
+	tokens := map[string]string{}
+	for _, client := range []string{"any", "main-only", "alt-pilot", "public", "corp-a", "nobody"} {
+		tokens[client] = "token-of-" + client
+	}
+	f.handler = tokenserver.New(sso, httpapi.NamedBearerTokens(tokens), rules(f.profiles))
+	return f
+}
+
+// rules gives one client of each kind of rule.
+func rules(profiles map[string]uuid.UUID) []tokenserver.Rule {
+	return []tokenserver.Rule{
+		{Client: "any"},
+		{Client: "main-only", Profiles: []uuid.UUID{profiles["main"]}},
+		{Client: "alt-pilot", Characters: []int32{altPilot.ID}},
+		{Client: "public", Scopes: []string{"publicData"}},
+		{Client: "corp-a", Profiles: []uuid.UUID{profiles["corp-a"]}},
+	}
+}
+
+func (f *fixture) do(method, target, client string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	if client != "" {
+		req.Header.Set("Authorization", "Bearer token-of-"+client)
+	}
+	rec := httptest.NewRecorder()
+	f.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestToken(t *testing.T) {
+	f := newFixture(t)
+	tests := []struct {
+		name    string
+		client  string
+		request any
+		status  int
+		// character is who the token is for when it is vended
+		character int32
+	}{
+		{name: "by character", client: "any", request: tokenserver.Request{CharacterID: mainPilot.ID}, status: http.StatusOK, character: mainPilot.ID},
+		{name: "by character with scopes", client: "any", request: tokenserver.Request{CharacterID: altPilot.ID, Scopes: []string{wallet}}, status: http.StatusOK, character: altPilot.ID},
+		{name: "character lacks the scope", client: "any", request: tokenserver.Request{CharacterID: mainPilot.ID, Scopes: []string{wallet}}, status: http.StatusNotFound},
+		{name: "profile lacks the scope", client: "any", request: tokenserver.Request{ProfileID: f.profiles["main"], Scopes: []string{wallet}}, status: http.StatusNotFound},
+		{name: "character in two profiles", client: "any", request: tokenserver.Request{CharacterID: twin.ID}, status: http.StatusConflict},
+		{name: "character in two profiles, one named", client: "any", request: tokenserver.Request{CharacterID: twin.ID, ProfileID: f.profiles["corp-b"]}, status: http.StatusOK, character: twin.ID},
+		{name: "unknown character", client: "any", request: tokenserver.Request{CharacterID: 1}, status: http.StatusNotFound},
+		{name: "by profile and character", client: "any", request: tokenserver.Request{ProfileID: f.profiles["main"], CharacterID: mainPilot.ID}, status: http.StatusOK, character: mainPilot.ID},
+		{name: "unknown profile", client: "any", request: tokenserver.Request{ProfileID: uuid.New(), CharacterID: mainPilot.ID}, status: http.StatusNotFound},
+		{name: "nothing named", client: "any", request: tokenserver.Request{}, status: http.StatusBadRequest},
+		{name: "record without profile", client: "any", request: tokenserver.Request{CharacterID: mainPilot.ID, ID: uuid.New()}, status: http.StatusBadRequest},
+		{name: "unknown field", client: "any", request: map[string]any{"character_id": mainPilot.ID, "owner": "x"}, status: http.StatusBadRequest},
+		{name: "Profiles rule, its profile", client: "main-only", request: tokenserver.Request{CharacterID: mainPilot.ID}, status: http.StatusOK, character: mainPilot.ID},
+		{name: "Profiles rule, another profile", client: "main-only", request: tokenserver.Request{CharacterID: altPilot.ID}, status: http.StatusForbidden},
+		{name: "Characters rule, its character", client: "alt-pilot", request: tokenserver.Request{CharacterID: altPilot.ID}, status: http.StatusOK, character: altPilot.ID},
+		{name: "Characters rule, another character", client: "alt-pilot", request: tokenserver.Request{CharacterID: mainPilot.ID}, status: http.StatusForbidden},
+		{name: "Scopes rule, token within", client: "public", request: tokenserver.Request{CharacterID: mainPilot.ID}, status: http.StatusOK, character: mainPilot.ID},
+		{name: "Scopes rule, token grants more", client: "public", request: tokenserver.Request{CharacterID: altPilot.ID}, status: http.StatusForbidden},
+		{name: "client without rules", client: "nobody", request: tokenserver.Request{CharacterID: mainPilot.ID}, status: http.StatusForbidden},
+		{name: "no credential", request: tokenserver.Request{CharacterID: mainPilot.ID}, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := f.do(http.MethodPost, "/token", tt.client, body)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var token tokenserver.Token
+			if err = json.NewDecoder(rec.Body).Decode(&token); err != nil {
+				t.Fatal(err)
+			}
+			if token.CharacterID != tt.character || token.AccessToken == "" {
+				t.Errorf("token for %d, want %d", token.CharacterID, tt.character)
+			}
+		})
+	}
+}
+
+func TestLookups(t *testing.T) {
+	f := newFixture(t)
+	tests := []struct {
+		name   string
+		client string
+		path   string
+		status int
+		// count is the length of a list answer
+		count int
+	}{
+		{name: "all profiles", client: "any", path: "/profiles", status: http.StatusOK, count: 4},
+		{name: "profiles of a Profiles rule", client: "main-only", path: "/profiles", status: http.StatusOK, count: 1},
+		{name: "profiles of a Scopes rule", client: "public", path: "/profiles", status: http.StatusOK, count: 3},
+		{name: "profiles without rules", client: "nobody", path: "/profiles", status: http.StatusOK, count: 0},
+		{name: "profile by name", client: "any", path: "/profiles?name=alt", status: http.StatusOK, count: 1},
+		{name: "profile by unseen name", client: "main-only", path: "/profiles?name=alt", status: http.StatusOK, count: 0},
+		{name: "profile by unknown name", client: "any", path: "/profiles?name=nobody", status: http.StatusOK, count: 0},
+		{name: "seen profile", client: "main-only", path: "/profiles/" + f.profiles["main"].String(), status: http.StatusOK},
+		{name: "unseen profile", client: "main-only", path: "/profiles/" + f.profiles["alt"].String(), status: http.StatusNotFound},
+		{name: "unknown profile", client: "any", path: "/profiles/" + uuid.NewString(), status: http.StatusNotFound},
+		{name: "malformed profile", client: "any", path: "/profiles/nope", status: http.StatusBadRequest},
+		{name: "characters", client: "alt-pilot", path: "/profiles/" + f.profiles["alt"].String() + "/characters", status: http.StatusOK, count: 1},
+		{name: "characters of an unseen profile", client: "alt-pilot", path: "/profiles/" + f.profiles["main"].String() + "/characters", status: http.StatusNotFound},
+		{name: "find character", client: "any", path: "/characters?character_name=Main+Pilot", status: http.StatusOK},
+		{name: "find unseen character", client: "alt-pilot", path: "/characters?character_name=Main+Pilot", status: http.StatusNotFound},
+		{name: "find character in two profiles", client: "any", path: "/characters?character_id=90000003", status: http.StatusConflict},
+		{name: "find character in two profiles, one seen", client: "corp-a", path: "/characters?character_id=90000003", status: http.StatusOK},
+		{name: "find character in two profiles, none seen", client: "main-only", path: "/characters?character_id=90000003", status: http.StatusNotFound},
+		{name: "find malformed character_id", client: "any", path: "/characters?character_id=pilot", status: http.StatusBadRequest},
+		{name: "no credential", path: "/profiles", status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := f.do(http.MethodGet, tt.path, tt.client, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code != http.StatusOK || rec.Body.Bytes()[0] != '[' {
+				return
+			}
+			var list []json.RawMessage
+			if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != tt.count {
+				t.Errorf("%d entries, want %d", len(list), tt.count)
+			}
+		})
+	}
+}
+
+func TestGetCharacter(t *testing.T) {
+	f := newFixture(t)
+	rec := f.do(http.MethodGet, "/profiles/"+f.profiles["alt"].String()+"/characters", "any", nil)
+	var characters []tokenserver.Character
+	if err := json.NewDecoder(rec.Body).Decode(&characters); err != nil || len(characters) != 1 {
+		t.Fatalf("characters = %d (%v)", rec.Code, err)
+	}
+	path := "/profiles/" + f.profiles["alt"].String() + "/characters/" + characters[0].ID.String()
+	for client, status := range map[string]int{"any": http.StatusOK, "alt-pilot": http.StatusOK, "public": http.StatusNotFound, "main-only": http.StatusNotFound} {
+		if rec = f.do(http.MethodGet, path, client, nil); rec.Code != status {
+			t.Errorf("%s: status = %d, want %d", client, rec.Code, status)
+		}
+	}
+
+	// a record named by ID needs no scopes or character ID
+	body, _ := json.Marshal(tokenserver.Request{ProfileID: f.profiles["alt"], ID: characters[0].ID})
+	if rec = f.do(http.MethodPost, "/token", "alt-pilot", body); rec.Code != http.StatusOK {
+		t.Errorf("token by record = %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestNewSource(t *testing.T) {
+	f := newFixture(t)
+	srv := httptest.NewServer(f.handler)
+	defer srv.Close()
+	tests := []struct {
+		name       string
+		credential string
+		request    tokenserver.Request
+		wantErr    bool
+	}{
+		{"allowed", "token-of-any", tokenserver.Request{CharacterID: mainPilot.ID}, false},
+		{"refused", "token-of-main-only", tokenserver.Request{CharacterID: altPilot.ID}, true},
+		{"unknown credential", "guess", tokenserver.Request{CharacterID: mainPilot.ID}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tokenserver.NewSource(context.Background(), srv.URL+"/token", tt.credential, tt.request, nil).Token()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (token.AccessToken == "" || token.Expiry.IsZero()) {
+				t.Errorf("token %+v", token)
+			}
+		})
+	}
+}
+
+// brokenStore is a store that cannot be reached.
+type brokenStore struct {
+	*evessotest.Store
+}
+
+var errBroken = errors.New("dial tcp 10.0.0.5:5432: connection refused")
+
+func (brokenStore) GetProfile(context.Context, uuid.UUID) (evesso.Profile, error) {
+	return nil, errBroken
+}
+
+func (brokenStore) AllProfiles(context.Context) ([]evesso.Profile, error) {
+	return nil, errBroken
+}
+
+func (brokenStore) FindCharacter(context.Context, int32, string, string) (evesso.Profile, evesso.Character, error) {
+	return nil, nil, errBroken
+}
+
+func TestStoreFailure(t *testing.T) {
+	_, sso, _ := evessotest.NewSSO(t, evesso.WithStore(brokenStore{evessotest.NewStore()}))
+	f := &fixture{handler: tokenserver.New(sso, httpapi.NamedBearerTokens(map[string]string{"any": "token-of-any"}), []tokenserver.Rule{{Client: "any"}})}
+	profileRequest, _ := json.Marshal(tokenserver.Request{ProfileID: uuid.New()})
+	characterRequest, _ := json.Marshal(tokenserver.Request{CharacterID: mainPilot.ID})
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   []byte
+	}{
+		{name: "token by profile", method: http.MethodPost, path: "/token", body: profileRequest},
+		{name: "token by character", method: http.MethodPost, path: "/token", body: characterRequest},
+		{name: "profiles", method: http.MethodGet, path: "/profiles"},
+		{name: "profile", method: http.MethodGet, path: "/profiles/" + uuid.NewString()},
+		{name: "find character", method: http.MethodGet, path: "/characters?character_id=90000001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := f.do(tt.method, tt.path, "any", tt.body)
+			if rec.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want 500: %s", rec.Code, rec.Body)
+			}
+			// the store's error is logged, not sent
+			var answer struct{ Error string }
+			if err := json.NewDecoder(rec.Body).Decode(&answer); err != nil {
+				t.Fatal(err)
+			}
+			if answer.Error != "internal error" {
+				t.Errorf("error = %q", answer.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package weblogin
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ferocious-space/evesso/pkg/webstore"
+)
+
+// errBadCookie is a session cookie that cannot be opened.
+var errBadCookie = errors.New("weblogin: bad session cookie")
+
+// ErrSessionEnded is returned for a cookie whose session was ended server
+// side, or that expired.
+var ErrSessionEnded = errors.New("session ended")
+
+// Session is a login. The cookie carries it sealed; a SessionStore decides
+// whether it is still valid.
+type Session = webstore.Session
+
+// SessionStore keeps sessions server side, so they can be ended before their
+// cookies expire. A DataStore that implements it is used unless
+// WithSessionStore names another.
+type SessionStore = webstore.SessionStore
+
+// MemorySessions is a SessionStore for a single process. Sessions do not
+// survive a restart.
+type MemorySessions struct {
+	mu       sync.Mutex
+	sessions map[uuid.UUID]Session
+}
+
+// NewMemorySessions returns an empty MemorySessions.
+func NewMemorySessions() *MemorySessions {
+	return &MemorySessions{sessions: make(map[uuid.UUID]Session)}
+}
+
+func (m *MemorySessions) CreateSession(_ context.Context, session Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for id, s := range m.sessions {
+		if now.After(s.Expires) {
+			delete(m.sessions, id)
+		}
+	}
+	m.sessions[session.ID] = session
+	return nil
+}
+
+func (m *MemorySessions) SessionActive(_ context.Context, sessionID uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[sessionID]
+	return ok && time.Now().Before(s.Expires), nil
+}
+
+func (m *MemorySessions) DeleteSession(_ context.Context, sessionID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, sessionID)
+	return nil
+}
+
+func (m *MemorySessions) DeleteProfileSessions(_ context.Context, profileID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, s := range m.sessions {
+		if s.ProfileID == profileID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// keyIDSize is the length of the key fingerprint a sealed cookie starts with.
+const keyIDSize = 4
+
+// sealer encrypts cookies with the first key and opens them with any, so keys
+// can be rotated by prepending a new one and dropping the oldest later.
+type sealer struct {
+	keys []sealKey
+}
+
+type sealKey struct {
+	id   [keyIDSize]byte
+	aead cipher.AEAD
+}
+
+func newSealer(keys [][]byte) (*sealer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("weblogin: no cookie keys")
+	}
+	s := new(sealer)
+	for i, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("weblogin: cookie key %d is %d bytes, want 32", i, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		k := sealKey{aead: aead}
+		copy(k.id[:], sum[:])
+		s.keys = append(s.keys, k)
+	}
+	return s, nil
+}
+
+// seal encrypts v with the current key. The cookie name is authenticated too,
+// so a value cannot be moved to another cookie.
+func (s *sealer) seal(name string, v any) (string, error) {
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	key := s.keys[0]
+	out := make([]byte, keyIDSize, keyIDSize+key.aead.NonceSize()+len(plain)+key.aead.Overhead())
+	copy(out, key.id[:])
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	out = append(out, nonce...)
+	out = key.aead.Seal(out, nonce, plain, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(out), nil
+}
+
+// open decrypts a cookie value into v, reporting whether it was sealed with an
+// older key and should be sealed again.
+func (s *sealer) open(name, value string, v any) (stale bool, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) < keyIDSize {
+		return false, fmt.Errorf("%w: malformed", errBadCookie)
+	}
+	for i, key := range s.keys {
+		if [keyIDSize]byte(data[:keyIDSize]) != key.id {
+			continue
+		}
+		sealed := data[keyIDSize:]
+		if len(sealed) < key.aead.NonceSize() {
+			return false, fmt.Errorf("%w: malformed", errBadCookie)
+		}
+		nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+		plain, err := key.aead.Open(nil, nonce, ciphertext, []byte(name))
+		if err != nil {
+			return false, fmt.Errorf("%w: does not authenticate", errBadCookie)
+		}
+		if err = json.Unmarshal(plain, v); err != nil {
+			return false, fmt.Errorf("%w: %w", errBadCookie, err)
+		}
+		return i > 0, nil
+	}
+	return false, fmt.Errorf("%w: sealed with an unknown key", errBadCookie)
+}
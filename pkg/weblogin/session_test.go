@@ -0,0 +1,114 @@
+package weblogin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestSealer(t *testing.T) {
+	current := bytes.Repeat([]byte{1}, 32)
+	previous := bytes.Repeat([]byte{2}, 32)
+	type value struct{ N int }
+
+	tests := []struct {
+		name string
+		// sealWith and openWith are the key lists the value is sealed and
+		// opened with
+		sealWith [][]byte
+		openWith [][]byte
+		// mangle changes the sealed value before it is opened
+		mangle   func(string) string
+		openName string
+		stale    bool
+		wantErr  bool
+	}{
+		{name: "round trip", sealWith: [][]byte{current}, openWith: [][]byte{current}},
+		{name: "older key", sealWith: [][]byte{previous}, openWith: [][]byte{current, previous}, stale: true},
+		{name: "retired key", sealWith: [][]byte{previous}, openWith: [][]byte{current}, wantErr: true},
+		{name: "another cookie name", sealWith: [][]byte{current}, openWith: [][]byte{current}, openName: "other", wantErr: true},
+		{
+			name:     "tampered",
+			sealWith: [][]byte{current},
+			openWith: [][]byte{current},
+			mangle: func(v string) string {
+				data, _ := base64.RawURLEncoding.DecodeString(v)
+				data[len(data)-1] ^= 1
+				return base64.RawURLEncoding.EncodeToString(data)
+			},
+			wantErr: true,
+		},
+		{
+			name:     "truncated",
+			sealWith: [][]byte{current},
+			openWith: [][]byte{current},
+			mangle:   func(v string) string { return v[:keyIDSize+2] },
+			wantErr:  true,
+		},
+		{
+			name:     "not base64",
+			sealWith: [][]byte{current},
+			openWith: [][]byte{current},
+			mangle:   func(string) string { return "!!!" },
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealing, err := newSealer(tt.sealWith)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opening, err := newSealer(tt.openWith)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sealed, err := sealing.seal("session", value{N: 42})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.mangle != nil {
+				sealed = tt.mangle(sealed)
+			}
+			name := "session"
+			if tt.openName != "" {
+				name = tt.openName
+			}
+			var got value
+			stale, err := opening.open(name, sealed, &got)
+			if tt.wantErr {
+				if !errors.Is(err, errBadCookie) {
+					t.Fatalf("err = %v, want errBadCookie", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.N != 42 || stale != tt.stale {
+				t.Errorf("opened %+v, stale %v, want 42, stale %v", got, stale, tt.stale)
+			}
+		})
+	}
+}
+
+func TestNewSealerKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    [][]byte
+		wantErr bool
+	}{
+		{"no keys", nil, true},
+		{"short key", [][]byte{make([]byte, 16)}, true},
+		{"one bad key among good ones", [][]byte{make([]byte, 32), make([]byte, 31)}, true},
+		{"32 byte keys", [][]byte{make([]byte, 32), bytes.Repeat([]byte{1}, 32)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newSealer(tt.keys); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
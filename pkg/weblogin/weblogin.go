@@ -0,0 +1,594 @@
+// Package weblogin is "Log in with EVE" for web applications: it turns an
+// EVESSO callback into a browser session. Sessions live in an encrypted cookie
+// and in a SessionStore, so they expire on their own and can be ended server
+// side. Downstream handlers get the logged-in profile from FromRequest.
+package weblogin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/uuid"
+
+	"github.com/ferocious-space/evesso"
+)
+
+const (
+	// DefaultCookieName names the session cookie unless WithCookieName is used.
+	DefaultCookieName = "evesso_session"
+	// DefaultLoginProfile names the profile new logins land in until they
+	// are resolved, unless WithLoginProfile is used.
+	DefaultLoginProfile = "weblogin"
+	// DefaultScope is what /login asks for unless WithScopes is used. It
+	// grants nothing beyond the character's identity.
+	DefaultScope = "publicData"
+)
+
+// stateCookieSuffix names the cookie binding a login to the browser that
+// started it.
+const stateCookieSuffix = "_state"
+
+// Resolver decides which profile and character a completed login is. It gets
+// the authorization as the callback persisted it.
+type Resolver func(ctx context.Context, auth *evesso.Authorization) (evesso.Profile, evesso.Character, error)
+
+// Option configures a Login.
+type Option func(*Login)
+
+// WithKeys sets the 32-byte AES-256 keys session cookies are encrypted with.
+// The first seals new cookies and any opens them: to rotate, put a new key
+// first and drop the oldest once the idle timeout has passed. Required.
+func WithKeys(keys ...[]byte) Option {
+	return func(l *Login) {
+		l.keys = keys
+	}
+}
+
+// WithScopes sets the scopes /login asks for instead of DefaultScope.
+func WithScopes(scopes ...string) Option {
+	return func(l *Login) {
+		l.scopes = scopes
+	}
+}
+
+// WithCookieName names the session cookie.
+func WithCookieName(name string) Option {
+	return func(l *Login) {
+		l.cookie = name
+	}
+}
+
+// WithLifetime sets how long a session lasts without being used, and at most.
+// The defaults are 24 hours and 30 days.
+func WithLifetime(idle, max time.Duration) Option {
+	return func(l *Login) {
+		l.idle, l.max = idle, max
+	}
+}
+
+// WithSessionStore keeps sessions in store. By default a DataStore that
+// implements SessionStore is used, and MemorySessions otherwise.
+func WithSessionStore(store SessionStore) Option {
+	return func(l *Login) {
+		l.sessions = store
+	}
+}
+
+// WithLoginProfile names the profile new logins land in.
+func WithLoginProfile(name string) Option {
+	return func(l *Login) {
+		l.holding = name
+	}
+}
+
+// WithResolver replaces how a completed login is matched to a profile.
+func WithResolver(resolve Resolver) Option {
+	return func(l *Login) {
+		l.resolve = resolve
+	}
+}
+
+// WithLogger logs logins and logouts to log.
+func WithLogger(log logr.Logger) Option {
+	return func(l *Login) {
+		l.log = log
+	}
+}
+
+// Login serves /login, /callback, /logout and /me, and authenticates the
+// requests that pass through Middleware.
+type Login struct {
+	sso      *evesso.EVESSO
+	store    evesso.DataStore
+	keys     [][]byte
+	sealer   *sealer
+	sessions SessionStore
+	scopes   []string
+	cookie   string
+	holding  string
+	secure   bool
+	path     string
+	idle     time.Duration
+	max      time.Duration
+	resolve  Resolver
+	log      logr.Logger
+	mux      *http.ServeMux
+}
+
+// New returns the login router for sso. Mount it so that /callback is the
+// configured callback URL, in place of sso itself:
+//
+//	mux.Handle("/auth/", http.StripPrefix("/auth", login))
+//
+// with the callback set to https://app.example.com/auth/callback. New fails
+// for a callback whose path does not end in /callback.
+func New(sso *evesso.EVESSO, opts ...Option) (*Login, error) {
+	l := &Login{
+		sso:     sso,
+		store:   sso.Store(),
+		cookie:  DefaultCookieName,
+		holding: DefaultLoginProfile,
+		scopes:  []string{DefaultScope},
+		idle:    24 * time.Hour,
+		max:     30 * 24 * time.Hour,
+		log:     logr.Discard(),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	var err error
+	if l.sealer, err = newSealer(l.keys); err != nil {
+		return nil, err
+	}
+	if l.sessions == nil {
+		if store, ok := l.store.(SessionStore); ok {
+			l.sessions = store
+		} else {
+			l.sessions = NewMemorySessions()
+		}
+	}
+	if l.resolve == nil {
+		l.resolve = l.resolveLogin
+	}
+	callback, err := url.Parse(sso.AppConfig().Callback)
+	if err != nil {
+		return nil, err
+	}
+	l.secure = callback.Scheme == "https"
+	var ok bool
+	if l.path, ok = strings.CutSuffix(callback.Path, "/callback"); !ok {
+		return nil, fmt.Errorf("weblogin: callback %s does not end in /callback", callback.Path)
+	}
+
+	l.mux = http.NewServeMux()
+	l.mux.HandleFunc("GET /login", l.login)
+	l.mux.HandleFunc("GET /callback", l.callback)
+	l.mux.HandleFunc("POST /logout", l.logout)
+	l.mux.HandleFunc("GET /me", l.me)
+	return l, nil
+}
+
+func (l *Login) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	l.Middleware(l.mux).ServeHTTP(w, req)
+}
+
+// LoginURL is the path of /login, where it is mounted for the callback URL,
+// returning to returnURL afterwards.
+func (l *Login) LoginURL(returnURL string) string {
+	if returnURL == "" {
+		return l.path + "/login"
+	}
+	return l.path + "/login?" + url.Values{"return": {returnURL}}.Encode()
+}
+
+type identityKey struct{}
+
+// identity is the login of a request.
+type identity struct {
+	session   Session
+	profile   evesso.Profile
+	character evesso.Character
+}
+
+// FromRequest returns the profile logged in on req, if it passed through
+// Middleware with a valid session.
+func FromRequest(req *http.Request) (evesso.Profile, bool) {
+	id, ok := req.Context().Value(identityKey{}).(*identity)
+	if !ok {
+		return nil, false
+	}
+	return id.profile, true
+}
+
+// CharacterFromRequest returns the character that logged in on req.
+func CharacterFromRequest(req *http.Request) (evesso.Character, bool) {
+	id, ok := req.Context().Value(identityKey{}).(*identity)
+	if !ok {
+		return nil, false
+	}
+	return id.character, true
+}
+
+// SessionFromRequest returns the session of req.
+func SessionFromRequest(req *http.Request) (Session, bool) {
+	id, ok := req.Context().Value(identityKey{}).(*identity)
+	if !ok {
+		return Session{}, false
+	}
+	return id.session, true
+}
+
+// Middleware authenticates the session cookie of each request for
+// FromRequest, and passes requests without a valid one on unchanged. A cookie
+// that is half way to its idle timeout, or sealed with an older key, is
+// sealed again.
+func (l *Login) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if id := l.authenticate(w, req); id != nil {
+			req = req.WithContext(context.WithValue(req.Context(), identityKey{}, id))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// EndSessions ends every session of profileID, for "log out everywhere".
+func (l *Login) EndSessions(ctx context.Context, profileID uuid.UUID) error {
+	return l.sessions.DeleteProfileSessions(ctx, profileID)
+}
+
+func (l *Login) authenticate(w http.ResponseWriter, req *http.Request) *identity {
+	cookie, err := req.Cookie(l.cookie)
+	if err != nil {
+		return nil
+	}
+	ctx := req.Context()
+	var session Session
+	stale, err := l.sealer.open(l.cookie, cookie.Value, &session)
+	if err == nil {
+		err = l.check(ctx, session)
+	}
+	id := &identity{session: session}
+	if err == nil {
+		id.profile, err = l.store.GetProfile(ctx, session.ProfileID)
+	}
+	if err == nil {
+		id.character, err = id.profile.GetCharacter(ctx, session.CharacterID)
+	}
+	if err != nil {
+		l.log.V(1).Info("session rejected", "error", err.Error())
+		// keep the cookie through store outages, it may be good again
+		if errors.Is(err, ErrSessionEnded) || errors.Is(err, errBadCookie) {
+			l.clearCookie(w, l.cookie)
+		}
+		return nil
+	}
+	if stale || time.Since(session.Issued) > l.idle/2 {
+		if err = l.issue(w, &id.session); err != nil {
+			l.log.Error(err, "session cookie could not be sealed again", "profile_id", session.ProfileID)
+		}
+	}
+	return id
+}
+
+// check reports whether session is still valid, here and server side.
+func (l *Login) check(ctx context.Context, session Session) error {
+	now := time.Now()
+	if now.After(session.Expires) || now.After(session.Issued.Add(l.idle)) {
+		return ErrSessionEnded
+	}
+	active, err := l.sessions.SessionActive(ctx, session.ID)
+	if err != nil {
+		return err
+	}
+	if !active {
+		return ErrSessionEnded
+	}
+	return nil
+}
+
+// issue seals session into the cookie, stamped now.
+func (l *Login) issue(w http.ResponseWriter, session *Session) error {
+	session.Issued = time.Now()
+	value, err := l.sealer.seal(l.cookie, session)
+	if err != nil {
+		return err
+	}
+	expires := session.Issued.Add(l.idle)
+	if session.Expires.Before(expires) {
+		expires = session.Expires
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     l.cookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   l.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (l *Login) clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   l.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// login starts an authorization. A logged-in user's new character joins their
+// profile; anyone else's lands in the login profile until the callback
+// resolves it. ?return= is where to go afterwards, if CheckReturnURL accepts
+// it.
+func (l *Login) login(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	returnURL := req.FormValue("return")
+	if returnURL != "" && l.sso.CheckReturnURL(returnURL) != nil {
+		returnURL = ""
+	}
+	profile, ok := FromRequest(req)
+	if !ok {
+		var err error
+		if profile, err = l.loginProfile(ctx); err != nil {
+			l.log.Error(err, "login profile could not be loaded", "profile_name", l.holding)
+			http.Error(w, "the login could not be started", http.StatusInternalServerError)
+			return
+		}
+	}
+	pkce, err := l.sso.CreatePKCE(ctx, profile, returnURL, nil, l.scopes...)
+	if err != nil {
+		l.log.Error(err, "login could not be started", "profile_id", profile.GetID())
+		http.Error(w, "the login could not be started", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     l.cookie + stateCookieSuffix,
+		Value:    pkce.GetState().String(),
+		Path:     "/",
+		MaxAge:   int((5 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   l.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, req, l.sso.AuthUrl(pkce), http.StatusFound)
+}
+
+// loginProfile returns the login profile, creating it on first use.
+func (l *Login) loginProfile(ctx context.Context) (evesso.Profile, error) {
+	profile, err := l.store.FindProfile(ctx, l.holding)
+	if err == nil {
+		return profile, nil
+	}
+	if profile, err = l.store.NewProfile(ctx, l.holding, nil); err == nil {
+		return profile, nil
+	}
+	// another request may have created it first
+	return l.store.FindProfile(ctx, l.holding)
+}
+
+// callback completes the login the state cookie says this browser started,
+// so a victim cannot be logged in with someone else's callback URL.
+func (l *Login) callback(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	state, err := req.Cookie(l.cookie + stateCookieSuffix)
+	if err != nil || state.Value != req.FormValue("state") {
+		http.Error(w, "this login was not started in this browser, please start again", http.StatusBadRequest)
+		return
+	}
+	l.clearCookie(w, l.cookie+stateCookieSuffix)
+
+	auth, redirect, err := l.sso.CompleteRequest(req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var callbackErr *evesso.CallbackError
+		if errors.As(err, &callbackErr) {
+			status = callbackErr.Kind.Status()
+		}
+		l.log.V(1).Info("login did not complete", "status", status, "error", err.Error())
+		http.Error(w, "the login did not complete, please start again", status)
+		return
+	}
+	profile, character, err := l.resolve(ctx, auth)
+	if err != nil {
+		l.log.Error(err, "login could not be resolved", "character_id", auth.Claims.CharacterID())
+		http.Error(w, "the login could not be saved", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	session := Session{
+		ID:          uuid.New(),
+		ProfileID:   profile.GetID(),
+		CharacterID: character.GetID(),
+		Created:     now,
+		Expires:     now.Add(l.max),
+	}
+	if err = l.sessions.CreateSession(ctx, session); err != nil {
+		l.log.Error(err, "session could not be stored", "profile_id", profile.GetID())
+		http.Error(w, "the login could not be saved", http.StatusInternalServerError)
+		return
+	}
+	if err = l.issue(w, &session); err != nil {
+		l.log.Error(err, "session cookie could not be sealed", "profile_id", profile.GetID())
+		http.Error(w, "the login could not be saved", http.StatusInternalServerError)
+		return
+	}
+	l.log.V(1).Info("logged in",
+		"character_id", character.GetCharacterID(),
+		"profile_id", profile.GetID(),
+		"session_id", session.ID,
+	)
+	if redirect == "" {
+		redirect = "/"
+	}
+	http.Redirect(w, req, redirect, http.StatusFound)
+}
+
+// profileData is the data of the profiles weblogin creates, marking them as
+// logins.
+type profileData struct {
+	WebLogin bool `json:"weblogin"`
+}
+
+// owned reports whether weblogin created profile.
+func owned(profile evesso.Profile) bool {
+	data, err := json.Marshal(profile.GetData())
+	if err != nil {
+		return false
+	}
+	var d profileData
+	return json.Unmarshal(data, &d) == nil && d.WebLogin
+}
+
+// resolveLogin is the default Resolver. A login through the login profile is
+// a returning user if the character is already active in a profile weblogin
+// created, and is then that profile; the new record is dropped. Otherwise the
+// character moves to a new profile named after it. Logins through any other
+// profile stay where they are. Store errors fail the login rather than make
+// a new user of it.
+func (l *Login) resolveLogin(ctx context.Context, auth *evesso.Authorization) (evesso.Profile, evesso.Character, error) {
+	if auth.Profile.GetName() != l.holding {
+		return auth.Profile, auth.Character, nil
+	}
+	landed := auth.Character
+	// FindCharacter only finds active characters, so this hides the new record
+	if err := landed.UpdateActiveState(ctx, false); err != nil {
+		return nil, nil, err
+	}
+	profile, character, err := l.relocate(ctx, auth)
+	if err != nil {
+		// leave the record as the callback stored it
+		if activeErr := landed.UpdateActiveState(ctx, true); activeErr != nil {
+			l.log.Error(activeErr, "login character could not be reactivated", "character_id", auth.Claims.CharacterID())
+		}
+		return nil, nil, err
+	}
+	return profile, character, landed.Delete(ctx)
+}
+
+// relocate returns the profile a login through the login profile belongs to:
+// the returning user's, or a new one with a copy of the landed character.
+func (l *Login) relocate(ctx context.Context, auth *evesso.Authorization) (evesso.Profile, evesso.Character, error) {
+	profile, character, err := l.returning(ctx, auth.Claims)
+	if !errors.Is(err, evesso.ErrNotFound) {
+		return profile, character, err
+	}
+
+	token, err := auth.Character.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	name := auth.Claims.CharacterName()
+	profile, err = l.store.NewProfile(ctx, name, profileData{WebLogin: true})
+	if errors.Is(err, evesso.ErrConflict) {
+		// names are unique, and a sold character's name may be taken
+		profile, err = l.store.NewProfile(ctx, name+" "+uuid.NewString()[:8], profileData{WebLogin: true})
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	character, err = profile.CreateCharacter(ctx, auth.Claims, token, auth.Character.GetReferenceData())
+	if err != nil {
+		return nil, nil, err
+	}
+	return profile, character, nil
+}
+
+// returning finds the login profile the character is active in. Profiles
+// weblogin did not create, such as one a token service authorized the
+// character into, are never logged into.
+func (l *Login) returning(ctx context.Context, claims evesso.CharacterClaims) (evesso.Profile, evesso.Character, error) {
+	profile, character, err := l.store.FindCharacter(ctx, claims.CharacterID(), "", claims.Owner())
+	switch {
+	case err == nil && owned(profile):
+		return profile, character, nil
+	case err == nil:
+		return nil, nil, evesso.ErrNotFound
+	case !errors.Is(err, evesso.ErrAmbiguous):
+		return nil, nil, err
+	}
+	// active in several profiles: look through the logins only
+	profiles, err := l.store.AllProfiles(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	profile, character = nil, nil
+	for _, p := range profiles {
+		if !owned(p) {
+			continue
+		}
+		c, err := p.FindCharacter(ctx, claims.CharacterID(), "", claims.Owner(), nil)
+		if errors.Is(err, evesso.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if profile != nil {
+			return nil, nil, fmt.Errorf("%w: character %d is in more than one login profile", evesso.ErrAmbiguous, claims.CharacterID())
+		}
+		profile, character = p, c
+	}
+	if profile == nil {
+		return nil, nil, evesso.ErrNotFound
+	}
+	return profile, character, nil
+}
+
+// logout ends the session here and server side. ?return= is where to go
+// afterwards. It takes POST only: the Lax session cookie comes along on a
+// GET from any site, which could then log the user out.
+func (l *Login) logout(w http.ResponseWriter, req *http.Request) {
+	if session, ok := SessionFromRequest(req); ok {
+		if err := l.sessions.DeleteSession(req.Context(), session.ID); err != nil {
+			l.log.Error(err, "session could not be ended", "session_id", session.ID)
+			http.Error(w, "the logout failed", http.StatusInternalServerError)
+			return
+		}
+		l.log.V(1).Info("logged out", "profile_id", session.ProfileID, "session_id", session.ID)
+	}
+	l.clearCookie(w, l.cookie)
+	target := req.FormValue("return")
+	if target == "" || l.sso.CheckReturnURL(target) != nil {
+		target = "/"
+	}
+	http.Redirect(w, req, target, http.StatusSeeOther)
+}
+
+// Me is the /me response.
+type Me struct {
+	ProfileID     uuid.UUID `json:"profile_id"`
+	ProfileName   string    `json:"profile_name"`
+	CharacterID   int32     `json:"character_id"`
+	CharacterName string    `json:"character_name"`
+	Expires       time.Time `json:"session_expires_at"`
+}
+
+func (l *Login) me(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	id, ok := req.Context().Value(identityKey{}).(*identity)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "not logged in"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(Me{
+		ProfileID:     id.profile.GetID(),
+		ProfileName:   id.profile.GetName(),
+		CharacterID:   id.character.GetCharacterID(),
+		CharacterName: id.character.GetCharacterName(),
+		Expires:       id.session.Expires,
+	})
+}
@@ -0,0 +1,343 @@
+package weblogin_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+	"github.com/ferocious-space/evesso/pkg/weblogin"
+)
+
+var (
+	current = bytes.Repeat([]byte{1}, 32)
+	retired = bytes.Repeat([]byte{2}, 32)
+)
+
+type fixture struct {
+	srv   *evessotest.Server
+	sso   *evesso.EVESSO
+	store *evessotest.Store
+}
+
+func newFixture(t *testing.T, opts ...evesso.Option) *fixture {
+	t.Helper()
+	srv, sso, store := evessotest.NewSSO(t, opts...)
+	return &fixture{srv: srv, sso: sso, store: store}
+}
+
+func (f *fixture) login(t *testing.T, opts ...weblogin.Option) *weblogin.Login {
+	t.Helper()
+	l, err := weblogin.New(f.sso, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// serve sends a GET for target to h with cookies.
+func serve(h http.Handler, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	return send(h, http.MethodGet, target, cookies...)
+}
+
+// send sends a method request for target to h with cookies.
+func send(h http.Handler, method, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func cookie(t *testing.T, rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	t.Helper()
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatalf("no %s cookie set", name)
+	return nil
+}
+
+// signIn runs /login, SSO and /callback, and returns the session cookie.
+func (f *fixture) signIn(t *testing.T, l *weblogin.Login) *http.Cookie {
+	t.Helper()
+	rec := serve(l, "/login")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("/login = %d: %s", rec.Code, rec.Body)
+	}
+	state := cookie(t, rec, weblogin.DefaultCookieName+"_state")
+	callback, err := f.srv.Approve(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec = serve(l, callback, state); rec.Code != http.StatusFound {
+		t.Fatalf("/callback = %d: %s", rec.Code, rec.Body)
+	}
+	return cookie(t, rec, weblogin.DefaultCookieName)
+}
+
+func me(t *testing.T, l *weblogin.Login, session *http.Cookie) (int, weblogin.Me) {
+	t.Helper()
+	rec := serve(l, "/me", session)
+	var m weblogin.Me
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, m
+}
+
+func TestSessionCookie(t *testing.T) {
+	tests := []struct {
+		name string
+		// session signs in and returns the cookie /me is asked with; store
+		// holds the sessions of l
+		session func(t *testing.T, f *fixture, l *weblogin.Login, store weblogin.SessionStore) *http.Cookie
+		status  int
+		// resealed is whether /me sets a fresh session cookie
+		resealed bool
+	}{
+		{
+			name: "signed in",
+			session: func(t *testing.T, f *fixture, l *weblogin.Login, _ weblogin.SessionStore) *http.Cookie {
+				return f.signIn(t, l)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:    "no cookie",
+			session: func(*testing.T, *fixture, *weblogin.Login, weblogin.SessionStore) *http.Cookie { return nil },
+			status:  http.StatusUnauthorized,
+		},
+		{
+			name: "tampered",
+			session: func(t *testing.T, f *fixture, l *weblogin.Login, _ weblogin.SessionStore) *http.Cookie {
+				c := f.signIn(t, l)
+				v := []byte(c.Value)
+				v[len(v)/2] ^= 'A' ^ 'B' // a byte of the ciphertext, not of the key ID
+				c.Value = string(v)
+				return c
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "logged out",
+			session: func(t *testing.T, f *fixture, l *weblogin.Login, _ weblogin.SessionStore) *http.Cookie {
+				c := f.signIn(t, l)
+				if rec := send(l, http.MethodPost, "/logout", c); rec.Code != http.StatusSeeOther {
+					t.Fatalf("/logout = %d", rec.Code)
+				}
+				return c
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "logout by GET",
+			session: func(t *testing.T, f *fixture, l *weblogin.Login, _ weblogin.SessionStore) *http.Cookie {
+				c := f.signIn(t, l)
+				// a link on any site could do this
+				if rec := serve(l, "/logout", c); rec.Code != http.StatusMethodNotAllowed {
+					t.Fatalf("GET /logout = %d", rec.Code)
+				}
+				return c
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "ended everywhere",
+			session: func(t *testing.T, f *fixture, l *weblogin.Login, _ weblogin.SessionStore) *http.Cookie {
+				c := f.signIn(t, l)
+				_, m := me(t, l, c)
+				if err := l.EndSessions(context.Background(), m.ProfileID); err != nil {
+					t.Fatal(err)
+				}
+				return c
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "sealed with the previous key",
+			session: func(t *testing.T, f *fixture, _ *weblogin.Login, store weblogin.SessionStore) *http.Cookie {
+				return f.signIn(t, f.login(t, weblogin.WithKeys(retired), weblogin.WithSessionStore(store)))
+			},
+			status:   http.StatusOK,
+			resealed: true,
+		},
+		{
+			name: "sealed with an unknown key",
+			session: func(t *testing.T, f *fixture, _ *weblogin.Login, store weblogin.SessionStore) *http.Cookie {
+				return f.signIn(t, f.login(t, weblogin.WithKeys(bytes.Repeat([]byte{3}, 32)), weblogin.WithSessionStore(store)))
+			},
+			status: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			store := weblogin.NewMemorySessions()
+			l := f.login(t, weblogin.WithKeys(current, retired), weblogin.WithSessionStore(store))
+
+			var cookies []*http.Cookie
+			if c := tt.session(t, f, l, store); c != nil {
+				cookies = append(cookies, c)
+			}
+			rec := serve(l, "/me", cookies...)
+			if rec.Code != tt.status {
+				t.Fatalf("/me = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			var resealed bool
+			for _, c := range rec.Result().Cookies() {
+				resealed = resealed || (c.Name == weblogin.DefaultCookieName && c.MaxAge >= 0)
+			}
+			if resealed != tt.resealed {
+				t.Errorf("resealed = %v, want %v", resealed, tt.resealed)
+			}
+		})
+	}
+}
+
+func TestCallbackState(t *testing.T) {
+	tests := []struct {
+		name string
+		// state is the state cookie value, relative to the one /login set
+		state  func(string) string
+		status int
+	}{
+		{"same browser", func(s string) string { return s }, http.StatusFound},
+		{"no state cookie", nil, http.StatusBadRequest},
+		{"another login's state", func(string) string { return "00000000-0000-0000-0000-000000000000" }, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			l := f.login(t, weblogin.WithKeys(current))
+			rec := serve(l, "/login")
+			state := cookie(t, rec, weblogin.DefaultCookieName+"_state")
+			callback, err := f.srv.Approve(rec.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var cookies []*http.Cookie
+			if tt.state != nil {
+				state.Value = tt.state(state.Value)
+				cookies = append(cookies, state)
+			}
+			if rec = serve(l, callback, cookies...); rec.Code != tt.status {
+				t.Fatalf("/callback = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+// profileStore fails to create any profile but the login profile.
+type profileStore struct {
+	*evessotest.Store
+}
+
+func (s profileStore) NewProfile(ctx context.Context, name string, data any) (evesso.Profile, error) {
+	if name != weblogin.DefaultLoginProfile {
+		return nil, errors.New("disk full")
+	}
+	return s.Store.NewProfile(ctx, name, data)
+}
+
+func TestReturningUser(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		// before runs between the first and the second login
+		before func(t *testing.T, f *fixture)
+	}{
+		{name: "in the login profile only"},
+		{
+			// the store's FindCharacter is ambiguous, so each login
+			// profile is searched with no scopes
+			name: "also in another profile",
+			before: func(t *testing.T, f *fixture) {
+				tokens, err := f.store.NewProfile(ctx, "tokens", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				evessotest.Authorize(t, f.srv, f.sso, tokens, "esi-wallet.read_character_wallet.v1")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			l := f.login(t, weblogin.WithKeys(current))
+			status, first := me(t, l, f.signIn(t, l))
+			if status != http.StatusOK {
+				t.Fatalf("/me = %d", status)
+			}
+			if first.ProfileName != evessotest.Pilot.Name || first.CharacterName != evessotest.Pilot.Name {
+				t.Errorf("first login is %+v", first)
+			}
+			if tt.before != nil {
+				tt.before(t, f)
+			}
+			status, second := me(t, l, f.signIn(t, l))
+			if status != http.StatusOK {
+				t.Fatalf("/me = %d", status)
+			}
+			if second.ProfileID != first.ProfileID {
+				t.Errorf("second login landed in %s, want %s", second.ProfileID, first.ProfileID)
+			}
+
+			holding, err := f.store.FindProfile(ctx, weblogin.DefaultLoginProfile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			characters, err := holding.AllCharacters(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(characters) != 0 {
+				t.Errorf("%d characters left in the login profile", len(characters))
+			}
+		})
+	}
+}
+
+func TestFailedLoginKeepsCharacter(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t, evesso.WithStore(profileStore{evessotest.NewStore()}))
+	l := f.login(t, weblogin.WithKeys(current))
+	rec := serve(l, "/login")
+	state := cookie(t, rec, weblogin.DefaultCookieName+"_state")
+	callback, err := f.srv.Approve(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec = serve(l, callback, state); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("/callback = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+
+	// the character stays where the callback stored it, still active
+	holding, err := f.sso.Store().FindProfile(ctx, weblogin.DefaultLoginProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = holding.FindCharacter(ctx, evessotest.Pilot.ID, "", "", nil); err != nil {
+		t.Errorf("login character: %v", err)
+	}
+}
+
+func TestNewCallbackPath(t *testing.T) {
+	cfg := evessotest.Config()
+	cfg.Callback = "http://localhost/sso"
+	f := newFixture(t, evesso.WithConfig(cfg))
+	if _, err := weblogin.New(f.sso, weblogin.WithKeys(current)); err == nil {
+		t.Fatal("no error for a callback not ending in /callback")
+	}
+}
@@ -0,0 +1,87 @@
+// Package webstore declares what weblogin and oidc keep in a store: login
+// sessions, and OpenID Connect clients and codes. It depends on nothing but
+// uuid, so a DataStore can implement its interfaces without pulling in the
+// web packages; weblogin and oidc refer to these types by alias.
+package webstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a login. The cookie carries it sealed; a SessionStore decides
+// whether it is still valid.
+type Session struct {
+	ID          uuid.UUID `json:"sid"`
+	ProfileID   uuid.UUID `json:"pid"`
+	CharacterID uuid.UUID `json:"cid"`
+	Created     time.Time `json:"iat"`
+	// Expires is the absolute end of the session, however active it is.
+	Expires time.Time `json:"exp"`
+	// Issued is when the cookie was last sealed; the session ends once it
+	// has been idle for the idle timeout since.
+	Issued time.Time `json:"iss"`
+}
+
+// SessionStore keeps sessions server side, so they can be ended before their
+// cookies expire. Implementations may forget sessions once they expire.
+type SessionStore interface {
+	CreateSession(ctx context.Context, session Session) error
+	// SessionActive reports whether the session exists and has not expired.
+	SessionActive(ctx context.Context, sessionID uuid.UUID) (bool, error)
+	DeleteSession(ctx context.Context, sessionID uuid.UUID) error
+	// DeleteProfileSessions ends every session of a profile, for "log out
+	// everywhere" or after removing a user.
+	DeleteProfileSessions(ctx context.Context, profileID uuid.UUID) error
+}
+
+// Client is a registered OpenID Connect client. A client without a secret is
+// public, such as a single-page or native app, and must use PKCE.
+type Client struct {
+	ID   string `json:"client_id"`
+	Name string `json:"client_name"`
+	// SecretHash is the SHA-256 of the client secret; the secret itself is
+	// only ever shown once, when the client is registered.
+	SecretHash   []byte    `json:"-"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Created      time.Time `json:"created_at"`
+}
+
+// Public reports whether the client has no secret.
+func (c Client) Public() bool {
+	return len(c.SecretHash) == 0
+}
+
+// Code is an authorization code waiting to be redeemed at the token endpoint.
+type Code struct {
+	// Hash is the SHA-256 of the code; the code itself is not stored.
+	Hash        []byte
+	ClientID    string
+	RedirectURI string
+	ProfileID   uuid.UUID
+	CharacterID uuid.UUID
+	Scopes      []string
+	Nonce       string
+	// Challenge is the S256 PKCE code challenge, if the client sent one.
+	Challenge string
+	// AuthTime is when the user logged in upstream.
+	AuthTime time.Time
+	Expires  time.Time
+}
+
+// OIDCStore keeps OpenID Connect clients and authorization codes.
+type OIDCStore interface {
+	CreateClient(ctx context.Context, client Client) error
+	// GetClient reports found=false for an unknown client rather than an
+	// error, so a store outage is not mistaken for bad credentials.
+	GetClient(ctx context.Context, clientID string) (client Client, found bool, err error)
+	AllClients(ctx context.Context) ([]Client, error)
+	DeleteClient(ctx context.Context, clientID string) error
+
+	CreateCode(ctx context.Context, code Code) error
+	// TakeCode returns the code and deletes it, so that it is redeemed at
+	// most once. Implementations may forget codes once they expire.
+	TakeCode(ctx context.Context, hash []byte) (code Code, found bool, err error)
+}
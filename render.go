@@ -0,0 +1,193 @@
+package evesso
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+// OutcomeKind is how a callback ended.
+type OutcomeKind int
+
+const (
+	// OutcomeSuccess means the character was verified and persisted.
+	OutcomeSuccess OutcomeKind = iota
+	// OutcomeExpired means the PKCE row was older than 5 minutes.
+	OutcomeExpired
+	// OutcomeUnknownState means the state matched no PKCE row.
+	OutcomeUnknownState
+	// OutcomeExchangeFailed means SSO did not exchange the code for a token.
+	OutcomeExchangeFailed
+	// OutcomeVerificationFailed means the token failed JWKS verification or
+	// lacked identity claims.
+	OutcomeVerificationFailed
+	// OutcomeStorageFailed means the DataStore failed.
+	OutcomeStorageFailed
+)
+
+func (k OutcomeKind) String() string {
+	switch k {
+	case OutcomeSuccess:
+		return "success"
+	case OutcomeExpired:
+		return "expired"
+	case OutcomeUnknownState:
+		return "unknown state"
+	case OutcomeExchangeFailed:
+		return "exchange failed"
+	case OutcomeVerificationFailed:
+		return "verification failed"
+	case OutcomeStorageFailed:
+		return "storage failed"
+	default:
+		return "unknown"
+	}
+}
+
+// Status is the HTTP status code a page for k should carry.
+func (k OutcomeKind) Status() int {
+	switch k {
+	case OutcomeSuccess:
+		return http.StatusOK
+	case OutcomeExpired, OutcomeUnknownState:
+		return http.StatusBadRequest
+	case OutcomeExchangeFailed, OutcomeVerificationFailed:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Outcome is the result of a callback. Profile and PKCE are set once the state
+// resolved, Character and Claims only on success. Err is for logs, not for
+// the browser.
+type Outcome struct {
+	Kind      OutcomeKind
+	Profile   Profile
+	Character Character
+	Claims    CharacterClaims
+	PKCE      PKCE
+	Err       error
+}
+
+// Authorization is what a successful callback authorized.
+type Authorization struct {
+	Profile   Profile
+	Character Character
+	// Claims are the verified identity the character was created from.
+	Claims CharacterClaims
+}
+
+// CallbackError is a callback that did not end in OutcomeSuccess.
+type CallbackError struct {
+	Kind OutcomeKind
+	Err  error
+}
+
+func (e *CallbackError) Error() string {
+	return fmt.Sprintf("callback %s: %s", e.Kind, e.Err)
+}
+
+func (e *CallbackError) Unwrap() error {
+	return e.Err
+}
+
+// authorization returns the Authorization of a successful outcome, or a
+// *CallbackError.
+func (o *Outcome) authorization() (*Authorization, error) {
+	if o.Kind != OutcomeSuccess {
+		return nil, &CallbackError{Kind: o.Kind, Err: o.Err}
+	}
+	return &Authorization{Profile: o.Profile, Character: o.Character, Claims: o.Claims}, nil
+}
+
+func (o *Outcome) fail(kind OutcomeKind, err error) *Outcome {
+	o.Kind = kind
+	o.Err = err
+	return o
+}
+
+// Renderer writes the page a browser sees at the end of a callback.
+type Renderer interface {
+	Render(w http.ResponseWriter, req *http.Request, outcome *Outcome)
+}
+
+// RendererFunc adapts a function to Renderer.
+type RendererFunc func(w http.ResponseWriter, req *http.Request, outcome *Outcome)
+
+func (f RendererFunc) Render(w http.ResponseWriter, req *http.Request, outcome *Outcome) {
+	f(w, req, outcome)
+}
+
+// BatchRenderer is implemented by a Renderer that also writes the page
+// LocalhostBatchAuth ends on, with every outcome of the batch in arrival
+// order. Any other Renderer gets the last outcome there.
+type BatchRenderer interface {
+	RenderBatch(w http.ResponseWriter, req *http.Request, outcomes []*Outcome)
+}
+
+// DefaultRenderer writes a minimal HTML page with the outcome's status code.
+// It names the character on success and never shows errors or tokens. It is
+// a BatchRenderer, counting the characters a batch authorized.
+var DefaultRenderer Renderer = defaultRenderer{}
+
+type defaultRenderer struct{}
+
+func (defaultRenderer) Render(w http.ResponseWriter, req *http.Request, outcome *Outcome) {
+	renderDefault(w, req, outcome)
+}
+
+func (defaultRenderer) RenderBatch(w http.ResponseWriter, req *http.Request, outcomes []*Outcome) {
+	renderBatchDefault(w, req, outcomes)
+}
+
+var defaultPage = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body style="font-family:sans-serif;max-width:32em;margin:4em auto">
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+</body>
+</html>
+`))
+
+func renderDefault(w http.ResponseWriter, _ *http.Request, outcome *Outcome) {
+	page := struct{ Title, Message string }{}
+	switch outcome.Kind {
+	case OutcomeSuccess:
+		page.Title = "Authorization complete"
+		page.Message = outcome.Claims.CharacterName() + " is authorized. You can close this window."
+	case OutcomeExpired:
+		page.Title = "Authorization expired"
+		page.Message = "The login took too long. Please start again."
+	case OutcomeUnknownState:
+		page.Title = "Unknown authorization"
+		page.Message = "This login was not started here, or it was already used. Please start again."
+	case OutcomeExchangeFailed, OutcomeVerificationFailed:
+		page.Title = "Authorization failed"
+		page.Message = "EVE SSO did not complete the login. Please try again later."
+	default:
+		page.Title = "Authorization failed"
+		page.Message = "The login could not be saved. Please try again later."
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(outcome.Kind.Status())
+	_ = defaultPage.Execute(w, page)
+}
+
+func renderBatchDefault(w http.ResponseWriter, _ *http.Request, outcomes []*Outcome) {
+	authorized := 0
+	for _, outcome := range outcomes {
+		if outcome.Kind == OutcomeSuccess {
+			authorized++
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = defaultPage.Execute(w, struct{ Title, Message string }{
+		Title:   "Authorization finished",
+		Message: strconv.Itoa(authorized) + " character(s) authorized. You can close this window.",
+	})
+}
@@ -0,0 +1,49 @@
+package evesso
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var ErrReturnURL = errors.New("return URL not allowed")
+
+// CheckReturnURL reports whether raw may be used as a per-authorization return
+// URL. Relative paths on the callback host are always allowed; absolute URLs
+// must be http or https on one of the configured return hosts. Placeholders
+// are checked as if substituted.
+func (r *EVESSO) CheckReturnURL(raw string) error {
+	_, err := r.expandReturnURL(raw, 1, "00000000-0000-0000-0000-000000000000")
+	return err
+}
+
+// expandReturnURL substitutes {character_id} and {profile_id} into raw and
+// checks the result against the return hosts.
+func (r *EVESSO) expandReturnURL(raw string, characterID int32, profileID string) (string, error) {
+	expanded := strings.NewReplacer(
+		"{character_id}", strconv.FormatInt(int64(characterID), 10),
+		"{profile_id}", profileID,
+	).Replace(raw)
+	u, err := url.Parse(expanded)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrReturnURL, err)
+	}
+	if !u.IsAbs() && u.Host == "" {
+		// "//host" and "/\host" are read as another host by browsers
+		if !strings.HasPrefix(expanded, "/") || strings.HasPrefix(expanded, "//") || strings.HasPrefix(expanded, "/\\") {
+			return "", fmt.Errorf("%w: %q is not an absolute path", ErrReturnURL, raw)
+		}
+		return u.String(), nil
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%w: scheme %q", ErrReturnURL, u.Scheme)
+	}
+	for _, host := range r.cfg.ReturnHosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return u.String(), nil
+		}
+	}
+	return "", fmt.Errorf("%w: host %q is not a return host", ErrReturnURL, u.Host)
+}
@@ -0,0 +1,45 @@
+package evesso_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+func TestCheckReturnURL(t *testing.T) {
+	_, sso, _ := evessotest.NewSSO(t)
+	tests := []struct {
+		raw string
+		ok  bool
+	}{
+		{"/done", true},
+		{"/characters/{character_id}?profile={profile_id}", true},
+		{"http://localhost/done", true},
+		{"https://LOCALHOST:8443/done", true},
+		{"done", false},
+		{"https://evil.example.com/", false},
+		{"https://localhost.evil.example.com/", false},
+		{"https://localhost@evil.example.com/", false},
+		{"//evil.example.com/", false},
+		{"/\\evil.example.com/", false},
+		{"\\\\evil.example.com/", false},
+		{"/\t/evil.example.com/", false},
+		{"javascript:alert(1)", false},
+		{"ftp://localhost/", false},
+		{"https://{character_id}.example.com/", false},
+		{"http://[::1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			err := sso.CheckReturnURL(tt.raw)
+			if tt.ok && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.ok && !errors.Is(err, evesso.ErrReturnURL) {
+				t.Fatalf("err = %v, want ErrReturnURL", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package evesso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrNoRevoker            = errors.New("no revoker configured")
+	ErrNoRevocationEndpoint = errors.New("SSO metadata has no revocation endpoint")
+)
+
+// Revoker revokes refresh tokens at SSO. EVESSO is one.
+type Revoker interface {
+	RevokeToken(ctx context.Context, refreshToken string) error
+}
+
+// RevokerSetter is implemented by a DataStore that can revoke tokens itself,
+// for Character.Revoke and WithRevocation. New hands it the EVESSO.
+type RevokerSetter interface {
+	SetRevoker(revoker Revoker)
+}
+
+// DeleteOptions is what a DataStore's delete methods were asked to do besides
+// deleting. Build it with NewDeleteOptions.
+type DeleteOptions struct {
+	Revoke bool
+}
+
+type DeleteOption func(*DeleteOptions)
+
+// WithRevocation revokes the refresh tokens of the deleted characters at SSO
+// before they are deleted locally. A revocation failure does not stop the
+// deletion; it is returned as a *RevocationError once the deletion succeeds.
+func WithRevocation() DeleteOption {
+	return func(o *DeleteOptions) {
+		o.Revoke = true
+	}
+}
+
+func NewDeleteOptions(opts ...DeleteOption) DeleteOptions {
+	var o DeleteOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// RevocationError lists the characters whose refresh tokens could not be
+// revoked. Whatever deletion it is returned from still happened.
+type RevocationError struct {
+	Errors []error
+}
+
+func (e *RevocationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return "revocation failed: " + strings.Join(msgs, "; ")
+}
+
+func (e *RevocationError) Unwrap() []error {
+	return e.Errors
+}
+
+// Add records that revoking character's token failed with err.
+func (e *RevocationError) Add(character Character, err error) {
+	e.Errors = append(e.Errors, fmt.Errorf("%s (%d): %w", character.GetCharacterName(), character.GetCharacterID(), err))
+}
+
+// Err returns e, or nil if nothing was added.
+func (e *RevocationError) Err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// RevokeToken revokes refreshToken at the discovered RevocationEndpoint,
+// authenticating the way the endpoint advertises.
+func (r *EVESSO) RevokeToken(ctx context.Context, refreshToken string) error {
+	if r.RevocationEndpoint == "" {
+		return ErrNoRevocationEndpoint
+	}
+	form := url.Values{
+		"token":           {refreshToken},
+		"token_type_hint": {"refresh_token"},
+	}
+	basic := len(r.RevocationEndpointAuthMethodsSupported) == 0 ||
+		slices.Contains(r.RevocationEndpointAuthMethodsSupported, "client_secret_basic")
+	if !basic {
+		form.Set("client_id", r.cfg.Key)
+		form.Set("client_secret", r.cfg.Secret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.RevocationEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(r.cfg.Key), url.QueryEscape(r.cfg.Secret))
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("revoke: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
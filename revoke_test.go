@@ -0,0 +1,127 @@
+package evesso_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+func TestRevocation(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		// revoke revokes or deletes what profile holds
+		revoke func(sso *evesso.EVESSO, profile evesso.Profile, character evesso.Character) error
+		// failRevoke makes SSO's revocation endpoint fail
+		failRevoke bool
+		revoked    bool
+		deleted    bool
+		wantErr    bool
+	}{
+		{
+			name:    "revoke a character",
+			revoke:  func(_ *evesso.EVESSO, _ evesso.Profile, c evesso.Character) error { return c.Revoke(ctx) },
+			revoked: true,
+		},
+		{
+			name:    "revoke a profile",
+			revoke:  func(_ *evesso.EVESSO, p evesso.Profile, _ evesso.Character) error { return p.RevokeAll(ctx) },
+			revoked: true,
+		},
+		{
+			name:    "delete a character",
+			revoke:  func(_ *evesso.EVESSO, _ evesso.Profile, c evesso.Character) error { return c.Delete(ctx) },
+			deleted: true,
+		},
+		{
+			name: "delete a character with revocation",
+			revoke: func(_ *evesso.EVESSO, _ evesso.Profile, c evesso.Character) error {
+				return c.Delete(ctx, evesso.WithRevocation())
+			},
+			revoked: true,
+			deleted: true,
+		},
+		{
+			name: "delete a profile with revocation",
+			revoke: func(sso *evesso.EVESSO, p evesso.Profile, _ evesso.Character) error {
+				return sso.DeleteProfile(ctx, p, evesso.WithRevocation())
+			},
+			revoked: true,
+			deleted: true,
+		},
+		{
+			name:       "revoke failing at SSO",
+			revoke:     func(_ *evesso.EVESSO, _ evesso.Profile, c evesso.Character) error { return c.Revoke(ctx) },
+			failRevoke: true,
+			wantErr:    true,
+		},
+		{
+			name: "delete with revocation failing at SSO",
+			revoke: func(sso *evesso.EVESSO, p evesso.Profile, _ evesso.Character) error {
+				return sso.DeleteProfile(ctx, p, evesso.WithRevocation())
+			},
+			failRevoke: true,
+			deleted:    true,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, sso, store := evessotest.NewSSO(t)
+			profile, err := store.NewProfile(ctx, "default", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			character := evessotest.Authorize(t, srv, sso, profile, "publicData").Character
+			token, err := character.Token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.failRevoke {
+				srv.FailNext(evessotest.EndpointRevoke, 1, http.StatusServiceUnavailable)
+			}
+
+			err = tt.revoke(sso, profile, character)
+			var revokeErr *evesso.RevocationError
+			switch {
+			case !tt.wantErr && err != nil:
+				t.Fatal(err)
+			case tt.wantErr && err == nil:
+				t.Fatal("no error")
+			case tt.wantErr && tt.deleted && !errors.As(err, &revokeErr):
+				t.Fatalf("err = %v, want a *RevocationError", err)
+			}
+			if got := srv.Revoked(token.RefreshToken); got != tt.revoked {
+				t.Errorf("revoked at SSO = %t, want %t", got, tt.revoked)
+			}
+			characters, err := profile.AllCharacters(ctx)
+			if err != nil && !errors.Is(err, evesso.ErrNotFound) {
+				t.Fatal(err)
+			}
+			if deleted := len(characters) == 0; deleted != tt.deleted {
+				t.Errorf("deleted locally = %t, want %t", deleted, tt.deleted)
+			}
+		})
+	}
+}
+
+func TestRevokeWithoutRevoker(t *testing.T) {
+	ctx := context.Background()
+	srv, sso, store := evessotest.NewSSO(t)
+	profile, err := store.NewProfile(ctx, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	character := evessotest.Authorize(t, srv, sso, profile, "publicData").Character
+	store.SetRevoker(nil)
+	if err = character.Revoke(ctx); !errors.Is(err, evesso.ErrNoRevoker) {
+		t.Fatalf("err = %v, want ErrNoRevoker", err)
+	}
+	if err = character.Delete(ctx, evesso.WithRevocation()); !errors.Is(err, evesso.ErrNoRevoker) {
+		t.Fatalf("err = %v, want ErrNoRevoker", err)
+	}
+}
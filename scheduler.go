@@ -0,0 +1,141 @@
+package evesso
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// CharacterPruner is implemented by a DataStore that can delete characters
+// which have been inactive since before a cutoff. It returns what it deleted.
+type CharacterPruner interface {
+	PruneCharacters(ctx context.Context, inactiveSince time.Time) ([]Character, error)
+}
+
+// CausePrune is a deletion by the inactive-character pruning job.
+const CausePrune Cause = "prune"
+
+type scheduler struct {
+	sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// Start runs the background jobs until ctx is done or Close is called:
+// PKCE cleanup, inactive-character pruning if configured and supported by the
+// store, JWKS freshness checks and SSO re-discovery. A job with a zero
+// interval does not run.
+func (r *EVESSO) Start(ctx context.Context) error {
+	r.jobs.Lock()
+	defer r.jobs.Unlock()
+	if r.jobs.closed {
+		return errors.New("evesso: closed")
+	}
+	if r.jobs.cancel != nil {
+		return errors.New("evesso: already started")
+	}
+	ctx, r.jobs.cancel = context.WithCancel(ctx)
+
+	r.every(ctx, r.intervals.pkceCleanup, func(ctx context.Context) {
+		if err := r.store.CleanPKCE(ctx); err != nil {
+			r.log.Error(err, "pkce cleanup failed")
+		}
+	})
+	if pruner, ok := r.store.(CharacterPruner); ok && r.intervals.pruneAfter > 0 {
+		r.every(ctx, r.intervals.prune, func(ctx context.Context) {
+			r.prune(ctx, pruner)
+		})
+	}
+	r.every(ctx, r.intervals.jwksCheck, r.checkJWKS)
+	if r.intervals.discovery > 0 {
+		r.every(ctx, min(r.intervals.discovery, time.Minute), r.checkDiscovery)
+	}
+	return nil
+}
+
+// every runs job each interval on its own goroutine until ctx is done.
+func (r *EVESSO) every(ctx context.Context, interval time.Duration, job func(ctx context.Context)) {
+	if interval <= 0 {
+		return
+	}
+	r.jobs.wg.Add(1)
+	go func() {
+		defer r.jobs.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				job(ctx)
+			}
+		}
+	}()
+}
+
+func (r *EVESSO) prune(ctx context.Context, pruner CharacterPruner) {
+	pruned, err := pruner.PruneCharacters(ctx, time.Now().Add(-r.intervals.pruneAfter))
+	if err != nil {
+		r.log.Error(err, "inactive character pruning failed")
+		return
+	}
+	for _, c := range pruned {
+		r.log.V(logEvents).Info("inactive character pruned", characterFields(c)...)
+		r.emit(ctx, eventDeleted, Event{Character: c, Cause: CausePrune})
+	}
+}
+
+// checkJWKS forces a refetch when the cached JWKS is older than twice the
+// refresh interval, meaning the cache's own refreshes have been failing.
+func (r *EVESSO) checkJWKS(ctx context.Context) {
+	fetched, _ := r.jwks.last()
+	if time.Since(fetched) < 2*r.intervals.jwks {
+		return
+	}
+	ks, err := r.refresher.Refresh(ctx, r.JwksURI)
+	if err != nil {
+		r.log.Error(err, "jwks refresh failed", "last_fetched", fetched)
+		return
+	}
+	r.keepJWKS(ctx, ks)
+}
+
+func (r *EVESSO) closed() bool {
+	r.jobs.Lock()
+	defer r.jobs.Unlock()
+	return r.jobs.closed
+}
+
+// Close shuts down in order: the background jobs, waiting for any that are
+// running, then the JWKS cache, then the store if it is an io.Closer. The
+// EVESSO cannot be used afterwards.
+func (r *EVESSO) Close() error {
+	r.jobs.Lock()
+	if r.jobs.closed {
+		r.jobs.Unlock()
+		return nil
+	}
+	r.jobs.closed = true
+	if r.jobs.cancel != nil {
+		r.jobs.cancel()
+	}
+	r.jobs.Unlock()
+	r.jobs.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var errs []error
+	if err := r.refresher.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if closer, ok := r.store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
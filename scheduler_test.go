@@ -0,0 +1,127 @@
+package evesso_test
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+// jobStore records the scheduler's calls into the store in order.
+type jobStore struct {
+	*evessotest.Store
+	mu    sync.Mutex
+	calls []string
+	// cleaning receives once the first PKCE cleanup is running, which then
+	// waits for release
+	cleaning chan struct{}
+	release  chan struct{}
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{Store: evessotest.NewStore(), cleaning: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (s *jobStore) record(call string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+func (s *jobStore) recorded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.calls)
+}
+
+func (s *jobStore) CleanPKCE(ctx context.Context) error {
+	s.record("clean")
+	select {
+	case s.cleaning <- struct{}{}:
+		<-s.release
+		s.record("clean done")
+	default:
+	}
+	return s.Store.CleanPKCE(ctx)
+}
+
+func (s *jobStore) PruneCharacters(context.Context, time.Time) ([]evesso.Character, error) {
+	s.record("prune")
+	return nil, nil
+}
+
+func (s *jobStore) Close() error {
+	s.record("close")
+	return nil
+}
+
+func TestStartClose(t *testing.T) {
+	store := newJobStore()
+	_, sso, _ := evessotest.NewSSO(t,
+		evesso.WithStore(store),
+		evesso.WithPKCECleanupInterval(10*time.Millisecond),
+		evesso.WithPruneInactive(time.Hour, 10*time.Millisecond),
+	)
+	if err := sso.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sso.Start(context.Background()); err == nil {
+		t.Error("started twice")
+	}
+
+	select {
+	case <-store.cleaning:
+	case <-time.After(5 * time.Second):
+		t.Fatal("PKCE cleanup never ran")
+	}
+	closed := make(chan error, 1)
+	go func() { closed <- sso.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("Close returned while a job was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(store.release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+
+	calls := store.recorded()
+	done := slices.Index(calls, "clean done")
+	if done < 0 || calls[len(calls)-1] != "close" || slices.Index(calls, "close") < done {
+		t.Errorf("calls = %q, want the store closed last, after the running job", calls)
+	}
+	if !slices.Contains(calls, "prune") {
+		// pruning runs on its own ticker, independently of the blocked cleanup
+		t.Errorf("calls = %q, pruning never ran", calls)
+	}
+	if err := sso.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if err := sso.Start(context.Background()); err == nil {
+		t.Error("started after Close")
+	}
+	if n := len(store.recorded()); n != len(calls) {
+		t.Errorf("%d calls after Close", n-len(calls))
+	}
+}
+
+func TestStartWithoutIntervals(t *testing.T) {
+	store := newJobStore()
+	_, sso, _ := evessotest.NewSSO(t, evesso.WithStore(store), evesso.WithPKCECleanupInterval(0))
+	if err := sso.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := sso.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// no pruning without WithPruneInactive, no cleanup with a zero interval
+	if calls := store.recorded(); !slices.Equal(calls, []string{"close"}) {
+		t.Errorf("calls = %q", calls)
+	}
+}
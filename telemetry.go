@@ -0,0 +1,116 @@
+package evesso
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/oauth2"
+)
+
+// instrumentationName names the tracer and meter of this package.
+const instrumentationName = "github.com/ferocious-space/evesso"
+
+// TracerSetter is implemented by a DataStore that traces its own calls. New
+// hands it the provider given to WithTracerProvider.
+type TracerSetter interface {
+	SetTracerProvider(provider trace.TracerProvider)
+}
+
+// refresh outcomes, the "outcome" attribute of evesso.refresh.duration
+const (
+	refreshOK       = "ok"
+	refreshRejected = "rejected"
+	refreshFailed   = "error"
+)
+
+// telemetry holds the tracer and instruments. Without providers they are the
+// OpenTelemetry no-op implementations.
+type telemetry struct {
+	tracer trace.Tracer
+
+	refreshDuration metric.Float64Histogram
+	deactivations   metric.Int64Counter
+	pkceCreated     metric.Int64Counter
+	pkceExpired     metric.Int64Counter
+	jwksErrors      metric.Int64Counter
+	tokenTTL        metric.Float64Histogram
+}
+
+func newTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) (*telemetry, error) {
+	if tp == nil {
+		tp = tracenoop.NewTracerProvider()
+	}
+	if mp == nil {
+		mp = metricnoop.NewMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+	t := &telemetry{tracer: tp.Tracer(instrumentationName)}
+	var err error
+	if t.refreshDuration, err = meter.Float64Histogram(
+		"evesso.refresh.duration",
+		metric.WithDescription("Duration of access token refreshes, by outcome."),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, err
+	}
+	if t.deactivations, err = meter.Int64Counter(
+		"evesso.character.deactivations",
+		metric.WithDescription("Characters marked inactive because SSO rejected their refresh token."),
+	); err != nil {
+		return nil, err
+	}
+	if t.pkceCreated, err = meter.Int64Counter(
+		"evesso.pkce.created",
+		metric.WithDescription("Authorizations started through CreatePKCE or a token source."),
+	); err != nil {
+		return nil, err
+	}
+	if t.pkceExpired, err = meter.Int64Counter(
+		"evesso.pkce.expired",
+		metric.WithDescription("Callbacks that arrived after their authorization expired."),
+	); err != nil {
+		return nil, err
+	}
+	if t.jwksErrors, err = meter.Int64Counter(
+		"evesso.jwks.errors",
+		metric.WithDescription("Failed JWKS fetches."),
+	); err != nil {
+		return nil, err
+	}
+	if t.tokenTTL, err = meter.Float64Histogram(
+		"evesso.token.ttl",
+		metric.WithDescription("Time to expiry of access tokens when they are obtained."),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// start starts a span named name under ctx.
+func (t *telemetry) start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// end ends span, marking it failed if err is not nil.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// obtained records the time to expiry of a freshly obtained access token.
+func (t *telemetry) obtained(ctx context.Context, token *oauth2.Token) {
+	if token.Expiry.IsZero() {
+		return
+	}
+	t.tokenTTL.Record(ctx, time.Until(token.Expiry).Seconds())
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/lestrrat-go/jwx/v3/jwk"
 	"github.com/lestrrat-go/jwx/v3/jwt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	"golang.org/x/oauth2"
 )
 
@@ -20,10 +22,12 @@ type ssoTokenSource struct {
 	token *oauth2.Token
 
 	ctx         context.Context
-	jwkfn       func() (jwk.Set, error)
+	jwkfn       func(ctx context.Context, accessToken string) (jwk.Set, error)
+	issuers     []string
 	oauthConfig *oauth2.Config
 
 	store DataStore
+	sso   *EVESSO
 
 	character     Character
 	profileID     uuid.UUID
@@ -42,10 +46,10 @@ func (o *ssoTokenSource) GetCharacter() (Character, error) {
 	return character, nil
 }
 
-// validateAccessToken checks an SSO access token's signature against ks and its
-// issuer and audience claims. It is the only place a token earns enough trust to
+// validateAccessToken checks an SSO access token's signature against ks, its
+// issuer against issuers and its audience claims. It is the only place a token earns enough trust to
 // have identity read out of it; see newCharacterClaims.
-func validateAccessToken(ks jwk.Set, clientID, accessToken string) (jwt.Token, error) {
+func validateAccessToken(ks jwk.Set, issuers []string, clientID, accessToken string) (jwt.Token, error) {
 	return jwt.ParseString(
 		accessToken,
 		jwt.WithKeySet(ks),
@@ -54,7 +58,7 @@ func validateAccessToken(ks jwk.Set, clientID, accessToken string) (jwt.Token, e
 			if !ok {
 				return fmt.Errorf("jwt: missing issuer claim")
 			}
-			for _, validIssuer := range VALID_ISSUERS {
+			for _, validIssuer := range issuers {
 				if iss == validIssuer {
 					return nil
 				}
@@ -105,12 +109,14 @@ func newCharacterClaims(jt jwt.Token) (CharacterClaims, error) {
 	return claims, nil
 }
 
-func (o *ssoTokenSource) validate(token *oauth2.Token) (jwt.Token, error) {
-	ks, err := o.jwkfn()
+func (o *ssoTokenSource) validate(ctx context.Context, token *oauth2.Token) (_ jwt.Token, err error) {
+	ctx, span := o.sso.telemetry.start(ctx, "evesso.validate")
+	defer func() { end(span, err) }()
+	ks, err := o.jwkfn(ctx, token.AccessToken)
 	if err != nil {
 		return nil, err
 	}
-	return validateAccessToken(ks, o.oauthConfig.ClientID, token.AccessToken)
+	return validateAccessToken(ks, o.issuers, o.oauthConfig.ClientID, token.AccessToken)
 }
 
 func (o *ssoTokenSource) Token() (*oauth2.Token, error) {
@@ -120,52 +126,107 @@ func (o *ssoTokenSource) Token() (*oauth2.Token, error) {
 		if o.character == nil {
 			character, err := o.GetCharacter()
 			if err != nil {
+				o.sso.logger(o.ctx).V(logEvents).Info("no usable character", "profile_id", o.profileID, "error_class", errorClass(err), "error", err.Error())
 				return nil, err
 			}
 			o.character = character
 		}
+		if remote, ok := o.character.(RemoteRefresher); ok {
+			return o.refreshRemote(remote)
+		}
 		token, err := o.character.Token()
 		if err != nil {
 			return nil, err
 		}
 		o.token = token
 	}
+	if o.token.Valid() {
+		return o.token, nil
+	}
+	return o.refresh()
+}
+
+// refresh gets a new access token with the refresh token, and deactivates
+// the character if SSO rejects it. A RemoteRefresher is asked instead.
+func (o *ssoTokenSource) refresh() (_ *oauth2.Token, err error) {
+	if remote, ok := o.character.(RemoteRefresher); ok {
+		return o.refreshRemote(remote)
+	}
+	ctx, span := o.sso.telemetry.start(o.ctx, "evesso.refresh", attribute.Int("evesso.character_id", int(o.character.GetCharacterID())))
+	log := o.sso.logger(ctx).WithValues(characterFields(o.character)...)
+	started := time.Now()
+	outcome := refreshOK
+	defer func() {
+		if err != nil && outcome == refreshOK {
+			outcome = refreshFailed
+		}
+		if err != nil && outcome != refreshRejected {
+			log.Error(err, "token refresh failed", "error_class", errorClass(err))
+		}
+		o.sso.telemetry.refreshDuration.Record(ctx, time.Since(started).Seconds(), metric.WithAttributes(attribute.String("outcome", outcome)))
+		end(span, err)
+	}()
 	// get token from refresh token or refresh existing access token
-	l, err := o.oauthConfig.TokenSource(o.ctx, o.token).Token()
+	l, err := o.oauthConfig.TokenSource(ctx, o.token).Token()
 	if err != nil {
 		var retrieveError *oauth2.RetrieveError
 		if errors.As(err, &retrieveError) {
+			outcome = refreshRejected
 			// mark character as inactive
-			terr := o.character.UpdateActiveState(o.ctx, false)
+			terr := o.character.UpdateActiveState(ctx, false)
 			if terr != nil {
 				return nil, fmt.Errorf("%s: %w", terr, err)
 			}
+			o.sso.telemetry.deactivations.Add(ctx, 1)
+			log.Info("character deactivated", "error_class", errorClass(err), "sso_error", retrieveError.ErrorCode, "error", err.Error())
+			o.sso.emit(ctx, eventDeactivated, Event{Character: o.character, Cause: CauseRefresh, Err: err})
 			return nil, err
 		}
 		return nil, err
 	}
 	// check if refresh token changed
 	if o.token.RefreshToken != l.RefreshToken {
-		err = o.character.UpdateRefreshToken(o.ctx, l.RefreshToken)
+		err = o.character.UpdateRefreshToken(ctx, l.RefreshToken)
 		if err != nil {
 			return nil, err
 		}
 	}
 	// verify token if changed
 	if o.token.AccessToken != l.AccessToken {
-		_, err = o.validate(l)
+		_, err = o.validate(ctx, l)
 		if err != nil {
 			return nil, err
 		}
-		err = o.character.UpdateAccessToken(o.ctx, l.AccessToken)
+		err = o.character.UpdateAccessToken(ctx, l.AccessToken)
 		if err != nil {
 			return nil, err
 		}
 		o.token = l
+		o.sso.telemetry.obtained(ctx, l)
+		log.V(logEvents).Info("token refreshed", "expiry", l.Expiry)
+		o.sso.emit(ctx, eventRefreshed, Event{Character: o.character, Cause: CauseRefresh})
 	}
 	return o.token, nil
 }
 
+// refreshRemote takes the access token a RemoteRefresher hands out. It is
+// verified like any other; the process holding the refresh token deals with
+// rotation and deactivation.
+func (o *ssoTokenSource) refreshRemote(remote RemoteRefresher) (_ *oauth2.Token, err error) {
+	ctx, span := o.sso.telemetry.start(o.ctx, "evesso.refresh.remote", attribute.Int("evesso.character_id", int(o.character.GetCharacterID())))
+	defer func() { end(span, err) }()
+	token, err := remote.RemoteToken(ctx)
+	if err != nil {
+		o.sso.logger(ctx).Error(err, "remote token could not be obtained", append(characterFields(o.character), "error_class", errorClass(err))...)
+		return nil, err
+	}
+	if _, err = o.validate(ctx, token); err != nil {
+		return nil, err
+	}
+	o.token = token
+	return token, nil
+}
+
 func (o *ssoTokenSource) Valid() bool {
 	if _, err := o.Token(); err != nil {
 		return false
@@ -176,8 +237,10 @@ func (o *ssoTokenSource) Valid() bool {
 func (o *ssoTokenSource) Save(token *oauth2.Token, referenceData interface{}) error {
 	o.Lock()
 	defer o.Unlock()
-	jt, err := o.validate(token)
+	log := o.sso.logger(o.ctx).WithValues("profile_id", o.profileID)
+	jt, err := o.validate(o.ctx, token)
 	if err != nil {
+		log.Error(err, "saved token failed verification", "error_class", errorClass(err))
 		return err
 	}
 	claims, err := newCharacterClaims(jt)
@@ -188,23 +251,48 @@ func (o *ssoTokenSource) Save(token *oauth2.Token, referenceData interface{}) er
 	if err != nil {
 		return err
 	}
-	_, err = profile.CreateCharacter(o.ctx, claims, token, referenceData)
+	character, err := profile.CreateCharacter(o.ctx, claims, token, referenceData)
 	if err != nil {
+		log.Error(err, "saved character could not be stored", "character_id", claims.CharacterID(), "error_class", errorClass(err))
 		return err
 	}
 	o.token = token
+	log.V(logEvents).Info("character authorized", "cause", CauseSave, "character_id", claims.CharacterID(), "scope_count", len(claims.Scopes()))
+	o.sso.emit(o.ctx, eventAuthorized, Event{
+		Profile:       profile,
+		Character:     character,
+		ReferenceData: referenceData,
+		Cause:         CauseSave,
+	})
 	return nil
 }
 
 func (o *ssoTokenSource) AuthURL(referenceData interface{}) (string, error) {
+	return o.AuthURLWithReturn("", referenceData)
+}
+
+// AuthURLWithReturn is AuthURL with a URL the callback redirects to once the
+// authorization succeeds. {character_id} and {profile_id} in it are
+// substituted, and it must pass CheckReturnURL.
+func (o *ssoTokenSource) AuthURLWithReturn(returnURL string, referenceData interface{}) (string, error) {
+	if returnURL != "" {
+		if err := o.sso.CheckReturnURL(returnURL); err != nil {
+			return "", err
+		}
+	}
 	profile, err := o.store.GetProfile(o.ctx, o.profileID)
 	if err != nil {
 		return "", err
 	}
-	pkce, err := profile.CreatePKCE(o.ctx, referenceData, o.oauthConfig.Scopes...)
+	pkce, err := o.sso.CreatePKCE(o.ctx, profile, returnURL, referenceData, o.oauthConfig.Scopes...)
 	if err != nil {
 		return "", err
 	}
+	o.sso.logger(o.ctx).V(logDetail).Info("authorization started",
+		"profile_id", o.profileID,
+		"state", pkce.GetState(),
+		"scope_count", len(o.oauthConfig.Scopes),
+	)
 	return o.oauthConfig.AuthCodeURL(
 		pkce.GetState().String(),
 		oauth2.AccessTypeOffline,
@@ -0,0 +1,130 @@
+package evesso
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DSNValidator is implemented by a DataStore that can check a DSN without
+// connecting. New uses it to report a malformed DSN as a configuration error
+// rather than as a connection failure.
+type DSNValidator interface {
+	ValidateDSN(dsn string) error
+}
+
+// FieldError is a problem with one configuration key.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigError lists every invalid field of a Config.
+type ConfigError struct {
+	Fields []*FieldError
+}
+
+func (e *ConfigError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Error())
+	}
+	return "invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+func (e *ConfigError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		errs = append(errs, f)
+	}
+	return errs
+}
+
+func (e *ConfigError) add(field string, err error) {
+	e.Fields = append(e.Fields, &FieldError{Field: field, Err: err})
+}
+
+// Validate checks c and returns a *ConfigError naming each bad field, or nil.
+// It does not check the DSN; that needs the DataStore, see DSNValidator.
+func (c *Config) Validate() error {
+	if errs := c.validate(); len(errs.Fields) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (c *Config) validate() *ConfigError {
+	errs := new(ConfigError)
+	if c.Key == "" {
+		errs.add("key", errors.New("is required"))
+	}
+
+	callback, err := parseAbsoluteURL(c.Callback)
+	if err != nil {
+		errs.add("callback", err)
+	}
+	if c.Redirect != "" {
+		if _, err = parseAbsoluteURL(c.Redirect); err != nil {
+			errs.add("redirect", err)
+		}
+	}
+
+	for _, host := range c.ReturnHosts {
+		if host == "" || strings.ContainsAny(host, "/?#@") {
+			errs.add("returnhosts", fmt.Errorf("%q is not a host name", host))
+		}
+	}
+
+	switch {
+	case c.TLSCert == "" && c.TLSKey != "":
+		errs.add("tlscert", errors.New("is required when tlskey is set"))
+	case c.TLSCert != "" && c.TLSKey == "":
+		errs.add("tlskey", errors.New("is required when tlscert is set"))
+	case c.TLSCert != "":
+		if err = readable(c.TLSCert); err != nil {
+			errs.add("tlscert", err)
+		}
+		if err = readable(c.TLSKey); err != nil {
+			errs.add("tlskey", err)
+		}
+	}
+
+	if c.Autocert && callback != nil {
+		if callback.Scheme != "https" || (callback.Port() != "" && callback.Port() != "443") {
+			errs.add("autocert", fmt.Errorf("needs an https callback on port 443, not %s", callback.Host))
+		}
+	}
+	return errs
+}
+
+func parseAbsoluteURL(raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, errors.New("is required")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an absolute URL", raw)
+	}
+	return u, nil
+}
+
+func readable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,94 @@
+package evesso_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ferocious-space/evesso"
+	"github.com/ferocious-space/evesso/pkg/evessotest"
+)
+
+func TestValidate(t *testing.T) {
+	cert, key := tlsFiles(t)
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+	valid := func(change func(*evesso.Config)) *evesso.Config {
+		cfg := evessotest.Config()
+		if change != nil {
+			change(cfg)
+		}
+		return cfg
+	}
+	tests := []struct {
+		name string
+		cfg  *evesso.Config
+		// fields are the keys the error names, in order
+		fields []string
+	}{
+		{name: "valid", cfg: valid(nil)},
+		{name: "no redirect", cfg: valid(func(c *evesso.Config) { c.Redirect = "" })},
+		{name: "no key", cfg: valid(func(c *evesso.Config) { c.Key = "" }), fields: []string{"key"}},
+		{name: "no callback", cfg: valid(func(c *evesso.Config) { c.Callback = "" }), fields: []string{"callback"}},
+		{name: "relative callback", cfg: valid(func(c *evesso.Config) { c.Callback = "/callback" }), fields: []string{"callback"}},
+		{name: "unparsable redirect", cfg: valid(func(c *evesso.Config) { c.Redirect = "http://[::1" }), fields: []string{"redirect"}},
+		{name: "return host with a path", cfg: valid(func(c *evesso.Config) { c.ReturnHosts = []string{"example.com/x"} }), fields: []string{"returnhosts"}},
+		{name: "cert and key", cfg: valid(func(c *evesso.Config) { c.TLSCert, c.TLSKey = cert, key })},
+		{name: "cert without key", cfg: valid(func(c *evesso.Config) { c.TLSCert = cert }), fields: []string{"tlskey"}},
+		{name: "key without cert", cfg: valid(func(c *evesso.Config) { c.TLSKey = key }), fields: []string{"tlscert"}},
+		{name: "unreadable cert", cfg: valid(func(c *evesso.Config) { c.TLSCert, c.TLSKey = missing, key }), fields: []string{"tlscert"}},
+		{name: "autocert on 443", cfg: valid(func(c *evesso.Config) { c.Callback, c.Autocert = "https://sso.example.com/callback", true })},
+		{name: "autocert on another port", cfg: valid(func(c *evesso.Config) { c.Callback, c.Autocert = "https://sso.example.com:8443/callback", true }), fields: []string{"autocert"}},
+		{name: "autocert over http", cfg: valid(func(c *evesso.Config) { c.Autocert = true }), fields: []string{"autocert"}},
+		{
+			name:   "every field at once",
+			cfg:    &evesso.Config{Callback: "callback", Redirect: "redirect", TLSKey: key},
+			fields: []string{"key", "callback", "redirect", "tlscert"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var cfgErr *evesso.ConfigError
+			if !errors.As(err, &cfgErr) {
+				t.Fatalf("err = %v, want a *ConfigError", err)
+			}
+			var fields []string
+			for _, f := range cfgErr.Fields {
+				fields = append(fields, f.Field)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("fields = %q, want %q", fields, tt.fields)
+			}
+		})
+	}
+}
+
+// dsnStore is a store that rejects every DSN.
+type dsnStore struct {
+	*evessotest.Store
+}
+
+func (dsnStore) ValidateDSN(string) error {
+	return errors.New("malformed")
+}
+
+func TestNewValidates(t *testing.T) {
+	cfg := evessotest.Config()
+	cfg.Key = ""
+	_, err := evesso.New(context.Background(), evesso.WithConfig(cfg), evesso.WithStore(dsnStore{evessotest.NewStore()}))
+	var field *evesso.FieldError
+	if !errors.As(err, &field) || field.Field != "key" {
+		t.Fatalf("err = %v, want the key named", err)
+	}
+	if err.Error() != "invalid configuration: key: is required; dsn: malformed" {
+		t.Errorf("err = %q", err)
+	}
+}